@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/validator"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) listAddressesHandler(c echo.Context) error {
+	user := c.Get("user").(*data.User)
+
+	addresses, err := app.models.Addresses.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":   "Addresses returned successfully",
+		"addresses": addresses,
+	})
+}
+
+func (app *application) createAddressHandler(c echo.Context) error {
+	user := c.Get("user").(*data.User)
+
+	var input struct {
+		FullName   string `json:"full_name"`
+		Line1      string `json:"line1"`
+		Line2      string `json:"line2"`
+		City       string `json:"city"`
+		Region     string `json:"region"`
+		PostalCode string `json:"postal_code"`
+		Country    string `json:"country"`
+		Phone      string `json:"phone"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	address := &data.ShippingAddress{
+		UserID:     user.ID,
+		FullName:   input.FullName,
+		Line1:      input.Line1,
+		Line2:      input.Line2,
+		City:       input.City,
+		Region:     input.Region,
+		PostalCode: input.PostalCode,
+		Country:    strings.ToUpper(input.Country),
+		Phone:      input.Phone,
+	}
+
+	v := validator.New()
+
+	if data.ValidateAddress(v, address); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err := app.models.Addresses.Insert(address)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, envelope{
+		"message": "Address created successfully",
+		"address": address,
+	})
+}
+
+func (app *application) updateAddressHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	user := c.Get("user").(*data.User)
+
+	address, err := app.models.Addresses.Get(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Address not found")
+		default:
+			return err
+		}
+	}
+
+	var input struct {
+		FullName   *string `json:"full_name"`
+		Line1      *string `json:"line1"`
+		Line2      *string `json:"line2"`
+		City       *string `json:"city"`
+		Region     *string `json:"region"`
+		PostalCode *string `json:"postal_code"`
+		Country    *string `json:"country"`
+		Phone      *string `json:"phone"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	if input.FullName != nil {
+		address.FullName = *input.FullName
+	}
+	if input.Line1 != nil {
+		address.Line1 = *input.Line1
+	}
+	if input.Line2 != nil {
+		address.Line2 = *input.Line2
+	}
+	if input.City != nil {
+		address.City = *input.City
+	}
+	if input.Region != nil {
+		address.Region = *input.Region
+	}
+	if input.PostalCode != nil {
+		address.PostalCode = *input.PostalCode
+	}
+	if input.Country != nil {
+		address.Country = strings.ToUpper(*input.Country)
+	}
+	if input.Phone != nil {
+		address.Phone = *input.Phone
+	}
+
+	v := validator.New()
+
+	if data.ValidateAddress(v, address); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err = app.models.Addresses.Update(address)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return echo.NewHTTPError(http.StatusConflict, data.ErrEditConflict.Error())
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Address updated successfully",
+		"address": address,
+	})
+}
+
+func (app *application) deleteAddressHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	user := c.Get("user").(*data.User)
+
+	err = app.models.Addresses.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Address not found")
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Address deleted successfully",
+	})
+}
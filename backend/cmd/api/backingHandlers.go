@@ -5,14 +5,12 @@ import (
 	"fmt"
 	"net/http"
 	"projectx/internal/data"
+	"projectx/internal/payments"
 	"projectx/internal/validator"
+	"slices"
 	"strconv"
 	"time"
 
-	"github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/paymentintent"
-	"github.com/stripe/stripe-go/v72/refund"
-
 	"github.com/labstack/echo/v4"
 )
 
@@ -32,12 +30,21 @@ func (app *application) createPaymentIntentHandler(c echo.Context) error {
 		}
 	}
 
-	if time.Now().After(project.Deadline) {
+	late := time.Now().After(project.Deadline)
+	if late && !project.TakesLatePledges(time.Now()) {
 		return echo.NewHTTPError(http.StatusNotFound, "Project funding duration is closed")
 	}
 
+	if project.Status == "Cancelled" || project.Status == "Failed" {
+		return echo.NewHTTPError(http.StatusConflict, "Project is not taking pledges anymore")
+	}
+
 	var input struct {
-		Amount float64 `json:"amount"`
+		Amount    float64     `json:"amount"`
+		Rewards   []int       `json:"rewards"`
+		Variants  map[int]int `json:"variants"`
+		AddressID int         `json:"address_id"`
+		PromoCode string      `json:"promo_code"`
 	}
 	if err := c.Bind(&input); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
@@ -47,29 +54,95 @@ func (app *application) createPaymentIntentHandler(c echo.Context) error {
 
 	v := validator.New()
 
-	if data.ValidateAmount(v, input.Amount); !v.Valid() {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
-	}
+	data.ValidateAmount(v, input.Amount)
 
-	params := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(int64(input.Amount)),
-		Currency: stripe.String(string(stripe.CurrencyDZD)),
+	shipment, err := app.validatePledge(v, projectId, input.Amount, input.Rewards, input.Variants, nil, input.AddressID, backer.ID, false, late)
+	if err != nil {
+		return err
 	}
 
-	projectID := strconv.Itoa(projectId)
-	backerID := strconv.Itoa(backer.ID)
+	var promo *data.PromoCode
+	discount := 0.0
+	if input.PromoCode != "" {
+		promo, discount, err = app.applyPromoCode(v, projectId, input.PromoCode, input.Amount, input.Rewards, late)
+		if err != nil {
+			return err
+		}
+	}
 
-	params.AddMetadata("project_id", projectID)
-	params.AddMetadata("backer_id", backerID)
+	if !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
 
-	pi, err := paymentintent.New(params)
+	amount := input.Amount - discount + shipment.Total
+
+	// late pledges go to a project that already got its funding, so they are
+	// charged right away
+	pi, err := app.payments.CreateIntent(payments.IntentParams{
+		Amount:        int64(amount),
+		Currency:      "dzd",
+		ManualCapture: project.FundingModel == data.FundingAllOrNothing && !late,
+		Metadata: map[string]string{
+			"project_id":  strconv.Itoa(projectId),
+			"backer_id":   strconv.Itoa(backer.ID),
+			"reward_ids":  formatIDList(input.Rewards),
+			"variant_ids": formatVariants(input.Variants),
+			"address_id":  strconv.Itoa(input.AddressID),
+			"ip_address":  c.RealIP(),
+			"late":        strconv.FormatBool(late),
+			"promo_code":  normalizePromoCode(input.PromoCode),
+			"discount":    strconv.FormatFloat(discount, 'f', -1, 64),
+		},
+	})
 	if err != nil {
 		return err
 	}
 
+	if len(input.Rewards) > 0 {
+		err = app.models.Rewards.Reserve(projectId, backer.ID, pi.ID, input.Rewards, input.Variants, time.Now().Add(rewardReservationTTL))
+		if err != nil {
+			if _, cancelErr := app.payments.CancelIntent(pi.ID); cancelErr != nil {
+				app.logger.Error("cancelling payment intent failed", "transaction_id", pi.ID, "err", cancelErr.Error())
+			}
+
+			switch {
+			case errors.Is(err, data.ErrRewardSoldOut):
+				return echo.NewHTTPError(http.StatusConflict, err.Error())
+			case errors.Is(err, data.ErrNoRecordFound):
+				return echo.NewHTTPError(http.StatusNotFound, err.Error())
+			default:
+				return err
+			}
+		}
+	}
+
+	if promo != nil {
+		err = app.models.PromoCodes.Reserve(promo.ID, backer.ID, pi.ID, discount, time.Now().Add(rewardReservationTTL))
+		if err != nil {
+			if _, cancelErr := app.payments.CancelIntent(pi.ID); cancelErr != nil {
+				app.logger.Error("cancelling payment intent failed", "transaction_id", pi.ID, "err", cancelErr.Error())
+			}
+			if releaseErr := app.models.Rewards.ReleaseReservations(pi.ID); releaseErr != nil {
+				app.logger.Error("releasing reward reservations failed", "transaction_id", pi.ID, "err", releaseErr.Error())
+			}
+
+			switch {
+			case errors.Is(err, data.ErrPromoCodeExhausted):
+				return echo.NewHTTPError(http.StatusConflict, err.Error())
+			case errors.Is(err, data.ErrNoRecordFound):
+				return echo.NewHTTPError(http.StatusNotFound, "Promo code not found")
+			default:
+				return err
+			}
+		}
+	}
+
 	return c.JSON(http.StatusCreated, envelope{
 		"message":       "Backing intent is done successfully",
 		"client_secret": pi.ClientSecret,
+		"amount":        amount,
+		"shipping_fee":  shipment.Total,
+		"discount":      discount,
 	})
 }
 
@@ -89,74 +162,117 @@ func (app *application) recordBackingHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
 	}
 
-	pi, err := paymentintent.Get(input.PaymentIntentID, nil)
+	project, err := app.models.Projects.Get(projectId)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
+		default:
+			return err
+		}
+	}
+
+	if input.PaymentIntentID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Payment intent id is required")
+	}
+
+	pi, err := app.payments.GetIntent(input.PaymentIntentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, payments.ErrIntentNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Payment intent not found")
+		default:
+			return err
+		}
 	}
 
 	backer := c.Get("user").(*data.User)
 
-	backing := data.Backing{
-		BackerID:  backer.ID,
-		ProjectID: projectId,
+	if pi.Metadata["project_id"] != strconv.Itoa(projectId) || pi.Metadata["backer_id"] != strconv.Itoa(backer.ID) {
+		return echo.NewHTTPError(http.StatusForbidden, "This payment doesn't belong to this backing")
 	}
 
-	payment := data.Payment{
-		Amount:        float64(pi.Amount),
-		Status:        string(pi.Status),
-		TransactionID: input.PaymentIntentID,
-		PaymentMethod: input.PaymentMethod,
+	// the intent was started before the deadline or as a late pledge, either
+	// way the backer already paid for it
+	late := pi.Metadata["late"] == "true"
+	if time.Now().After(project.Deadline) && !late && !project.TakesLatePledges(time.Now()) {
+		return echo.NewHTTPError(http.StatusNotFound, "Project funding duration is closed")
+	}
+
+	expectedStatus := payments.StatusSucceeded
+	if project.FundingModel == data.FundingAllOrNothing && !late {
+		expectedStatus = payments.StatusRequiresCapture
 	}
 
-	err = app.models.Backing.Insert(&backing, &payment)
+	if pi.Status != expectedStatus {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("Payment is not completed (status: %s)", pi.Status))
+	}
+
+	v := validator.New()
+
+	intentRewards := parseIDList(pi.Metadata["reward_ids"])
+	v.Check(slices.Equal(sortedCopy(input.Rewards), sortedCopy(intentRewards)), "rewards", "Rewards don't match the ones selected when the payment was started")
+
+	addressID, _ := strconv.Atoi(pi.Metadata["address_id"])
+	variants := parseVariants(pi.Metadata["variant_ids"])
+
+	shipment, err := app.validatePledge(v, projectId, float64(pi.Amount)+intentDiscount(pi.Metadata), input.Rewards, variants, nil, addressID, backer.ID, true, late)
 	if err != nil {
 		return err
 	}
 
-	project, err := app.models.Projects.Get(projectId)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrNoRecordFound):
-			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
-		default:
-			return err
-		}
+	if !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
 	}
 
-	if time.Now().After(project.Deadline) {
-		return echo.NewHTTPError(http.StatusNotFound, "Project funding duration is closed")
+	backing := data.Backing{
+		BackerID:  backer.ID,
+		ProjectID: projectId,
+		IPAddress: c.RealIP(),
+		IsLate:    late,
 	}
 
-	project.CurrentFunding = project.CurrentFunding + (payment.Amount / 100)
+	payment := data.Payment{
+		Amount:        float64(pi.Amount),
+		Status:        pi.Status,
+		TransactionID: pi.ID,
+		PaymentMethod: input.PaymentMethod,
+	}
 
-	err = app.models.Projects.Update(project)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			return echo.NewHTTPError(http.StatusConflict, data.ErrEditConflict.Error())
-		default:
-			return err
-		}
+	rewards := &data.PledgedRewards{
+		RewardIDs: input.Rewards,
+		Variants:  variants,
+		Shipment:  shipment,
 	}
 
-	if input.Rewards != nil {
-		for i, rewardID := range input.Rewards {
-			_, err := app.models.Rewards.Get(rewardID)
-			if err != nil {
-				switch {
-				case errors.Is(err, data.ErrNoRecordFound):
-					return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Reward %d not found", i))
-				default:
-					return err
-				}
-			}
-			err = app.models.Rewards.InsertBackingReward(backing.BackingID, rewardID)
+	err = app.models.Backing.Insert(&backing, &payment, rewards)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateTransaction):
+			existingPayment, _, err := app.models.Backing.GetPaymentByTransactionID(input.PaymentIntentID)
 			if err != nil {
 				return err
 			}
+			return c.JSON(http.StatusConflict, envelope{
+				"message":        "This payment has already been recorded",
+				"backing_id":     existingPayment.BackingID,
+				"payment_id":     existingPayment.PaymentID,
+				"status":         existingPayment.Status,
+				"transaction_id": existingPayment.TransactionID,
+			})
+		case errors.Is(err, data.ErrRewardSoldOut), errors.Is(err, data.ErrNoRecordFound):
+			if giveBackErr := app.givePaymentBack(pi.ID, pi.Status); giveBackErr != nil {
+				return giveBackErr
+			}
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("%s, the payment was given back", err))
+		default:
+			return err
 		}
 	}
 
+	app.scoreBacking(backing.BackingID)
+	app.unlockStretchGoals(projectId)
+
 	app.background(func() {
 		data := map[string]interface{}{
 			"TransactionID":   payment.TransactionID,
@@ -253,104 +369,92 @@ func (app *application) refundHandler(c echo.Context) error {
 		}
 	}
 
+	policy, err := app.models.RefundPolicy.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := policy.Allows(project, time.Now()); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
 	var input struct {
-		Reason *string `json:"reason"`
+		Reason    *string `json:"reason"`
+		BackingID *int    `json:"backing_id"`
 	}
 
 	if err := c.Bind(&input); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
 	}
 
+	reason := ""
 	if input.Reason != nil {
-		v := validator.New()
+		reason = *input.Reason
+	}
 
-		if data.ValidateReason(v, *input.Reason); !v.Valid() {
-			return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
-		}
+	v := validator.New()
+
+	if data.ValidateReason(v, reason); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
 	}
 
-	backingID, err := app.models.Backing.GetBacking(backer.ID, id)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrNoRecordFound):
-			return echo.NewHTTPError(http.StatusNotFound, "This user didn't back this project")
-		default:
+	var backingID int
+
+	// without a backing id the latest backing of the project is refunded
+	if input.BackingID != nil {
+		backing, err := app.models.Backing.GetByID(*input.BackingID)
+		if err != nil && !errors.Is(err, data.ErrNoRecordFound) {
 			return err
 		}
-	}
-
-	paymentID, transactionID, err := app.models.Backing.CheckBacking(*backingID)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrNoRecordFound):
+		if backing == nil || backing.BackerID != backer.ID || backing.ProjectID != id {
 			return echo.NewHTTPError(http.StatusNotFound, "This user didn't back this project")
-		default:
-			return err
 		}
+		backingID = backing.BackingID
+	} else {
+		latest, err := app.models.Backing.GetBacking(backer.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrNoRecordFound):
+				return echo.NewHTTPError(http.StatusNotFound, "This user didn't back this project")
+			default:
+				return err
+			}
+		}
+		backingID = *latest
 	}
 
-	result, err := refund.New(&stripe.RefundParams{
-		PaymentIntent: stripe.String(*transactionID),
-	})
+	activePayments, err := app.models.Backing.GetActivePayments(backingID)
 	if err != nil {
 		return err
 	}
+	if len(activePayments) == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "This backing has already been refunded")
+	}
 
-	refundDate := time.Now()
-
-	originalBackingDate, err := app.models.Backing.Refund(*backingID, *input.Reason, *paymentID, refundDate)
+	cancellations, originalBackingDate, err := app.refundPayments(v, backingID, activePayments, 0, data.RefundBackerRequest, reason, nil)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecordFound):
 			return echo.NewHTTPError(http.StatusNotFound, "Payment not found")
-		case errors.Is(err, data.ErrEditConflict):
-			return echo.NewHTTPError(http.StatusConflict, data.ErrEditConflict.Error())
-		default:
-			return err
-		}
-	}
-
-	project.CurrentFunding = project.CurrentFunding - float64(result.Amount/100)
-
-	err = app.models.Projects.Update(project)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			return echo.NewHTTPError(http.StatusConflict, data.ErrEditConflict.Error())
+		case errors.Is(err, data.ErrRefundTooLarge):
+			remaining := 0.0
+			for _, payment := range activePayments {
+				remaining += payment.Amount - payment.RefundedAmount
+			}
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("Refund is more than the %.2f DA left on this backing", remaining/100))
+		case errors.Is(err, data.ErrEditConflict), errors.Is(err, payments.ErrInvalidState):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
 		default:
 			return err
 		}
 	}
 
-	isBackingWithRewards, err := app.models.Rewards.CheckBackingWithRewards(*backingID)
-	if err != nil {
-		return err
-	}
-
-	if isBackingWithRewards {
-		err = app.models.Rewards.DeleteBackingReward(*backingID)
-		if err != nil {
-			return err
-		}
-	}
+	app.sendRefundEmail(backer.Email, project.Title, activePayments[len(activePayments)-1].TransactionID, originalBackingDate, cancellations)
 
-	app.background(func() {
-		data := map[string]interface{}{
-			"RefundID":                result.ID,
-			"RefundDate":              refundDate,
-			"OriginalTransactionID":   transactionID,
-			"OriginalTransactionDate": originalBackingDate,
-			"PaymentMethod":           "card",
-			"ProjectName":             project.Title,
-			"RefundAmount":            result.Amount / 100,
-		}
-		err = app.mailer.Send(backer.Email, "refund.tmpl", data)
-		if err != nil {
-			c.Logger().Error(err)
-		}
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Backing refunded successfully",
+		"refunds": cancellations,
 	})
-
-	return c.JSON(http.StatusOK, envelope{"message": "Backing refunded successfully"})
 }
 
 func (app *application) deleteBackingHandler(c echo.Context) error {
@@ -427,3 +531,92 @@ func (app *application) getBackingRewardsHandler(c echo.Context) error {
 		"rewards": rewards,
 	})
 }
+
+// validatePledge checks the selected rewards and works out what shipping them
+// to the backer's address costs. held are the rewards the backing already has,
+// which stay its own even once sold out or retired. withShipping tells whether
+// amount already includes the shipping fees and late whether rewards go at
+// their late price.
+func (app *application) validatePledge(v *validator.Validator, projectID int, amount float64, rewardIDs []int, variants map[int]int, held []int, addressID, backerID int, withShipping, late bool) (*data.Shipment, error) {
+	rewards, err := app.models.Rewards.GetByIDs(rewardIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reward := range rewards {
+		if slices.Contains(held, reward.ID) {
+			reward.IsAvailable = true
+			for i := range reward.Variants {
+				reward.Variants[i].IsAvailable = true
+			}
+		}
+	}
+
+	var address *data.ShippingAddress
+	if addressID != 0 {
+		address, err = app.models.Addresses.Get(addressID, backerID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrNoRecordFound):
+				v.AddError("address_id", "Shipping address not found")
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	shipment := data.ValidateShipment(v, rewards, address)
+
+	if !withShipping {
+		amount += shipment.Total
+	}
+
+	data.ValidatePledge(v, projectID, amount, rewardIDs, variants, rewards, shipment.Total, late)
+
+	return shipment, nil
+}
+
+// givePaymentBack cancels or refunds a payment that can't be recorded and
+// releases what was held for it. A payment that was already given back is
+// left as is.
+func (app *application) givePaymentBack(transactionID, status string) error {
+	var err error
+	if status == payments.StatusRequiresCapture {
+		_, err = app.payments.CancelIntent(transactionID)
+	} else {
+		_, err = app.payments.Refund(payments.RefundParams{
+			IntentID:       transactionID,
+			IdempotencyKey: "give_back_" + transactionID,
+		})
+	}
+	if err != nil && !errors.Is(err, payments.ErrInvalidState) {
+		return err
+	}
+
+	err = app.models.Rewards.ReleaseReservations(transactionID)
+	if err != nil {
+		return err
+	}
+
+	return app.models.PromoCodes.ReleaseReservation(transactionID)
+}
+
+// releaseHold cancels an authorization hold. A hold that is already released
+// counts as released, so it can be asked for again after an interruption.
+func (app *application) releaseHold(transactionID string) (*payments.Intent, error) {
+	pi, err := app.payments.CancelIntent(transactionID)
+	if errors.Is(err, payments.ErrInvalidState) {
+		pi, err = app.payments.GetIntent(transactionID)
+		if err == nil && pi.Status != payments.StatusCanceled {
+			err = payments.ErrInvalidState
+		}
+	}
+
+	return pi, err
+}
+
+func sortedCopy(ids []int) []int {
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	return sorted
+}
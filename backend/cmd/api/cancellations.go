@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"projectx/internal/data"
+	"projectx/internal/validator"
+	"time"
+)
+
+const (
+	cancellationInterval  = time.Minute
+	cancellationBatchSize = 50
+	pendingRefundTimeout  = time.Minute
+)
+
+func (app *application) cancellationWorker(ctx context.Context) {
+	ticker := time.NewTicker(cancellationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.resumePendingRefunds()
+			app.processCancellations()
+		}
+	}
+}
+
+func (app *application) processCancellations() {
+	cancellations, err := app.models.Projects.GetUnfinishedCancellations()
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	for _, cancellation := range cancellations {
+		if err := app.refundCancelledProject(cancellation); err != nil {
+			app.logger.Error("refunding cancelled project failed", "project_id", cancellation.ProjectID, "err", err.Error())
+		}
+	}
+}
+
+// refundCancelledProject refunds the backers of a cancelled project in
+// batches. Every refund is recorded as soon as it's made, so a run that stops
+// halfway, or a payment the gateway refused, is picked up again on the next
+// tick. The cancellation only finishes once nothing is left to refund.
+func (app *application) refundCancelledProject(cancellation *data.ProjectCancellation) error {
+	project, err := app.models.Projects.Get(cancellation.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	reason := cancellation.Reason
+	if reason == "" {
+		reason = "The project was cancelled"
+	}
+
+	refunded, failed := 0, 0
+	afterID := 0
+
+	for {
+		payments, err := app.models.Backing.GetRefundablePayments(project.ID, afterID, cancellationBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, payment := range payments {
+			afterID = payment.PaymentID
+
+			err := app.refundCancelledPayment(project, payment, reason, cancellation.RequestedBy)
+			if err != nil {
+				app.logger.Error("refunding payment failed", "project_id", project.ID, "transaction_id", payment.TransactionID, "err", err.Error())
+				failed++
+				continue
+			}
+			refunded++
+		}
+
+		if len(payments) < cancellationBatchSize {
+			break
+		}
+	}
+
+	err = app.models.Projects.RecordCancellationProgress(project.ID, refunded, failed)
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return nil
+	}
+
+	err = app.models.Projects.FinishCancellation(project.ID)
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		return err
+	}
+
+	app.logger.Info("project cancellation finished", "project_id", project.ID, "type", cancellation.Type, "refunded", cancellation.RefundedCount+refunded)
+
+	return nil
+}
+
+func (app *application) refundCancelledPayment(project *data.Project, payment *data.RefundablePayment, reason string, refundedBy *int) error {
+	cancellations, originalBackingDate, err := app.refundPayments(validator.New(), payment.BackingID, []*data.Payment{&payment.Payment}, 0, data.RefundProjectCancelled, reason, refundedBy)
+	if err != nil {
+		return err
+	}
+
+	app.sendRefundEmail(payment.BackerEmail, project.Title, payment.TransactionID, originalBackingDate, cancellations)
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"projectx/internal/data"
+	"strings"
+)
+
+// scoreBacking runs the fraud rules over a freshly recorded backing. It runs in
+// the background, so a failure is only logged and never holds the backing up.
+func (app *application) scoreBacking(backingID int) {
+	app.background(func() {
+		facts, err := app.models.Backing.GetFraudFacts(backingID)
+		if err != nil {
+			app.logger.Error("gathering fraud facts failed", "backing_id", backingID, "err", err.Error())
+			return
+		}
+
+		score := data.ScoreBacking(facts)
+
+		err = app.models.Backing.SaveFraudScore(facts.ProjectID, score)
+		if err != nil {
+			app.logger.Error("saving fraud score failed", "backing_id", backingID, "err", err.Error())
+			return
+		}
+
+		if score.Flagged {
+			app.logger.Warn("backing flagged for review", "backing_id", backingID, "project_id", facts.ProjectID, "score", score.Score, "signals", strings.Join(score.Signals, ","))
+		}
+		if score.ProjectFlagged {
+			app.logger.Warn("project flagged as suspicious", "project_id", facts.ProjectID, "backing_id", backingID)
+		}
+	})
+}
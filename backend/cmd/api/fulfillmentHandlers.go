@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/validator"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) updateFulfillmentHandler(c echo.Context) error {
+	projectId, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	var input struct {
+		Updates []*data.FulfillmentUpdate `json:"updates"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	v := validator.New()
+
+	if data.ValidateFulfillmentUpdates(v, input.Updates); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	user := c.Get("user").(*data.User)
+
+	changes, err := app.models.Fulfillment.Update(projectId, user.ID, input.Updates)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		case errors.Is(err, data.ErrInvalidFulfillment):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		default:
+			return err
+		}
+	}
+
+	for _, change := range changes {
+		app.sendFulfillmentEmail(change)
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Fulfillment updated successfully",
+		"updates": changes,
+	})
+}
+
+func (app *application) confirmRewardReceiptHandler(c echo.Context) error {
+	backingId, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	var input struct {
+		RewardID int `json:"reward_id"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	user := c.Get("user").(*data.User)
+
+	change, err := app.models.Fulfillment.ConfirmReceipt(backingId, input.RewardID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Backed reward not found")
+		case errors.Is(err, data.ErrInvalidFulfillment):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		default:
+			return err
+		}
+	}
+
+	app.sendFulfillmentEmail(change)
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":     "Reward receipt confirmed successfully",
+		"fulfillment": change,
+	})
+}
+
+func (app *application) sendFulfillmentEmail(change *data.FulfillmentChange) {
+	app.background(func() {
+		data := map[string]interface{}{
+			"ProjectName":    change.ProjectTitle,
+			"RewardTitle":    change.RewardTitle,
+			"Status":         change.Status,
+			"StatusLabel":    strings.ReplaceAll(change.Status, "_", " "),
+			"Carrier":        change.Carrier,
+			"TrackingNumber": change.TrackingNumber,
+		}
+		err := app.mailer.Send(change.BackerEmail, "fulfillment.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"projectx/internal/data"
+)
+
+func (app *application) repairFundingDrift() ([]*data.FundingDrift, []*data.LedgerEntry, error) {
+	drifts, corrections, err := app.models.Ledger.RepairFundingDrift()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, entry := range corrections {
+		paymentID := 0
+		if entry.PaymentID != nil {
+			paymentID = *entry.PaymentID
+		}
+		app.logger.Info("funding drift corrected",
+			"entry_id", entry.EntryID,
+			"project_id", entry.ProjectID,
+			"payment_id", paymentID,
+			"entry_type", entry.EntryType,
+			"debit_account", entry.DebitAccount,
+			"credit_account", entry.CreditAccount,
+			"amount", entry.Amount,
+		)
+	}
+
+	return drifts, corrections, nil
+}
+
+// fundingCheckCommand runs as `api funding-check [-repair]`.
+func (app *application) fundingCheckCommand(args []string) error {
+	fs := flag.NewFlagSet("funding-check", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "Post ledger entries that correct the drift")
+	fs.Parse(args)
+
+	var drifts []*data.FundingDrift
+	var corrections []*data.LedgerEntry
+	var err error
+
+	if *repair {
+		drifts, corrections, err = app.repairFundingDrift()
+	} else {
+		drifts, err = app.models.Ledger.GetFundingDrift()
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(drifts) == 0 {
+		fmt.Println("No funding drift found")
+		return nil
+	}
+
+	for _, drift := range drifts {
+		fmt.Printf("project %d (%s):\tledger %d\texpected %d\tdrift %d\n", drift.ProjectID, drift.Title, drift.LedgerFunding, drift.ExpectedFunding, drift.Drift)
+		for _, payment := range drift.Payments {
+			if payment.PaymentID == nil {
+				fmt.Printf("\tdeleted payments:\trecorded %d\texpected %d\n", payment.Recorded, payment.Expected)
+				continue
+			}
+			fmt.Printf("\tpayment %d (%s, %s):\trecorded %d\texpected %d\n", *payment.PaymentID, payment.TransactionID, payment.Status, payment.Recorded, payment.Expected)
+		}
+	}
+
+	if *repair {
+		fmt.Printf("%d correcting entries posted\n", len(corrections))
+	}
+
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"os"
 	"projectx/internal/validator"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -57,6 +58,61 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	return strings.Split(csv, ",")
 }
 
+func formatIDList(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseIDList(s string) []int {
+	ids := []int{}
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(part)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// formatVariants writes the variant picked for each reward as
+// reward:variant pairs, for payment intent metadata.
+func formatVariants(variants map[int]int) string {
+	rewardIDs := make([]int, 0, len(variants))
+	for rewardID := range variants {
+		rewardIDs = append(rewardIDs, rewardID)
+	}
+	slices.Sort(rewardIDs)
+
+	parts := make([]string, len(rewardIDs))
+	for i, rewardID := range rewardIDs {
+		parts[i] = fmt.Sprintf("%d:%d", rewardID, variants[rewardID])
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseVariants(s string) map[int]int {
+	variants := map[int]int{}
+	for _, part := range strings.Split(s, ",") {
+		reward, variant, found := strings.Cut(part, ":")
+		if !found {
+			continue
+		}
+		rewardID, err := strconv.Atoi(reward)
+		if err != nil {
+			continue
+		}
+		variantID, err := strconv.Atoi(variant)
+		if err != nil {
+			continue
+		}
+		variants[rewardID] = variantID
+	}
+	return variants
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 	go func() {
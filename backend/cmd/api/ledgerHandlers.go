@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) getFundingDriftHandler(c echo.Context) error {
+	drifts, err := app.models.Ledger.GetFundingDrift()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Funding drift report generated successfully",
+		"drifts":  drifts,
+	})
+}
+
+func (app *application) repairFundingDriftHandler(c echo.Context) error {
+	drifts, corrections, err := app.repairFundingDrift()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":     "Funding drift repaired successfully",
+		"drifts":      drifts,
+		"corrections": corrections,
+	})
+}
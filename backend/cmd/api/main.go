@@ -12,6 +12,8 @@ import (
 	"os/signal"
 	"projectx/internal/data"
 	"projectx/internal/mailer"
+	"projectx/internal/payments"
+	"projectx/internal/secrets"
 	"strconv"
 	"sync"
 	"time"
@@ -21,7 +23,6 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	_ "github.com/lib/pq"
-	"github.com/stripe/stripe-go/v72"
 	"golang.org/x/time/rate"
 )
 
@@ -46,20 +47,43 @@ type smtp struct {
 	sender   string
 }
 
+type stripeConfig struct {
+	secretKey     string
+	webhookSecret string
+}
+
+type paymentsConfig struct {
+	gateway        string
+	payoutProvider string
+	encryptionKey  string
+}
+
+type feesConfig struct {
+	platformPercent   float64
+	processingPercent float64
+	processingFixed   int64
+}
+
 type config struct {
-	port    int
-	env     string
-	db      dbConfig
-	limiter rateLimitConfig
-	smtp    smtp
+	port     int
+	env      string
+	db       dbConfig
+	limiter  rateLimitConfig
+	smtp     smtp
+	stripe   stripeConfig
+	payments paymentsConfig
+	fees     feesConfig
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config   config
+	logger   *slog.Logger
+	models   data.Models
+	mailer   mailer.Mailer
+	payments payments.PaymentGateway
+	accounts payments.PayoutAccountProvider
+	secrets  *secrets.Box
+	wg       sync.WaitGroup
 }
 
 var (
@@ -119,7 +143,26 @@ func main() {
 	smtpSender := os.Getenv("SMTP_SENDER")
 
 	stripeSecretKey := os.Getenv("STRIPE_SECRET_KEY")
-	stripe.Key = stripeSecretKey
+	stripeWebhookSecret := os.Getenv("STRIPE_WEBHOOK_SECRET")
+
+	paymentGateway := os.Getenv("PAYMENT_GATEWAY")
+	if paymentGateway == "" {
+		paymentGateway = "stripe"
+	}
+	payoutProvider := os.Getenv("PAYOUT_PROVIDER")
+	if payoutProvider == "" {
+		payoutProvider = paymentGateway
+	}
+
+	platformFeePercent, err := strconv.ParseFloat(os.Getenv("PLATFORM_FEE_PERCENT"), 64)
+	if err != nil {
+		platformFeePercent = 5
+	}
+	processingFeePercent, err := strconv.ParseFloat(os.Getenv("PROCESSING_FEE_PERCENT"), 64)
+	if err != nil {
+		processingFeePercent = 2.9
+	}
+	processingFeeFixed, _ := strconv.ParseInt(os.Getenv("PROCESSING_FEE_FIXED"), 10, 64)
 
 	cfg := config{
 		port: realPort,
@@ -141,6 +184,20 @@ func main() {
 			password: smtpPassword,
 			sender:   smtpSender,
 		},
+		stripe: stripeConfig{
+			secretKey:     stripeSecretKey,
+			webhookSecret: stripeWebhookSecret,
+		},
+		payments: paymentsConfig{
+			gateway:        paymentGateway,
+			payoutProvider: payoutProvider,
+			encryptionKey:  os.Getenv("PAYOUT_ENCRYPTION_KEY"),
+		},
+		fees: feesConfig{
+			platformPercent:   platformFeePercent,
+			processingPercent: processingFeePercent,
+			processingFixed:   processingFeeFixed,
+		},
 	}
 	flag.StringVar(&cfg.env, "env", "development", "Environment(development|staging|production)")
 	flag.Parse()
@@ -212,11 +269,58 @@ func main() {
 
 	logger.Info("database connection pool established")
 
+	var gateway payments.PaymentGateway
+	switch cfg.payments.gateway {
+	case "stripe":
+		gateway = payments.NewStripeGateway(cfg.stripe.secretKey)
+	case "fake":
+		gateway = payments.NewFakeGateway()
+		logger.Warn("using the in-memory payment gateway, no real payments will be made")
+	default:
+		logger.Error("unknown payment gateway", "gateway", cfg.payments.gateway)
+		os.Exit(1)
+	}
+
+	var accounts payments.PayoutAccountProvider
+	switch cfg.payments.payoutProvider {
+	case "stripe":
+		accounts = payments.NewStripeAccountProvider(cfg.stripe.secretKey)
+	case "fake":
+		accounts = payments.NewFakeAccountProvider()
+		logger.Warn("using the in-memory payout account provider, payout accounts are verified right away")
+	default:
+		logger.Error("unknown payout account provider", "provider", cfg.payments.payoutProvider)
+		os.Exit(1)
+	}
+
+	// without a key payout accounts can't be saved, everything else still runs
+	var box *secrets.Box
+	if cfg.payments.encryptionKey != "" {
+		box, err = secrets.NewBox(cfg.payments.encryptionKey)
+		if err != nil {
+			logger.Error("invalid PAYOUT_ENCRYPTION_KEY", "err", err.Error())
+			os.Exit(1)
+		}
+	} else {
+		logger.Warn("PAYOUT_ENCRYPTION_KEY is not set, payout accounts can't be saved")
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db),
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		payments: gateway,
+		accounts: accounts,
+		secrets:  box,
+	}
+
+	if flag.Arg(0) == "funding-check" {
+		if err := app.fundingCheckCommand(flag.Args()[1:]); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 
 	e.Use(echoprometheus.NewMiddleware("myapp"))
@@ -232,6 +336,13 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
+	go app.settlementWorker(ctx)
+	go app.reservationWorker(ctx)
+	go app.payoutWorker(ctx)
+	go app.cancellationWorker(ctx)
+	go app.surveyReminderWorker(ctx)
+	go app.membershipWorker(ctx)
+
 	go func() {
 		if err := e.Start(fmt.Sprintf(":%d", cfg.port)); err != nil && err != http.ErrServerClosed {
 			e.Logger.Fatal("shutting down the server")
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/payments"
+	"projectx/internal/validator"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) createMembershipTierHandler(c echo.Context) error {
+	user := c.Get("user").(*data.User)
+
+	completed, err := app.models.Memberships.HasCompletedProject(user.ID)
+	if err != nil {
+		return err
+	}
+	if !completed {
+		return echo.NewHTTPError(http.StatusForbidden, "Memberships are only available to creators with a completed project")
+	}
+
+	var input struct {
+		Title       string  `json:"title"`
+		Description string  `json:"description"`
+		Amount      float64 `json:"amount"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	tier := &data.MembershipTier{
+		CreatorID:   user.ID,
+		Title:       input.Title,
+		Description: input.Description,
+		Amount:      input.Amount,
+	}
+
+	v := validator.New()
+	if data.ValidateMembershipTier(v, tier); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	tier.PlanID, err = app.payments.CreatePlan(payments.PlanParams{
+		Name:     user.Username + " - " + tier.Title,
+		Amount:   int64(tier.Amount),
+		Currency: "dzd",
+	})
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Memberships.InsertTier(tier)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, envelope{
+		"message": "Membership tier created successfully",
+		"tier":    tier,
+	})
+}
+
+func (app *application) getMembershipTiersHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	tiers, err := app.models.Memberships.GetTiers(id, true)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Membership tiers returned successfully",
+		"tiers":   tiers,
+	})
+}
+
+func (app *application) getMyMembershipTiersHandler(c echo.Context) error {
+	user := c.Get("user").(*data.User)
+
+	tiers, err := app.models.Memberships.GetTiers(user.ID, false)
+	if err != nil {
+		return err
+	}
+
+	members, err := app.models.Memberships.GetAllForCreator(user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Membership tiers returned successfully",
+		"tiers":   tiers,
+		"members": members,
+	})
+}
+
+// updateMembershipTierHandler changes how the tier is presented, or closes it
+// to new members. Its amount can't change since current members are billed
+// for it.
+func (app *application) updateMembershipTierHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	user := c.Get("user").(*data.User)
+
+	tier, err := app.models.Memberships.GetTier(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Membership tier not found")
+		default:
+			return err
+		}
+	}
+
+	if tier.CreatorID != user.ID {
+		return echo.NewHTTPError(http.StatusForbidden, data.ErrActionsForbidden.Error())
+	}
+
+	var input struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		IsActive    *bool   `json:"is_active"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	if input.Title != nil {
+		tier.Title = *input.Title
+	}
+	if input.Description != nil {
+		tier.Description = *input.Description
+	}
+	if input.IsActive != nil {
+		tier.IsActive = *input.IsActive
+	}
+
+	v := validator.New()
+	if data.ValidateMembershipTier(v, tier); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err = app.models.Memberships.UpdateTier(tier)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return echo.NewHTTPError(http.StatusConflict, data.ErrEditConflict.Error())
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Membership tier updated successfully",
+		"tier":    tier,
+	})
+}
+
+// joinMembershipHandler subscribes the user to the tier. When the first month
+// still has to be paid, the returned client secret confirms it.
+func (app *application) joinMembershipHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	user := c.Get("user").(*data.User)
+
+	tier, err := app.models.Memberships.GetTier(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Membership tier not found")
+		default:
+			return err
+		}
+	}
+
+	if !tier.IsActive {
+		return echo.NewHTTPError(http.StatusConflict, "This membership tier doesn't take new members")
+	}
+	if tier.CreatorID == user.ID {
+		return echo.NewHTTPError(http.StatusForbidden, "You can't join your own membership")
+	}
+
+	member, err := app.models.Memberships.IsMember(user.ID, tier.CreatorID)
+	if err != nil {
+		return err
+	}
+	if member {
+		return echo.NewHTTPError(http.StatusConflict, data.ErrAlreadyMember.Error())
+	}
+
+	subscription, err := app.payments.CreateSubscription(payments.SubscriptionParams{
+		PlanID: tier.PlanID,
+		Email:  user.Email,
+		Metadata: map[string]string{
+			"tier_id":   strconv.Itoa(tier.ID),
+			"member_id": strconv.Itoa(user.ID),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	membership := &data.Membership{
+		TierID:         tier.ID,
+		TierTitle:      tier.Title,
+		Amount:         tier.Amount,
+		CreatorID:      tier.CreatorID,
+		MemberID:       user.ID,
+		MemberEmail:    user.Email,
+		SubscriptionID: subscription.ID,
+		Status:         membershipStatus(subscription),
+	}
+	if !subscription.CurrentPeriodEnd.IsZero() {
+		membership.CurrentPeriodEnd = &subscription.CurrentPeriodEnd
+	}
+
+	err = app.models.Memberships.Insert(membership, membershipPayment(subscription))
+	if err != nil {
+		if _, cancelErr := app.payments.CancelSubscription(subscription.ID, false); cancelErr != nil {
+			app.logger.Error("canceling unrecorded subscription failed", "subscription_id", subscription.ID, "err", cancelErr.Error())
+		}
+
+		switch {
+		case errors.Is(err, data.ErrAlreadyMember):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		default:
+			return err
+		}
+	}
+
+	response := envelope{
+		"message":    "Membership created successfully",
+		"membership": membership,
+	}
+	if membership.Status == data.MembershipIncomplete {
+		response["client_secret"] = subscription.ClientSecret
+	}
+
+	return c.JSON(http.StatusCreated, response)
+}
+
+func (app *application) getMyMembershipsHandler(c echo.Context) error {
+	user := c.Get("user").(*data.User)
+
+	memberships, err := app.models.Memberships.GetAllForMember(user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":     "Memberships returned successfully",
+		"memberships": memberships,
+	})
+}
+
+// cancelMembershipHandler stops the billing at the end of the paid month, the
+// member keeps their perks until then.
+func (app *application) cancelMembershipHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	user := c.Get("user").(*data.User)
+
+	membership, err := app.models.Memberships.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Membership not found")
+		default:
+			return err
+		}
+	}
+
+	if membership.MemberID != user.ID {
+		return echo.NewHTTPError(http.StatusNotFound, "Membership not found")
+	}
+	if membership.Status == data.MembershipCancelled || membership.CancelAtPeriodEnd {
+		return echo.NewHTTPError(http.StatusConflict, "This membership is already cancelled")
+	}
+
+	subscription, err := app.payments.CancelSubscription(membership.SubscriptionID, membership.Status != data.MembershipIncomplete)
+	if err != nil {
+		switch {
+		case errors.Is(err, payments.ErrInvalidState):
+			return echo.NewHTTPError(http.StatusConflict, "This membership is already cancelled")
+		default:
+			return err
+		}
+	}
+
+	err = app.recordSubscription(membership, subscription)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":    "Membership cancelled successfully",
+		"membership": membership,
+	})
+}
+
+// getMembersOnlyUpdatesHandler lists the creator's members-only updates, for
+// their members and themselves.
+func (app *application) getMembersOnlyUpdatesHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	user := c.Get("user").(*data.User)
+
+	if user.ID != id {
+		member, err := app.models.Memberships.IsMember(user.ID, id)
+		if err != nil {
+			return err
+		}
+		if !member {
+			return echo.NewHTTPError(http.StatusForbidden, "Only members can see these updates")
+		}
+	}
+
+	var input struct {
+		data.Filter
+	}
+
+	v := validator.New()
+
+	input.Page = app.readInt(c.QueryParams(), "page", 1, v)
+	input.PageSize = app.readInt(c.QueryParams(), "page_size", 5, v)
+
+	v.Check(input.Page >= 1 && input.PageSize <= 10_000_000, "page", "page must be between 1 and 10000000")
+	v.Check(input.PageSize >= 1 && input.PageSize <= 100, "page_size", "page size must be between 1 and 100")
+
+	if !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	updates, metadata, err := app.models.Updates.GetMembersOnlyUpdates(id, input.Filter)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":  "Updates returned successfully",
+		"metadata": metadata,
+		"updates":  updates,
+	})
+}
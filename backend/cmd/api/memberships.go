@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"projectx/internal/data"
+	"projectx/internal/payments"
+	"time"
+)
+
+const (
+	membershipSyncInterval = time.Hour
+	membershipGracePeriod  = 7 * 24 * time.Hour
+)
+
+// membershipWorker keeps the memberships in line with their subscriptions,
+// for when the gateway's webhooks were missed.
+func (app *application) membershipWorker(ctx context.Context) {
+	ticker := time.NewTicker(membershipSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.syncDueMemberships()
+		}
+	}
+}
+
+func (app *application) syncDueMemberships() {
+	memberships, err := app.models.Memberships.GetDue()
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	for _, membership := range memberships {
+		if err := app.syncMembership(membership); err != nil {
+			app.logger.Error("syncing membership failed", "membership_id", membership.ID, "err", err.Error())
+		}
+	}
+}
+
+func membershipStatus(subscription *payments.Subscription) string {
+	switch subscription.Status {
+	case payments.SubscriptionActive:
+		return data.MembershipActive
+	case payments.SubscriptionPastDue:
+		return data.MembershipPastDue
+	case payments.SubscriptionIncomplete:
+		return data.MembershipIncomplete
+	default:
+		return data.MembershipCancelled
+	}
+}
+
+func membershipPayment(subscription *payments.Subscription) *data.MembershipPayment {
+	if subscription.LatestInvoice == nil || !subscription.LatestInvoice.Paid {
+		return nil
+	}
+
+	return &data.MembershipPayment{
+		InvoiceID: subscription.LatestInvoice.ID,
+		Amount:    subscription.LatestInvoice.Amount,
+	}
+}
+
+// syncMembership records the state of the membership's subscription and the
+// payment of its latest month. Members whose payment is still failing after
+// membershipGracePeriod lose their membership.
+func (app *application) syncMembership(membership *data.Membership) error {
+	subscription, err := app.payments.GetSubscription(membership.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	if subscription.Status == payments.SubscriptionPastDue && membership.PastDueSince != nil && time.Since(*membership.PastDueSince) > membershipGracePeriod {
+		subscription, err = app.payments.CancelSubscription(membership.SubscriptionID, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.recordSubscription(membership, subscription)
+}
+
+func (app *application) recordSubscription(membership *data.Membership, subscription *payments.Subscription) error {
+	previous := membership.Status
+	status := membershipStatus(subscription)
+
+	var periodEnd *time.Time
+	if !subscription.CurrentPeriodEnd.IsZero() {
+		periodEnd = &subscription.CurrentPeriodEnd
+	}
+
+	err := app.models.Memberships.Sync(membership, status, periodEnd, subscription.CancelAtPeriodEnd, membershipPayment(subscription))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	switch {
+	case status == data.MembershipPastDue && previous != data.MembershipPastDue:
+		app.sendMembershipPastDueEmail(membership, false)
+	case status == data.MembershipCancelled && previous == data.MembershipPastDue:
+		app.sendMembershipPastDueEmail(membership, true)
+	}
+
+	return nil
+}
+
+func (app *application) sendMembershipPastDueEmail(membership *data.Membership, cancelled bool) {
+	app.background(func() {
+		err := app.mailer.Send(membership.MemberEmail, "membership_past_due.tmpl", map[string]interface{}{
+			"TierTitle": membership.TierTitle,
+			"Amount":    membership.Amount,
+			"GraceDays": int(membershipGracePeriod.Hours() / 24),
+			"Cancelled": cancelled,
+		})
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/payments"
+	"projectx/internal/validator"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) getPayoutAccountHandler(c echo.Context) error {
+	user := c.Get("user").(*data.User)
+
+	account, err := app.models.PayoutAccounts.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "No payout account was set up yet")
+		default:
+			return err
+		}
+	}
+
+	if account.Status == data.PayoutAccountPending {
+		if err := app.syncPayoutAccount(account); err != nil {
+			app.logger.Error("refreshing payout account failed", "user_id", user.ID, "err", err.Error())
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":        "Payout account returned successfully",
+		"payout_account": account,
+	})
+}
+
+// savePayoutAccountHandler onboards the creator with the payout provider, or
+// sends the provider their new details. The bank account number can be left
+// out to keep the current one.
+func (app *application) savePayoutAccountHandler(c echo.Context) error {
+	if app.secrets == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Payout accounts can't be saved right now")
+	}
+
+	user := c.Get("user").(*data.User)
+
+	var input struct {
+		LegalName         string `json:"legal_name"`
+		Country           string `json:"country"`
+		Currency          string `json:"currency"`
+		AccountHolderName string `json:"account_holder_name"`
+		AccountNumber     string `json:"account_number"`
+		RoutingNumber     string `json:"routing_number"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	current, err := app.models.PayoutAccounts.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrNoRecordFound) {
+		return err
+	}
+
+	accountNumber := normalizeBankNumber(input.AccountNumber)
+	routingNumber := normalizeBankNumber(input.RoutingNumber)
+
+	if current != nil && accountNumber == "" {
+		accountNumber, err = app.secrets.Decrypt(current.AccountNumberEncrypted)
+		if err != nil {
+			return err
+		}
+		if routingNumber == "" {
+			routingNumber, err = app.secrets.Decrypt(current.RoutingNumberEncrypted)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	account := &data.PayoutAccount{
+		UserID:            user.ID,
+		LegalName:         strings.TrimSpace(input.LegalName),
+		Country:           strings.ToUpper(input.Country),
+		Currency:          strings.ToUpper(input.Currency),
+		AccountHolderName: strings.TrimSpace(input.AccountHolderName),
+	}
+
+	v := validator.New()
+
+	if data.ValidatePayoutAccount(v, account, accountNumber, routingNumber); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	params := payments.AccountParams{
+		Email:     user.Email,
+		LegalName: account.LegalName,
+		Country:   account.Country,
+		Bank: payments.BankAccount{
+			HolderName:    account.AccountHolderName,
+			AccountNumber: accountNumber,
+			RoutingNumber: routingNumber,
+			Country:       account.Country,
+			Currency:      account.Currency,
+		},
+		TOSAcceptedIP: c.RealIP(),
+	}
+
+	var remote *payments.Account
+	if current == nil || current.Country != account.Country {
+		// the country of a connected account can't change
+		remote, err = app.accounts.CreateAccount(params)
+	} else {
+		remote, err = app.accounts.UpdateAccount(current.ProviderAccountID, params)
+	}
+	if err != nil {
+		app.logger.Error("onboarding payout account failed", "user_id", user.ID, "err", err.Error())
+		return echo.NewHTTPError(http.StatusBadGateway, "The payout provider refused these details, please check them and try again")
+	}
+
+	account.ProviderAccountID = remote.ID
+	account.Status = remote.Status
+	account.StatusReason = remote.StatusReason
+	account.AccountLast4 = accountNumber[len(accountNumber)-4:]
+
+	account.AccountNumberEncrypted, err = app.secrets.Encrypt(accountNumber)
+	if err != nil {
+		return err
+	}
+	account.RoutingNumberEncrypted, err = app.secrets.Encrypt(routingNumber)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.PayoutAccounts.Save(account)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":        "Payout account saved successfully",
+		"payout_account": account,
+	})
+}
+
+// syncPayoutAccount records the verification status the provider has for the
+// account, if it changed.
+func (app *application) syncPayoutAccount(account *data.PayoutAccount) error {
+	remote, err := app.accounts.GetAccount(account.ProviderAccountID)
+	if err != nil {
+		return err
+	}
+
+	if remote.Status == account.Status && remote.StatusReason == account.StatusReason {
+		return nil
+	}
+
+	err = app.models.PayoutAccounts.UpdateStatus(account, remote.Status, remote.StatusReason)
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("payout account status changed", "user_id", account.UserID, "status", account.Status, "reason", account.StatusReason)
+
+	return nil
+}
+
+// requireVerifiedPayoutAccount keeps projects whose creator can't be paid out
+// from being approved.
+func (app *application) requireVerifiedPayoutAccount(creatorID int) error {
+	verified, err := app.models.PayoutAccounts.IsVerified(creatorID)
+	if err != nil {
+		return err
+	}
+
+	if !verified {
+		return echo.NewHTTPError(http.StatusConflict, "The creator's payout account must be verified before the project can be approved")
+	}
+
+	return nil
+}
+
+func normalizeBankNumber(number string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(number), ""))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/validator"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) getMyPayoutsHandler(c echo.Context) error {
+	user := c.Get("user").(*data.User)
+
+	payouts, err := app.models.Payouts.GetAllForCreator(user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Payouts returned successfully",
+		"payouts": payouts,
+	})
+}
+
+func (app *application) getPayoutsHandler(c echo.Context) error {
+	status := c.QueryParam("status")
+
+	v := validator.New()
+
+	if v.Check(status == "" || validator.In(status, data.PayoutStatuses...), "status", "Invalid payout status"); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	payouts, err := app.models.Payouts.GetAll(status)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Payouts returned successfully",
+		"payouts": payouts,
+	})
+}
+
+func (app *application) approvePayoutHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	var input struct {
+		ScheduledAt *time.Time `json:"scheduled_at"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	scheduledAt := time.Now()
+	if input.ScheduledAt != nil {
+		scheduledAt = *input.ScheduledAt
+	}
+
+	admin := c.Get("user").(*data.User)
+
+	payout, err := app.models.Payouts.Approve(id, admin.ID, scheduledAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Payout not found")
+		case errors.Is(err, data.ErrInvalidPayoutState):
+			return echo.NewHTTPError(http.StatusConflict, "Only pending payouts can be approved")
+		case errors.Is(err, data.ErrOpenDisputes):
+			return echo.NewHTTPError(http.StatusConflict, "Project has open disputes, payout is blocked until they are closed")
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Payout approved successfully",
+		"payout":  payout,
+	})
+}
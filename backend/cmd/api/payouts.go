@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"projectx/internal/data"
+	"time"
+)
+
+const payoutInterval = 10 * time.Minute
+
+func (app *application) payoutWorker(ctx context.Context) {
+	ticker := time.NewTicker(payoutInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.createPendingPayouts()
+			app.payDuePayouts()
+		}
+	}
+}
+
+func (app *application) feeSchedule() data.FeeSchedule {
+	return data.FeeSchedule{
+		PlatformPercent:   app.config.fees.platformPercent,
+		ProcessingPercent: app.config.fees.processingPercent,
+		ProcessingFixed:   app.config.fees.processingFixed,
+	}
+}
+
+func (app *application) createPendingPayouts() {
+	created, err := app.models.Payouts.CreatePending(app.feeSchedule())
+	for _, payout := range created {
+		app.logger.Info("payout created", "payout_id", payout.PayoutID, "project_id", payout.ProjectID, "net", payout.Net)
+	}
+	if err != nil {
+		app.logger.Error("creating payouts failed", "err", err.Error())
+	}
+}
+
+// payDuePayouts pays out every scheduled payout whose date has come. Payouts of
+// projects with open disputes, or whose creator has no verified payout account,
+// stay scheduled until that's sorted out.
+func (app *application) payDuePayouts() {
+	payouts, err := app.models.Payouts.GetDue()
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	for _, due := range payouts {
+		verified, err := app.models.PayoutAccounts.IsVerified(due.CreatorID)
+		if err != nil {
+			app.logger.Error(err.Error())
+			continue
+		}
+		if !verified {
+			app.logger.Warn("payout held back until the creator's payout account is verified", "payout_id", due.PayoutID, "project_id", due.ProjectID)
+			continue
+		}
+
+		payout, err := app.models.Payouts.Pay(due.PayoutID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrOpenDisputes):
+				app.logger.Warn("payout held back by open disputes", "payout_id", due.PayoutID, "project_id", due.ProjectID)
+			case errors.Is(err, data.ErrInvalidPayoutState):
+			default:
+				app.logger.Error("paying out failed", "payout_id", due.PayoutID, "err", err.Error())
+			}
+			continue
+		}
+
+		if payout.Status == data.PayoutFailed {
+			app.logger.Warn("payout failed", "payout_id", payout.PayoutID, "project_id", payout.ProjectID, "reason", *payout.FailureReason)
+			continue
+		}
+
+		app.logger.Info("payout paid", "payout_id", payout.PayoutID, "project_id", payout.ProjectID, "net", payout.Net)
+	}
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/payments"
+	"projectx/internal/validator"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// errPledgeChangeInvalid is returned for a raise whose new pledge isn't valid
+// anymore once paid for, a reward's price having gone up for instance.
+var errPledgeChangeInvalid = errors.New("the pledge change is not valid anymore")
+
+func (app *application) managePledgeHandler(c echo.Context) error {
+	projectId, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	project, err := app.models.Projects.Get(projectId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
+		default:
+			return err
+		}
+	}
+
+	if project.Status != "Live" || time.Now().After(project.Deadline) {
+		return echo.NewHTTPError(http.StatusConflict, "Pledges can only be changed while the project is live")
+	}
+
+	var input struct {
+		Amount    float64     `json:"amount"`
+		Rewards   []int       `json:"rewards"`
+		Variants  map[int]int `json:"variants"`
+		AddressID int         `json:"address_id"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	backer := c.Get("user").(*data.User)
+
+	backingID, err := app.models.Backing.GetBacking(backer.ID, projectId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "This user didn't back this project")
+		default:
+			return err
+		}
+	}
+
+	pending, err := app.models.Backing.GetPendingPledgeChange(*backingID)
+	if err != nil && !errors.Is(err, data.ErrNoRecordFound) {
+		return err
+	}
+	if pending != nil && pending.TransactionID == nil {
+		// a lowering that was interrupted while giving the money back, it's
+		// finished before the pledge can change again
+		if err := app.resumePledgeLowering(pending); err != nil {
+			app.logger.Error("resuming pledge lowering failed", "history_id", pending.HistoryID, "err", err.Error())
+			return echo.NewHTTPError(http.StatusConflict, "A previous pledge change is still being processed")
+		}
+		pending = nil
+	}
+	if pending != nil {
+		if time.Since(pending.CreatedAt) < rewardReservationTTL {
+			return echo.NewHTTPError(http.StatusConflict, "A pledge change is already waiting for payment")
+		}
+
+		// the backer never paid for the previous change, drop it before
+		// starting a new one
+		if _, err := app.payments.CancelIntent(*pending.TransactionID); err != nil {
+			return echo.NewHTTPError(http.StatusConflict, "A pledge change is already waiting for payment")
+		}
+		if err := app.models.Backing.CancelPledgeChange(*pending.TransactionID); err != nil && !errors.Is(err, data.ErrNoRecordFound) {
+			return err
+		}
+		if err := app.models.Rewards.ReleaseReservations(*pending.TransactionID); err != nil {
+			return err
+		}
+	}
+
+	activePayments, err := app.models.Backing.GetActivePayments(*backingID)
+	if err != nil {
+		return err
+	}
+	if len(activePayments) == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "This backing has no payment left to change")
+	}
+
+	currentVariants, err := app.models.Backing.GetRewardVariants(*backingID)
+	if err != nil {
+		return err
+	}
+
+	// rewards that are kept keep the variant they were pledged with
+	variants := map[int]int{}
+	for rewardID, variantID := range input.Variants {
+		variants[rewardID] = variantID
+	}
+	for rewardID, variantID := range currentVariants {
+		if slices.Contains(input.Rewards, rewardID) {
+			variants[rewardID] = variantID
+		}
+	}
+
+	oldRewards, err := app.models.Backing.GetRewardIDs(*backingID)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+
+	data.ValidateAmount(v, input.Amount)
+
+	shipment, err := app.validatePledge(v, projectId, input.Amount, input.Rewards, variants, oldRewards, input.AddressID, backer.ID, false, false)
+	if err != nil {
+		return err
+	}
+
+	if !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	var current float64
+	for _, payment := range activePayments {
+		current += payment.Amount - payment.RefundedAmount
+	}
+
+	newAmount := input.Amount + shipment.Total
+	newRewards := sortedCopy(input.Rewards)
+
+	change := &data.PledgeChange{
+		BackingID:   *backingID,
+		ProjectID:   projectId,
+		BackerID:    backer.ID,
+		OldAmount:   current,
+		NewAmount:   newAmount,
+		OldRewards:  oldRewards,
+		NewRewards:  newRewards,
+		NewVariants: variants,
+	}
+	if input.AddressID != 0 {
+		change.AddressID = &input.AddressID
+	}
+
+	diff := newAmount - current
+	rewardsChanged := len(data.Difference(oldRewards, newRewards)) > 0 || len(data.Difference(newRewards, oldRewards)) > 0
+
+	if diff == 0 && !rewardsChanged {
+		v.AddError("amount", "Pledge is already set to this amount and these rewards")
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	if diff > 0 {
+		pi, err := app.payments.CreateIntent(payments.IntentParams{
+			Amount:        int64(diff),
+			Currency:      "dzd",
+			ManualCapture: project.FundingModel == data.FundingAllOrNothing,
+			Metadata: map[string]string{
+				"project_id":    strconv.Itoa(projectId),
+				"backer_id":     strconv.Itoa(backer.ID),
+				"backing_id":    strconv.Itoa(*backingID),
+				"pledge_change": "true",
+				"reward_ids":    formatIDList(newRewards),
+				"variant_ids":   formatVariants(variants),
+				"address_id":    strconv.Itoa(input.AddressID),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		if added := data.Difference(newRewards, oldRewards); len(added) > 0 {
+			err = app.models.Rewards.Reserve(projectId, backer.ID, pi.ID, added, variants, time.Now().Add(rewardReservationTTL))
+			if err != nil {
+				if _, cancelErr := app.payments.CancelIntent(pi.ID); cancelErr != nil {
+					app.logger.Error("cancelling payment intent failed", "transaction_id", pi.ID, "err", cancelErr.Error())
+				}
+
+				switch {
+				case errors.Is(err, data.ErrRewardSoldOut):
+					return echo.NewHTTPError(http.StatusConflict, err.Error())
+				case errors.Is(err, data.ErrNoRecordFound):
+					return echo.NewHTTPError(http.StatusNotFound, err.Error())
+				default:
+					return err
+				}
+			}
+		}
+
+		change.TransactionID = &pi.ID
+
+		err = app.models.Backing.InsertPledgeChange(change)
+		if err != nil {
+			if _, cancelErr := app.payments.CancelIntent(pi.ID); cancelErr != nil {
+				app.logger.Error("cancelling payment intent failed", "transaction_id", pi.ID, "err", cancelErr.Error())
+			}
+			if releaseErr := app.models.Rewards.ReleaseReservations(pi.ID); releaseErr != nil {
+				app.logger.Error("releasing reward reservations failed", "transaction_id", pi.ID, "err", releaseErr.Error())
+			}
+
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				return echo.NewHTTPError(http.StatusConflict, "A pledge change is already being processed")
+			default:
+				return err
+			}
+		}
+
+		return c.JSON(http.StatusCreated, envelope{
+			"message":       "Pledge change is waiting for payment",
+			"client_secret": pi.ClientSecret,
+			"amount":        diff,
+			"shipping_fee":  shipment.Total,
+			"change":        change,
+		})
+	}
+
+	if diff < 0 {
+		policy, err := app.models.RefundPolicy.Get(projectId)
+		if err != nil {
+			return err
+		}
+
+		// lowering a pledge gives money back, so it follows the refund policy
+		if err := policy.Allows(project, time.Now()); err != nil {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+
+		change.Adjustments = data.PlanPledgeLowering(activePayments, -diff)
+	}
+
+	if len(change.Adjustments) > 0 {
+		err = app.lowerPledge(change, shipment)
+	} else {
+		err = app.models.Backing.ApplyPledgeChange(change, nil, shipment)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return echo.NewHTTPError(http.StatusConflict, data.ErrEditConflict.Error())
+		case errors.Is(err, payments.ErrInvalidState):
+			return echo.NewHTTPError(http.StatusConflict, "The payment can't be given back in its current state")
+		case errors.Is(err, data.ErrRewardSoldOut):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Pledge changed successfully",
+		"change":  change,
+	})
+}
+
+// lowerPledge records the lowering as pending, with the adjustments it plans,
+// before giving any money back. A refund webhook or a crash in between then
+// finds it and finishes it, instead of recording the money as given back
+// through the payment provider.
+func (app *application) lowerPledge(change *data.PledgeChange, shipment *data.Shipment) error {
+	err := app.models.Backing.InsertPledgeChange(change)
+	if err != nil {
+		return err
+	}
+
+	if added := data.Difference(change.NewRewards, change.OldRewards); len(added) > 0 {
+		err = app.models.Rewards.Reserve(change.ProjectID, change.BackerID, change.ReservationID(), added, change.NewVariants, time.Now().Add(rewardReservationTTL))
+		if err != nil {
+			return app.dropPledgeLowering(change, err)
+		}
+	}
+
+	return app.finishPledgeLowering(change, shipment)
+}
+
+// finishPledgeLowering gives the money of a pending lowering back and applies
+// it. It can run again on a lowering that was interrupted: nothing is given
+// back twice. A lowering is only dropped when the payment provider refused the
+// first money to go out, otherwise it stays pending until it can be finished.
+func (app *application) finishPledgeLowering(change *data.PledgeChange, shipment *data.Shipment) error {
+	for i, adjustment := range change.Adjustments {
+		err := app.adjustPayment(change.HistoryID, adjustment)
+		if err != nil {
+			given := slices.ContainsFunc(change.Adjustments[:i], func(a *data.PaymentAdjustment) bool {
+				return a.RefundID != ""
+			})
+			if !given && (errors.Is(err, payments.ErrInvalidState) || errors.Is(err, payments.ErrIntentNotFound)) {
+				return app.dropPledgeLowering(change, err)
+			}
+			return err
+		}
+	}
+
+	err := app.models.Backing.ApplyPledgeChange(change, nil, shipment)
+	if errors.Is(err, data.ErrEditConflict) {
+		// a webhook for the same payments may have finished it first
+		applied, getErr := app.models.Backing.GetPledgeChange(change.HistoryID)
+		if getErr == nil && applied.Status == data.PledgeChangeApplied {
+			*change = *applied
+			return nil
+		}
+	}
+
+	return err
+}
+
+// adjustPayment gives the money of one adjustment back. Refunds are sent with
+// a key of their own so sending one again doesn't refund twice.
+func (app *application) adjustPayment(historyID int, adjustment *data.PaymentAdjustment) error {
+	if adjustment.RefundID != "" {
+		return nil
+	}
+
+	switch {
+	case adjustment.Held && adjustment.Status == "":
+		// captured for less at settlement
+		return nil
+	case adjustment.Held:
+		pi, err := app.releaseHold(adjustment.TransactionID)
+		if err != nil {
+			return err
+		}
+		adjustment.RefundID = pi.ID
+	default:
+		refund, err := app.payments.Refund(payments.RefundParams{
+			IntentID:       adjustment.TransactionID,
+			Amount:         int64(adjustment.Amount),
+			IdempotencyKey: fmt.Sprintf("pledge_change_%d_%d", historyID, adjustment.PaymentID),
+			Metadata:       map[string]string{"history_id": strconv.Itoa(historyID)},
+		})
+		if err != nil {
+			return err
+		}
+		adjustment.RefundID = refund.ID
+	}
+
+	return nil
+}
+
+// dropPledgeLowering cancels a lowering none of whose money went out and
+// returns err.
+func (app *application) dropPledgeLowering(change *data.PledgeChange, err error) error {
+	cancelErr := app.models.Backing.CancelPledgeLowering(change.HistoryID)
+	if cancelErr != nil && !errors.Is(cancelErr, data.ErrNoRecordFound) {
+		return cancelErr
+	}
+	change.Status = data.PledgeChangeCanceled
+
+	if releaseErr := app.models.Rewards.ReleaseReservations(change.ReservationID()); releaseErr != nil {
+		return releaseErr
+	}
+
+	return err
+}
+
+// resumePledgeLowering finishes a lowering left pending. The money may already
+// be given back, so the pledge is only checked to compute its shipment.
+func (app *application) resumePledgeLowering(change *data.PledgeChange) error {
+	addressID := 0
+	if change.AddressID != nil {
+		addressID = *change.AddressID
+	}
+
+	v := validator.New()
+	shipment, err := app.validatePledge(v, change.ProjectID, change.NewAmount, change.NewRewards, change.NewVariants, change.OldRewards, addressID, change.BackerID, true, false)
+	if err != nil {
+		return err
+	}
+
+	return app.finishPledgeLowering(change, shipment)
+}
+
+// finishPendingLowering finishes the lowering giving money back from the
+// payment, if there is one, before an event about the payment is reconciled.
+func (app *application) finishPendingLowering(transactionID string) error {
+	change, err := app.models.Backing.GetPendingPledgeLowering(transactionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	return app.resumePledgeLowering(change)
+}
+
+func (app *application) confirmPledgeChangeHandler(c echo.Context) error {
+	projectId, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	var input struct {
+		PaymentIntentID string `json:"payment_intent_id"`
+		PaymentMethod   string `json:"payment_method"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	if input.PaymentIntentID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Payment intent id is required")
+	}
+
+	project, err := app.models.Projects.Get(projectId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
+		default:
+			return err
+		}
+	}
+
+	change, err := app.models.Backing.GetPledgeChangeByTransactionID(input.PaymentIntentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Pledge change not found")
+		default:
+			return err
+		}
+	}
+
+	backer := c.Get("user").(*data.User)
+
+	if change.BackerID != backer.ID || change.ProjectID != projectId {
+		return echo.NewHTTPError(http.StatusForbidden, "This payment doesn't belong to this backing")
+	}
+
+	if change.Status != data.PledgeChangePending {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("Pledge change is already %s", change.Status))
+	}
+
+	pi, err := app.payments.GetIntent(input.PaymentIntentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, payments.ErrIntentNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Payment intent not found")
+		default:
+			return err
+		}
+	}
+
+	expectedStatus := payments.StatusSucceeded
+	if project.FundingModel == data.FundingAllOrNothing {
+		expectedStatus = payments.StatusRequiresCapture
+	}
+
+	if pi.Status != expectedStatus {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("Payment is not completed (status: %s)", pi.Status))
+	}
+
+	err = app.applyPledgeRaise(change, pi, input.PaymentMethod)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return echo.NewHTTPError(http.StatusConflict, "This pledge change has already been recorded")
+		case errors.Is(err, data.ErrRewardSoldOut), errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("%s, the payment was given back", err))
+		case errors.Is(err, errPledgeChangeInvalid):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("%s, the payment was given back", err))
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Pledge changed successfully",
+		"change":  change,
+	})
+}
+
+// applyPledgeRaise records the payment of the difference of a raised pledge.
+// It is called from the confirm endpoint and from the webhook, whichever comes
+// first. A change that isn't valid anymore or whose added rewards can't be
+// pledged anymore is canceled and its payment given back.
+func (app *application) applyPledgeRaise(change *data.PledgeChange, pi *payments.Intent, paymentMethod string) error {
+	addressID := 0
+	if change.AddressID != nil {
+		addressID = *change.AddressID
+	}
+
+	v := validator.New()
+	shipment, err := app.validatePledge(v, change.ProjectID, change.NewAmount, change.NewRewards, change.NewVariants, change.OldRewards, addressID, change.BackerID, true, false)
+	if err != nil {
+		return err
+	}
+	if !v.Valid() {
+		if err := app.rejectPledgeRaise(change, pi); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %v", errPledgeChangeInvalid, v.Errors)
+	}
+
+	payment := &data.Payment{
+		Amount:        float64(pi.Amount),
+		Status:        pi.Status,
+		TransactionID: pi.ID,
+		PaymentMethod: paymentMethod,
+	}
+
+	err = app.models.Backing.ApplyPledgeChange(change, payment, shipment)
+	if err != nil {
+		if errors.Is(err, data.ErrRewardSoldOut) || errors.Is(err, data.ErrNoRecordFound) {
+			if rejectErr := app.rejectPledgeRaise(change, pi); rejectErr != nil {
+				return rejectErr
+			}
+		}
+		return err
+	}
+
+	app.unlockStretchGoals(change.ProjectID)
+
+	return nil
+}
+
+// rejectPledgeRaise cancels a raise that can't be applied and gives its
+// payment back.
+func (app *application) rejectPledgeRaise(change *data.PledgeChange, pi *payments.Intent) error {
+	err := app.models.Backing.CancelPledgeChange(pi.ID)
+	if err != nil && !errors.Is(err, data.ErrNoRecordFound) {
+		return err
+	}
+	change.Status = data.PledgeChangeCanceled
+
+	return app.givePaymentBack(pi.ID, pi.Status)
+}
+
+func (app *application) getPledgeHistoryHandler(c echo.Context) error {
+	projectId, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	backer := c.Get("user").(*data.User)
+
+	backingID, err := app.models.Backing.GetBacking(backer.ID, projectId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "This user didn't back this project")
+		default:
+			return err
+		}
+	}
+
+	history, err := app.models.Backing.GetPledgeHistory(*backingID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Pledge history returned successfully",
+		"history": history,
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"projectx/internal/data"
 	"projectx/internal/validator"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -14,11 +15,12 @@ import (
 
 func (app *application) createProjectHandler(c echo.Context) error {
 	var input struct {
-		Title       string    `json:"title"`
-		Description string    `json:"description"`
-		FundingGoal float64   `json:"funding_goal"`
-		Categories  []string  `json:"categories"`
-		Deadline    time.Time `json:"deadline"`
+		Title        string    `json:"title"`
+		Description  string    `json:"description"`
+		FundingGoal  float64   `json:"funding_goal"`
+		Categories   []string  `json:"categories"`
+		Deadline     time.Time `json:"deadline"`
+		FundingModel string    `json:"funding_model"`
 	}
 
 	if err := c.Bind(&input); err != nil {
@@ -37,13 +39,18 @@ func (app *application) createProjectHandler(c echo.Context) error {
 		}
 	}
 
+	if input.FundingModel == "" {
+		input.FundingModel = data.FundingFlexible
+	}
+
 	project := &data.Project{
-		Title:       input.Title,
-		Description: input.Description,
-		FundingGoal: input.FundingGoal,
-		Deadline:    input.Deadline,
-		Categories:  input.Categories,
-		CreatorID:   user.ID,
+		Title:        input.Title,
+		Description:  input.Description,
+		FundingGoal:  input.FundingGoal,
+		Deadline:     input.Deadline,
+		Categories:   input.Categories,
+		CreatorID:    user.ID,
+		FundingModel: input.FundingModel,
 	}
 
 	v := validator.New()
@@ -67,8 +74,9 @@ func (app *application) createProjectHandler(c echo.Context) error {
 
 func (app *application) getProjectsHandler(c echo.Context) error {
 	var input struct {
-		Title      string
-		Categories []string
+		Title       string
+		Categories  []string
+		LatePledges bool
 		data.Filter
 	}
 
@@ -76,6 +84,7 @@ func (app *application) getProjectsHandler(c echo.Context) error {
 
 	input.Title = c.QueryParam("title")
 	input.Categories = app.readCSV(c.QueryParams(), "categories", []string{})
+	input.LatePledges = app.readString(c.QueryParams(), "late_pledges", "") == "true"
 	input.Page = app.readInt(c.QueryParams(), "page", 1, v)
 	input.PageSize = app.readInt(c.QueryParams(), "page_size", 5, v)
 	input.Sort = app.readString(c.QueryParams(), "sort", "project_id")
@@ -85,7 +94,7 @@ func (app *application) getProjectsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
 	}
 
-	projects, metaData, err := app.models.Projects.GetAll(input.Title, input.Categories, input.Filter)
+	projects, metaData, err := app.models.Projects.GetAll(input.Title, input.Categories, input.LatePledges, input.Filter)
 	if err != nil {
 		return err
 	}
@@ -120,6 +129,11 @@ func (app *application) getProjectHandler(c echo.Context) error {
 
 	project.Rewards = *rewards
 
+	project.RefundPolicy, err = app.models.RefundPolicy.Get(id)
+	if err != nil {
+		return err
+	}
+
 	return c.JSON(http.StatusOK, envelope{
 		"message": "Project returned successfully",
 		"project": project,
@@ -149,6 +163,21 @@ func (app *application) getPublicProjectHandler(c echo.Context) error {
 
 	project.Rewards = *rewards
 
+	project.RefundPolicy, err = app.models.RefundPolicy.Get(id)
+	if err != nil {
+		return err
+	}
+
+	project.StretchGoals, err = app.models.StretchGoals.GetAll(id)
+	if err != nil {
+		return err
+	}
+
+	project.Sponsors, err = app.models.Sponsorships.GetForProject(id)
+	if err != nil {
+		return err
+	}
+
 	return c.JSON(http.StatusOK, envelope{
 		"message": "Project returned successfully",
 		"project": project,
@@ -172,17 +201,17 @@ func (app *application) updateProjectHandler(c echo.Context) error {
 	}
 
 	var input struct {
-		Title          *string    `json:"title,omitempty"`
-		Description    *string    `json:"description,omitempty"`
-		FundingGoal    *float64   `json:"funding_goal,omitempty"`
-		Categories     []string   `json:"categories,omitempty"`
-		CurrentFunding *float64   `json:"current_funding,omitempty"`
-		Deadline       *time.Time `json:"deadline,omitempty"`
-		ProjectImg     *string    `json:"project_img,omitempty"`
-		Status         *string    `json:"status,omitempty"`
-		Campaign       *string    `json:"campaign,omitempty"`
-		LaunchedAt     *time.Time `json:"launched_at,omitempty"`
-		IsSuspicious   bool       `json:"is_suspicious"`
+		Title        *string    `json:"title,omitempty"`
+		Description  *string    `json:"description,omitempty"`
+		FundingGoal  *float64   `json:"funding_goal,omitempty"`
+		Categories   []string   `json:"categories,omitempty"`
+		Deadline     *time.Time `json:"deadline,omitempty"`
+		ProjectImg   *string    `json:"project_img,omitempty"`
+		Status       *string    `json:"status,omitempty"`
+		Campaign     *string    `json:"campaign,omitempty"`
+		LaunchedAt   *time.Time `json:"launched_at,omitempty"`
+		IsSuspicious bool       `json:"is_suspicious"`
+		FundingModel *string    `json:"funding_model,omitempty"`
 	}
 
 	if err := c.Bind(&input); err != nil {
@@ -223,9 +252,6 @@ func (app *application) updateProjectHandler(c echo.Context) error {
 	if input.FundingGoal != nil {
 		project.FundingGoal = *input.FundingGoal
 	}
-	if input.CurrentFunding != nil {
-		project.CurrentFunding = *input.CurrentFunding
-	}
 	if input.Status != nil {
 		if user.Role == "user" && !slices.Contains(userAllowedUpdates, *input.Status) && slices.Index(orderedProjectStatus, *input.Status) < slices.Index(orderedProjectStatus, project.Status) {
 			return echo.NewHTTPError(http.StatusForbidden, data.ErrActionsForbidden.Error())
@@ -233,6 +259,11 @@ func (app *application) updateProjectHandler(c echo.Context) error {
 		if (user.Role == "admin" || user.Role == "reviewer") && !slices.Contains(privilegedUserAllowedUpdates, *input.Status) {
 			return echo.NewHTTPError(http.StatusForbidden, data.ErrActionsForbidden.Error())
 		}
+		if (*input.Status == "Approved" || *input.Status == "Live") && *input.Status != project.Status {
+			if err := app.requireVerifiedPayoutAccount(project.CreatorID); err != nil {
+				return err
+			}
+		}
 		project.Status = *input.Status
 	}
 	if input.Campaign != nil {
@@ -249,6 +280,10 @@ func (app *application) updateProjectHandler(c echo.Context) error {
 	if input.IsSuspicious {
 		project.IsSuspicious = input.IsSuspicious
 	}
+	if input.FundingModel != nil && *input.FundingModel != project.FundingModel {
+		v.Check(project.Status == "Draft" || project.Status == "Pending Review", "funding_model", "Funding model cannot be changed once the project is approved")
+		project.FundingModel = *input.FundingModel
+	}
 
 	if data.ValidateProject(v, project); !v.Valid() {
 		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
@@ -272,23 +307,238 @@ func (app *application) updateProjectHandler(c echo.Context) error {
 	})
 }
 
+func (app *application) updateRefundPolicyHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	project, err := app.models.Projects.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
+		default:
+			return err
+		}
+	}
+
+	user := c.Get("user").(*data.User)
+
+	// backers pledge under the policy the project launched with
+	if user.Role != "admin" && (project.Status == "Live" || project.Status == "Completed") {
+		return echo.NewHTTPError(http.StatusConflict, "Refund policy cannot be changed once the project is live")
+	}
+
+	policy, err := app.models.RefundPolicy.Get(id)
+	if err != nil {
+		return err
+	}
+
+	var input struct {
+		DisputeOnly       *bool `json:"dispute_only"`
+		FinalHoursLocked  *int  `json:"final_hours_locked"`
+		AllowAfterSuccess *bool `json:"allow_after_success"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	if input.DisputeOnly != nil {
+		policy.DisputeOnly = *input.DisputeOnly
+	}
+	if input.FinalHoursLocked != nil {
+		policy.FinalHoursLocked = *input.FinalHoursLocked
+	}
+	if input.AllowAfterSuccess != nil {
+		policy.AllowAfterSuccess = *input.AllowAfterSuccess
+	}
+
+	v := validator.New()
+
+	if data.ValidateRefundPolicy(v, policy); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err = app.models.RefundPolicy.Upsert(policy)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":       "Refund policy updated successfully",
+		"refund_policy": policy,
+	})
+}
+
+// updateLatePledgesHandler lets the creator of a successful project keep
+// taking pledges after the campaign, at a late price per reward.
+func (app *application) updateLatePledgesHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	project, err := app.models.Projects.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
+		default:
+			return err
+		}
+	}
+
+	if project.Status != "Completed" {
+		return echo.NewHTTPError(http.StatusConflict, "Only successful projects can take late pledges")
+	}
+
+	var input struct {
+		Enabled bool             `json:"enabled"`
+		Until   *time.Time       `json:"until"`
+		Prices  []data.LatePrice `json:"prices"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	v := validator.New()
+
+	if data.ValidateLatePledges(v, input.Until, input.Prices); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err = app.models.Projects.UpdateLatePledges(id, input.Enabled, input.Until, input.Prices)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		case errors.Is(err, data.ErrEditConflict):
+			return echo.NewHTTPError(http.StatusConflict, "Only successful projects can take late pledges")
+		default:
+			return err
+		}
+	}
+
+	rewards, err := app.models.Rewards.GetAll(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":            "Late pledges updated successfully",
+		"late_pledges":       input.Enabled,
+		"late_pledges_until": input.Until,
+		"rewards":            rewards,
+	})
+}
+
+// deleteProjectHandler takes the project down. Its backers are refunded in
+// the background and the project is only deleted once they all are.
 func (app *application) deleteProjectHandler(c echo.Context) error {
 	id, err := app.readIDParam(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
 
-	err = app.models.Projects.Delete(id)
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	return app.startProjectCancellation(c, id, data.CancellationTakedown, input.Reason)
+}
+
+func (app *application) cancelProjectHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	project, err := app.models.Projects.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecordFound):
-			return echo.NewHTTPError(http.StatusNotFound, data.ErrNoRecordFound.Error())
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
 		default:
 			return err
 		}
 	}
 
-	return c.JSON(http.StatusOK, envelope{"message": "Project deleted successfully"})
+	if slices.Contains([]string{"Completed", "Failed", "Cancelled"}, project.Status) {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("A %s project cannot be cancelled", strings.ToLower(project.Status)))
+	}
+
+	return app.startProjectCancellation(c, id, data.CancellationByCreator, input.Reason)
+}
+
+func (app *application) startProjectCancellation(c echo.Context, projectID int, cancellationType, reason string) error {
+	v := validator.New()
+
+	if data.ValidateReason(v, reason); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	user := c.Get("user").(*data.User)
+
+	cancellation := &data.ProjectCancellation{
+		ProjectID:   projectID,
+		Type:        cancellationType,
+		Reason:      reason,
+		RequestedBy: &user.ID,
+	}
+
+	err := app.models.Projects.StartCancellation(cancellation)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
+		case errors.Is(err, data.ErrAlreadyCancelled):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusAccepted, envelope{
+		"message":      "Project is being cancelled, backers will be refunded",
+		"cancellation": cancellation,
+	})
+}
+
+func (app *application) getProjectCancellationHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	cancellation, err := app.models.Projects.GetCancellation(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project is not cancelled")
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":      "Cancellation returned successfully",
+		"cancellation": cancellation,
+	})
 }
 
 func (app *application) getProjectsByCreatorHandler(c echo.Context) error {
@@ -410,8 +660,9 @@ func (app *application) createUpdateHandler(c echo.Context) error {
 	}
 
 	var input struct {
-		Title   string `json:"title"`
-		Content string `json:"content"`
+		Title       string `json:"title"`
+		Content     string `json:"content"`
+		MembersOnly bool   `json:"members_only"`
 	}
 
 	if err := c.Bind(&input); err != nil {
@@ -419,9 +670,10 @@ func (app *application) createUpdateHandler(c echo.Context) error {
 	}
 
 	update := &data.Update{
-		Title:     input.Title,
-		Content:   input.Content,
-		ProjectID: id,
+		Title:       input.Title,
+		Content:     input.Content,
+		ProjectID:   id,
+		MembersOnly: input.MembersOnly,
 	}
 
 	v := validator.New()
@@ -511,7 +763,7 @@ func (app *application) reviewProjectHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
 
-	_, err = app.models.Projects.Get(id)
+	project, err := app.models.Projects.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecordFound):
@@ -543,6 +795,12 @@ func (app *application) reviewProjectHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
 	}
 
+	if review.Status == "Approved" {
+		if err := app.requireVerifiedPayoutAccount(project.CreatorID); err != nil {
+			return err
+		}
+	}
+
 	err = app.models.Projects.ReviewProject(review)
 	if err != nil {
 		return err
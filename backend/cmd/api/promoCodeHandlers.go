@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) createPromoCodeHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	project, err := app.models.Projects.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
+		default:
+			return err
+		}
+	}
+
+	if project.Status == "Cancelled" || project.Status == "Failed" {
+		return echo.NewHTTPError(http.StatusConflict, "Promo codes cannot be added to a project that is not taking pledges anymore")
+	}
+
+	var input struct {
+		Code           string     `json:"code"`
+		DiscountType   string     `json:"discount_type"`
+		DiscountValue  float64    `json:"discount_value"`
+		RewardIDs      []int      `json:"reward_ids"`
+		MaxRedemptions *int       `json:"max_redemptions"`
+		StartsAt       *time.Time `json:"starts_at"`
+		EndsAt         *time.Time `json:"ends_at"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	promo := &data.PromoCode{
+		ProjectID:      id,
+		Code:           normalizePromoCode(input.Code),
+		DiscountType:   input.DiscountType,
+		DiscountValue:  input.DiscountValue,
+		RewardIDs:      input.RewardIDs,
+		MaxRedemptions: input.MaxRedemptions,
+		StartsAt:       input.StartsAt,
+		EndsAt:         input.EndsAt,
+	}
+	if promo.RewardIDs == nil {
+		promo.RewardIDs = []int{}
+	}
+
+	rewards, err := app.models.Rewards.GetByIDs(promo.RewardIDs)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	data.ValidatePromoCode(v, promo, rewards)
+	if promo.EndsAt != nil {
+		v.Check(promo.EndsAt.After(time.Now()), "ends_at", "End date must be in the future")
+	}
+	if !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err = app.models.PromoCodes.Insert(promo)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePromoCode):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusCreated, envelope{
+		"message":    "Promo code created successfully",
+		"promo_code": promo,
+	})
+}
+
+func (app *application) getPromoCodesHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	promos, err := app.models.PromoCodes.GetAll(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":     "Promo codes returned successfully",
+		"promo_codes": promos,
+	})
+}
+
+// updatePromoCodeHandler changes when a code of the project can be used and
+// how many times, or turns it off.
+func (app *application) updatePromoCodeHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	var input struct {
+		PromoCodeID    int        `json:"promo_code_id"`
+		MaxRedemptions *int       `json:"max_redemptions"`
+		StartsAt       *time.Time `json:"starts_at"`
+		EndsAt         *time.Time `json:"ends_at"`
+		IsActive       *bool      `json:"is_active"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	promo, err := app.models.PromoCodes.Get(input.PromoCodeID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Promo code not found")
+		default:
+			return err
+		}
+	}
+
+	if input.MaxRedemptions != nil {
+		promo.MaxRedemptions = input.MaxRedemptions
+	}
+	if input.StartsAt != nil {
+		promo.StartsAt = input.StartsAt
+	}
+	if input.EndsAt != nil {
+		promo.EndsAt = input.EndsAt
+	}
+	if input.IsActive != nil {
+		promo.IsActive = *input.IsActive
+	}
+
+	rewards, err := app.models.Rewards.GetByIDs(promo.RewardIDs)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	if data.ValidatePromoCode(v, promo, rewards); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err = app.models.PromoCodes.Update(promo)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return echo.NewHTTPError(http.StatusConflict, data.ErrEditConflict.Error())
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":    "Promo code updated successfully",
+		"promo_code": promo,
+	})
+}
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// applyPromoCode checks the code against the project and the selected rewards
+// and works out what it takes off a pledge of amount.
+func (app *application) applyPromoCode(v *validator.Validator, projectID int, code string, amount float64, rewardIDs []int, late bool) (*data.PromoCode, float64, error) {
+	promo, err := app.models.PromoCodes.GetByCode(projectID, normalizePromoCode(code))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			v.AddError("promo_code", "Promo code not found")
+			return nil, 0, nil
+		default:
+			return nil, 0, err
+		}
+	}
+
+	if !promo.IsRedeemable(time.Now()) {
+		v.AddError("promo_code", "Promo code cannot be used at this time")
+		return nil, 0, nil
+	}
+	if promo.MaxRedemptions != nil && promo.Redemptions >= *promo.MaxRedemptions {
+		v.AddError("promo_code", data.ErrPromoCodeExhausted.Error())
+		return nil, 0, nil
+	}
+
+	rewards, err := app.models.Rewards.GetByIDs(rewardIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	discount := data.PromoDiscount(v, promo, amount, rewardIDs, rewards, late)
+	data.ValidateAmount(v, amount-discount)
+
+	return promo, discount, nil
+}
+
+// intentDiscount returns what a promo code took off the payment, which has to
+// be added back when the pledge is checked against its rewards.
+func intentDiscount(metadata map[string]string) float64 {
+	discount, _ := strconv.ParseFloat(metadata["discount"], 64)
+	return discount
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/pdf"
+	"projectx/internal/validator"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) getBackingReceiptHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	user := c.Get("user").(*data.User)
+
+	payments, err := app.models.Tables.GetUserBacking(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Backing not found")
+		default:
+			return err
+		}
+	}
+
+	rewards, err := app.models.Rewards.GetAllByBacking(id)
+	if err != nil {
+		return err
+	}
+
+	receipt, err := pdf.Receipt(user, payments, *rewards)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=backing-%d-receipt.pdf", id))
+	return c.Blob(http.StatusOK, "application/pdf", receipt)
+}
+
+// getCreatorStatementHandler renders the statement of the month given as
+// YYYY-MM, the last month by default.
+func (app *application) getCreatorStatementHandler(c echo.Context) error {
+	lastMonth := time.Now().UTC().AddDate(0, -1, 0).Format("2006-01")
+
+	month, err := time.Parse("2006-01", app.readString(c.QueryParams(), "month", lastMonth))
+
+	v := validator.New()
+	v.Check(err == nil, "month", "Month must be formatted as YYYY-MM")
+	if err == nil {
+		v.Check(!month.After(time.Now()), "month", "Month cannot be in the future")
+	}
+
+	if !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	user := c.Get("user").(*data.User)
+
+	entries, err := app.models.Tables.GetCreatorStatement(user.ID, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return err
+	}
+
+	statement, err := pdf.Statement(user, month, entries)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=statement-%s.pdf", month.Format("2006-01")))
+	return c.Blob(http.StatusOK, "application/pdf", statement)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/payments"
+	"projectx/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) adminRefundBackingHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	var input struct {
+		Amount   float64 `json:"amount"`
+		Category string  `json:"category"`
+		Reason   string  `json:"reason"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	v := validator.New()
+
+	if data.ValidateRefund(v, input.Amount, input.Category, input.Reason); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	backing, err := app.models.Backing.GetByID(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Backing not found")
+		default:
+			return err
+		}
+	}
+
+	activePayments, err := app.models.Backing.GetActivePayments(backing.BackingID)
+	if err != nil {
+		return err
+	}
+	if len(activePayments) == 0 {
+		return echo.NewHTTPError(http.StatusConflict, "This backing has already been refunded")
+	}
+
+	admin := c.Get("user").(*data.User)
+
+	cancellations, originalBackingDate, err := app.refundPayments(v, backing.BackingID, activePayments, input.Amount, input.Category, input.Reason, &admin.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict), errors.Is(err, data.ErrRefundTooLarge), errors.Is(err, payments.ErrInvalidState):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		default:
+			return err
+		}
+	}
+
+	if !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	backer, err := app.models.Users.GetByID(backing.BackerID)
+	if err != nil {
+		return err
+	}
+
+	project, err := app.models.Projects.Get(backing.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	app.sendRefundEmail(backer.Email, project.Title, activePayments[len(activePayments)-1].TransactionID, originalBackingDate, cancellations)
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Backing refunded successfully",
+		"refunds": cancellations,
+	})
+}
+
+func (app *application) getBackingRefundsHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	cancellations, err := app.models.Backing.GetCancellations(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Refunds returned successfully",
+		"refunds": cancellations,
+	})
+}
+
+// refundPayments gives amount back through the payment provider, starting
+// with the latest payment. An amount of 0 gives back everything that is left.
+// Problems with the amount are added to v and nothing is refunded. Refunds are
+// recorded pending before the provider is asked for them and completed once
+// it made them, so one that stops halfway is finished later instead of being
+// made twice. It returns when the backing was made.
+func (app *application) refundPayments(v *validator.Validator, backingID int, activePayments []*data.Payment, amount float64, category, reason string, refundedBy *int) ([]*data.Cancellation, *string, error) {
+	cancellations := data.PlanRefund(v, activePayments, amount, category, reason, refundedBy)
+	if !v.Valid() {
+		return nil, nil, nil
+	}
+
+	originalBackingDate, err := app.models.Backing.BeginRefund(backingID, cancellations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, cancellation := range cancellations {
+		err := app.completeRefund(cancellation, activePayments[i])
+		if err != nil {
+			return nil, nil, app.refundFailed(cancellations[i:], err)
+		}
+	}
+
+	return cancellations, originalBackingDate, nil
+}
+
+// completeRefund gives the money of a pending cancellation back and records
+// it. The refund is sent with a key of its own, so sending it again after an
+// interruption doesn't refund twice.
+func (app *application) completeRefund(cancellation *data.Cancellation, payment *data.Payment) error {
+	var refundID string
+
+	// a pledge to an all-or-nothing project that hasn't been settled yet
+	// is only an authorization hold, so it gets released instead of refunded
+	if payment.Status == payments.StatusRequiresCapture {
+		pi, err := app.releaseHold(payment.TransactionID)
+		if err != nil {
+			return err
+		}
+		refundID = pi.ID
+	} else {
+		refundAmount := int64(cancellation.Amount)
+		if cancellation.Amount == payment.Amount-payment.RefundedAmount {
+			refundAmount = 0
+		}
+
+		refund, err := app.payments.Refund(payments.RefundParams{
+			IntentID:       payment.TransactionID,
+			Amount:         refundAmount,
+			IdempotencyKey: fmt.Sprintf("cancellation_%d", cancellation.CancellationID),
+			Metadata:       map[string]string{"cancellation_id": strconv.Itoa(cancellation.CancellationID)},
+		})
+		if err != nil {
+			return err
+		}
+		refundID = refund.ID
+	}
+
+	err := app.models.Backing.CompleteRefund(cancellation, refundID)
+	if errors.Is(err, data.ErrNoRecordFound) {
+		// the webhook of the refund finished it first
+		cancellation.RefundID = refundID
+		cancellation.Status = data.RefundCompleted
+		return nil
+	}
+
+	return err
+}
+
+// refundFailed handles a refund that stopped at the first of the remaining
+// cancellations. The ones after it were never sent and are marked failed, so
+// is the first one when the payment provider refused it. Otherwise it's left
+// pending, to be finished once the provider or the database answers again.
+func (app *application) refundFailed(remaining []*data.Cancellation, err error) error {
+	refused := errors.Is(err, payments.ErrInvalidState) || errors.Is(err, payments.ErrIntentNotFound)
+
+	for i, cancellation := range remaining {
+		if i == 0 && !refused {
+			app.logger.Error("refund left pending", "cancellation_id", cancellation.CancellationID, "payment_id", cancellation.PaymentID, "amount", cancellation.Amount, "err", err.Error())
+			continue
+		}
+
+		failErr := app.models.Backing.FailRefund(cancellation.CancellationID)
+		if failErr != nil && !errors.Is(failErr, data.ErrNoRecordFound) {
+			return failErr
+		}
+	}
+
+	return err
+}
+
+// resumeRefund finishes a refund left pending. One the payment provider
+// refuses is marked failed.
+func (app *application) resumeRefund(refund *data.PendingRefund) error {
+	err := app.completeRefund(refund.Cancellation, refund.Payment)
+	if errors.Is(err, payments.ErrInvalidState) || errors.Is(err, payments.ErrIntentNotFound) {
+		app.logger.Warn("pending refund refused by the payment provider", "cancellation_id", refund.Cancellation.CancellationID, "transaction_id", refund.Payment.TransactionID, "err", err.Error())
+		return app.models.Backing.FailRefund(refund.Cancellation.CancellationID)
+	}
+
+	return err
+}
+
+// finishPendingRefunds finishes the refunds of the payment left pending, if
+// any, before an event about the payment is reconciled.
+func (app *application) finishPendingRefunds(transactionID string) error {
+	refunds, err := app.models.Backing.GetPendingRefunds(transactionID)
+	if err != nil {
+		return err
+	}
+
+	for _, refund := range refunds {
+		err := app.resumeRefund(refund)
+		if err != nil && !errors.Is(err, data.ErrNoRecordFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resumePendingRefunds finishes the refunds left pending, when the server
+// stopped or the payment provider failed halfway through giving money back.
+func (app *application) resumePendingRefunds() {
+	refunds, err := app.models.Backing.GetStalePendingRefunds(time.Now().Add(-pendingRefundTimeout))
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	for _, refund := range refunds {
+		err := app.resumeRefund(refund)
+		if err != nil {
+			app.logger.Error("resuming refund failed", "cancellation_id", refund.Cancellation.CancellationID, "err", err.Error())
+			continue
+		}
+
+		app.logger.Info("pending refund finished", "cancellation_id", refund.Cancellation.CancellationID, "status", refund.Cancellation.Status)
+	}
+}
+
+func (app *application) sendRefundEmail(email, projectTitle, transactionID string, transactionDate *string, cancellations []*data.Cancellation) {
+	var refundIDs []string
+	var refundAmount float64
+	for _, cancellation := range cancellations {
+		refundIDs = append(refundIDs, cancellation.RefundID)
+		refundAmount += cancellation.Amount
+	}
+
+	refundDate := time.Now()
+	if len(cancellations) > 0 {
+		refundDate = cancellations[0].Date
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"RefundID":                strings.Join(refundIDs, ", "),
+			"RefundDate":              refundDate,
+			"OriginalTransactionID":   transactionID,
+			"OriginalTransactionDate": transactionDate,
+			"PaymentMethod":           "card",
+			"ProjectName":             projectTitle,
+			"RefundAmount":            refundAmount / 100,
+		}
+		err := app.mailer.Send(email, "refund.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
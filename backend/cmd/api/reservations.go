@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	rewardReservationTTL       = 15 * time.Minute
+	reservationCleanupInterval = 5 * time.Minute
+	pledgeLoweringTimeout      = time.Minute
+)
+
+func (app *application) reservationWorker(ctx context.Context) {
+	ticker := time.NewTicker(reservationCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.resumePledgeLowerings()
+
+			released, err := app.models.Rewards.DeleteExpiredReservations()
+			if err != nil {
+				app.logger.Error(err.Error())
+				continue
+			}
+			if released > 0 {
+				app.logger.Info("expired reward reservations released", "count", released)
+			}
+		}
+	}
+}
+
+// resumePledgeLowerings finishes the lowerings left pending, when the server
+// stopped or the payment provider failed halfway through giving money back.
+func (app *application) resumePledgeLowerings() {
+	changes, err := app.models.Backing.GetStalePledgeLowerings(time.Now().Add(-pledgeLoweringTimeout))
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	for _, change := range changes {
+		err := app.resumePledgeLowering(change)
+		if err != nil {
+			app.logger.Error("resuming pledge lowering failed", "history_id", change.HistoryID, "err", err.Error())
+			continue
+		}
+
+		app.logger.Info("pending pledge lowering finished", "backing_id", change.BackingID, "history_id", change.HistoryID, "status", change.Status)
+	}
+}
@@ -1,22 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"encoding/csv"
 	"errors"
+	"fmt"
 	"net/http"
 	"projectx/internal/data"
 	"projectx/internal/validator"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
 type rewardsInput struct {
-	Title             string    `json:"title"`
-	Description       string    `json:"description"`
-	Amount            float64   `json:"amount"`
-	EstimatedDelivery time.Time `json:"estimated_delivery"`
-	ImageURL          string    `json:"image_url"`
-	Includes          []string  `json:"includes"`
+	ID                int                   `json:"id"`
+	Title             string                `json:"title"`
+	Description       string                `json:"description"`
+	Amount            float64               `json:"amount"`
+	EstimatedDelivery time.Time             `json:"estimated_delivery"`
+	ImageURL          string                `json:"image_url"`
+	Includes          []string              `json:"includes"`
+	QuantityLimit     *int                  `json:"quantity_limit"`
+	Shipping          []data.RewardShipping `json:"shipping"`
+	IsAddon           bool                  `json:"is_addon"`
+	Variants          []data.RewardVariant  `json:"variants"`
 }
 
 func (app *application) createRewardsHandler(c echo.Context) error {
@@ -52,6 +63,10 @@ func (app *application) createRewardsHandler(c echo.Context) error {
 		realReward.EstimatedDelivery = reward.EstimatedDelivery
 		realReward.ImageURL = reward.ImageURL
 		realReward.Includes = reward.Includes
+		realReward.QuantityLimit = reward.QuantityLimit
+		realReward.Shipping = reward.Shipping
+		realReward.IsAddon = reward.IsAddon
+		realReward.Variants = reward.Variants
 		rewards = append(rewards, realReward)
 	}
 
@@ -136,6 +151,11 @@ func (app *application) updateRewardsHandler(c echo.Context) error {
 		realReward.EstimatedDelivery = reward.EstimatedDelivery
 		realReward.ImageURL = reward.ImageURL
 		realReward.Includes = reward.Includes
+		realReward.QuantityLimit = reward.QuantityLimit
+		realReward.Shipping = reward.Shipping
+		realReward.IsAddon = reward.IsAddon
+		realReward.Variants = reward.Variants
+		realReward.ID = reward.ID
 		rewards = append(rewards, realReward)
 	}
 
@@ -151,7 +171,12 @@ func (app *application) updateRewardsHandler(c echo.Context) error {
 
 	err = app.models.Rewards.UpdateAll(rewards, id)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Reward not found")
+		default:
+			return err
+		}
 	}
 
 	return c.JSON(http.StatusCreated, envelope{
@@ -159,3 +184,59 @@ func (app *application) updateRewardsHandler(c echo.Context) error {
 		"rewards": rewards,
 	})
 }
+
+// getVariantTotalsHandler counts the pledged units of each reward variant, so
+// creators know how many of each to manufacture. ?format=csv returns them as a
+// spreadsheet.
+func (app *application) getVariantTotalsHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	totals, err := app.models.Rewards.GetVariantTotals(id)
+	if err != nil {
+		return err
+	}
+
+	if app.readString(c.QueryParams(), "format", "json") != "csv" {
+		return c.JSON(http.StatusOK, envelope{
+			"message": "Variant totals returned successfully",
+			"totals":  totals,
+		})
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	w.Write([]string{"reward_id", "reward", "add_on", "variant_id", "variant", "options", "quantity"})
+	for _, total := range totals {
+		variantID := ""
+		if total.VariantID != nil {
+			variantID = strconv.Itoa(*total.VariantID)
+		}
+
+		options := make([]string, 0, len(total.Options))
+		for name, value := range total.Options {
+			options = append(options, name+"="+value)
+		}
+		slices.Sort(options)
+
+		w.Write([]string{
+			strconv.Itoa(total.RewardID),
+			total.RewardTitle,
+			strconv.FormatBool(total.IsAddon),
+			variantID,
+			total.VariantName,
+			strings.Join(options, "; "),
+			strconv.Itoa(total.Quantity),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=project-%d-variants.csv", id))
+	return c.Blob(http.StatusOK, "text/csv", buf.Bytes())
+}
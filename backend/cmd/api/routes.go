@@ -18,8 +18,17 @@ func (app *application) routes(e *echo.Echo) {
 	publicGroup.GET("/projects/discover/:id", app.getPublicProjectHandler)
 	publicGroup.GET("/projects", app.getProjectsHandler)
 	authGroup.PATCH("/projects/:id", app.updateProjectHandler, app.RequirePermission("projects:update"), app.VerifyProjectOwnership())
+	authGroup.PUT("/projects/refundPolicy/:id", app.updateRefundPolicyHandler, app.RequirePermission("projects:update"), app.VerifyProjectOwnership())
+	authGroup.PUT("/projects/latePledges/:id", app.updateLatePledgesHandler, app.RequirePermission("projects:update"), app.VerifyProjectOwnership())
+	authGroup.PUT("/projects/stretchGoals/:id", app.updateStretchGoalsHandler, app.RequirePermission("projects:update"), app.VerifyProjectOwnership())
+	authGroup.POST("/projects/promoCodes/:id", app.createPromoCodeHandler, app.RequirePermission("projects:update"), app.VerifyProjectOwnership())
+	authGroup.GET("/projects/promoCodes/:id", app.getPromoCodesHandler, app.VerifyProjectOwnership())
+	authGroup.PATCH("/projects/promoCodes/:id", app.updatePromoCodeHandler, app.RequirePermission("projects:update"), app.VerifyProjectOwnership())
 	authGroup.DELETE("/projects/:id", app.deleteProjectHandler, app.RequirePermission("projects:delete"))
+	authGroup.POST("/projects/cancel/:id", app.cancelProjectHandler, app.RequirePermission("projects:update"), app.VerifyProjectOwnership())
+	authGroup.GET("/projects/cancellation/:id", app.getProjectCancellationHandler, app.VerifyProjectOwnership())
 	authGroup.GET("/projects/me", app.getProjectsByCreatorHandler)
+	authGroup.GET("/projects/statement", app.getCreatorStatementHandler)
 	publicGroup.GET("/projects/creator/:id", app.getProjectsByCreatorPublicHandler)
 	publicGroup.GET("/projects/backer/:id", app.getProjectsByBackerHandler)
 	authGroup.GET("/projects/saved", app.getSavedProjectsByCurrentUserHandler)
@@ -48,6 +57,12 @@ func (app *application) routes(e *echo.Echo) {
 	authGroup.PATCH("/users/update/:id", app.updateUserHandler, app.RequirePermission("users:update"))
 	authGroup.PATCH("/users/passwordChange", app.changePasswordHandler)
 	publicGroup.GET("/users/createdBackedCount/:id", app.getBackedCreatedCountHandler)
+	authGroup.GET("/users/addresses", app.listAddressesHandler)
+	authGroup.POST("/users/addresses", app.createAddressHandler)
+	authGroup.PATCH("/users/addresses/:id", app.updateAddressHandler)
+	authGroup.DELETE("/users/addresses/:id", app.deleteAddressHandler)
+	authGroup.GET("/users/payoutAccount", app.getPayoutAccountHandler)
+	authGroup.PUT("/users/payoutAccount", app.savePayoutAccountHandler)
 
 	// backing
 	authGroup.POST("/backing/backIntent/:id", app.createPaymentIntentHandler, app.RequirePermission("backing:create"), app.VerifyProjectNonOwnership())
@@ -55,14 +70,60 @@ func (app *application) routes(e *echo.Echo) {
 	publicGroup.GET("/backing/projectBackers/:id", app.backersCountByProjectHandler)
 	authGroup.GET("/backing/didIbackIt/:id", app.didIBackThisProjectHandler)
 	authGroup.POST("/backing/refund/:id", app.refundHandler, app.RequirePermission("backing:create"))
+	authGroup.GET("/admin/backings/:id/refunds", app.getBackingRefundsHandler, app.RequirePermission("backings:refund"))
+	authGroup.POST("/admin/backings/:id/refund", app.adminRefundBackingHandler, app.RequirePermission("backings:refund"))
 	authGroup.DELETE("/backing/:id", app.deleteBackingHandler, app.RequirePermission("backing:delete"))
 	authGroup.PATCH("/backing/:id", app.updateBackingHandler, app.RequirePermission("backing:update"))
 	authGroup.GET("/backing/rewards/:id", app.getBackingRewardsHandler, app.RequirePermission("backing:rewards"))
+	authGroup.POST("/backing/rewards/:id/received", app.confirmRewardReceiptHandler)
+	authGroup.POST("/backing/manage/:id", app.managePledgeHandler, app.RequirePermission("backing:create"), app.VerifyProjectNonOwnership())
+	authGroup.POST("/backing/manage/:id/confirm", app.confirmPledgeChangeHandler, app.RequirePermission("backing:create"), app.VerifyProjectNonOwnership())
+	authGroup.GET("/backing/history/:id", app.getPledgeHistoryHandler)
+	authGroup.GET("/backing/:id/receipt", app.getBackingReceiptHandler)
+
+	// fulfillment
+	authGroup.PATCH("/fulfillment/:id", app.updateFulfillmentHandler, app.RequirePermission("fulfillment:update"), app.VerifyProjectOwnership())
+
+	// surveys
+	authGroup.PUT("/surveys/:id", app.updateSurveyHandler, app.RequirePermission("projects:update"), app.VerifyProjectOwnership())
+	authGroup.GET("/surveys/:id", app.getSurveyHandler, app.VerifyProjectOwnership())
+	authGroup.POST("/surveys/send/:id", app.sendSurveyHandler, app.RequirePermission("projects:update"), app.VerifyProjectOwnership())
+	authGroup.GET("/surveys/responses/:id", app.getSurveyResponsesHandler, app.VerifyProjectOwnership())
+	authGroup.GET("/surveys/backer/:id", app.getBackerSurveyHandler)
+	authGroup.POST("/surveys/answer/:id", app.answerSurveyHandler)
+
+	// memberships
+	authGroup.POST("/memberships/tiers", app.createMembershipTierHandler, app.RequirePermission("memberships:manage"))
+	authGroup.GET("/memberships/tiers/me", app.getMyMembershipTiersHandler)
+	publicGroup.GET("/memberships/tiers/:id", app.getMembershipTiersHandler)
+	authGroup.PATCH("/memberships/tiers/:id", app.updateMembershipTierHandler, app.RequirePermission("memberships:manage"))
+	authGroup.POST("/memberships/join/:id", app.joinMembershipHandler, app.RequirePermission("memberships:join"))
+	authGroup.GET("/memberships/me", app.getMyMembershipsHandler)
+	authGroup.POST("/memberships/cancel/:id", app.cancelMembershipHandler)
+	authGroup.GET("/memberships/updates/:id", app.getMembersOnlyUpdatesHandler)
+
+	// sponsorships
+	authGroup.POST("/sponsorships", app.createSponsorshipHandler, app.RequirePermission("sponsorships:manage"))
+	authGroup.GET("/sponsorships", app.getSponsorshipsHandler, app.RequirePermission("sponsorships:manage"))
+	authGroup.PATCH("/sponsorships/:id", app.updateSponsorshipHandler, app.RequirePermission("sponsorships:manage"))
+
+	// ledger
+	authGroup.GET("/ledger/drift", app.getFundingDriftHandler, app.RequirePermission("ledger:read"))
+	authGroup.POST("/ledger/drift/repair", app.repairFundingDriftHandler, app.RequirePermission("ledger:repair"))
+
+	// payouts
+	authGroup.GET("/payouts/me", app.getMyPayoutsHandler)
+	authGroup.GET("/payouts", app.getPayoutsHandler, app.RequirePermission("payouts:read"))
+	authGroup.POST("/payouts/:id/approve", app.approvePayoutHandler, app.RequirePermission("payouts:approve"))
+
+	// webhooks
+	publicGroup.POST("/webhooks/stripe", app.stripeWebhookHandler)
 
 	// rewards
 	authGroup.POST("/rewards/create/:id", app.createRewardsHandler, app.RequirePermission("rewards:create"))
 	authGroup.PUT("/rewards/update/:id", app.updateRewardsHandler, app.RequirePermission("rewards:update"))
 	publicGroup.GET("/rewards/:id", app.getAllRewardsHandler)
+	authGroup.GET("/rewards/variantTotals/:id", app.getVariantTotalsHandler, app.RequirePermission("rewards:update"), app.VerifyProjectOwnership())
 
 	// updates
 	authGroup.POST("/updates/create/:id", app.createUpdateHandler, app.RequirePermission("updates:create"), app.VerifyProjectOwnership())
@@ -100,6 +161,7 @@ func (app *application) routes(e *echo.Echo) {
 	authGroup.GET("/tables/pendingAssessements", app.getPendingAssessementProjectsTableHandler, app.RequirePermission("tables:pendingAssessments"))
 	authGroup.GET("/tables/assessed", app.getAssessedProjectsTableHandler, app.RequirePermission("tables:assessed"))
 	authGroup.GET("/tables/createdProjects", app.getCreatedProjectsTableHandler, app.RequirePermission("tables:created"))
+	authGroup.GET("/tables/promoRedemptions", app.getPromoRedemptionsTableHandler, app.RequirePermission("tables:created"))
 	authGroup.GET("/tables/userBackings", app.getUserBackingsTableHandler, app.RequirePermission("tables:userBackings"))
 
 	// disputes
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"projectx/internal/data"
+	"projectx/internal/payments"
+	"time"
+)
+
+const settlementInterval = time.Minute
+
+func (app *application) settlementWorker(ctx context.Context) {
+	ticker := time.NewTicker(settlementInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.settleDueProjects()
+		}
+	}
+}
+
+func (app *application) settleDueProjects() {
+	ids, err := app.models.Projects.GetDueForSettlement()
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	for _, id := range ids {
+		if err := app.settleProject(id); err != nil {
+			app.logger.Error("project settlement failed", "project_id", id, "err", err.Error())
+		}
+	}
+}
+
+// settleProject captures or cancels every authorization hold of an
+// all-or-nothing project once its deadline has passed. Payments are settled one
+// by one, so a run that stops halfway is picked up again on the next tick.
+func (app *application) settleProject(id int) error {
+	project, err := app.models.Projects.Get(id)
+	if err != nil {
+		return err
+	}
+
+	goalReached := project.CurrentFunding >= project.FundingGoal
+
+	held, err := app.models.Backing.GetHeldPayments(id)
+	if err != nil {
+		return err
+	}
+
+	unsettled := 0
+
+	for _, payment := range held {
+		status := "succeeded"
+		if goalReached {
+			_, err = app.payments.CaptureIntent(payment.TransactionID, int64(payment.Amount))
+			if err != nil {
+				app.logger.Error("capturing payment failed", "transaction_id", payment.TransactionID, "err", err.Error())
+				// A hold that expired or was released won't be captured on a
+				// later run either. Anything else is tried again.
+				switch {
+				case errors.Is(err, payments.ErrInvalidState), errors.Is(err, payments.ErrIntentNotFound):
+					status = "failed"
+				default:
+					unsettled++
+					continue
+				}
+			}
+		} else {
+			_, err = app.payments.CancelIntent(payment.TransactionID)
+			if err != nil {
+				app.logger.Error("cancelling payment failed", "transaction_id", payment.TransactionID, "err", err.Error())
+				unsettled++
+				continue
+			}
+			status = "canceled"
+		}
+
+		err = app.models.Backing.SettlePayment(payment.PaymentID, status)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				continue
+			default:
+				return err
+			}
+		}
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"ProjectName":   project.Title,
+				"TransactionID": payment.TransactionID,
+				"Amount":        payment.Amount / 100,
+				"Captured":      status == "succeeded",
+				"GoalReached":   goalReached,
+			}
+			err := app.mailer.Send(payment.BackerEmail, "settlement.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	if unsettled > 0 {
+		return nil
+	}
+
+	finalStatus := "Completed"
+	if !goalReached {
+		finalStatus = "Failed"
+
+		// pledges that were captured anyway, like raises made without a hold,
+		// are refunded like those of a cancelled project
+		captured, err := app.models.Backing.GetRefundablePayments(id, 0, 1)
+		if err != nil {
+			return err
+		}
+		if len(captured) > 0 {
+			err = app.models.Projects.StartCancellation(&data.ProjectCancellation{
+				ProjectID: id,
+				Type:      data.CancellationFailedGoal,
+				Reason:    "The project didn't reach its funding goal",
+			})
+			if err != nil && !errors.Is(err, data.ErrAlreadyCancelled) {
+				return err
+			}
+
+			app.logger.Info("project failed, refunding captured payments", "project_id", id)
+			return nil
+		}
+	}
+
+	err = app.models.Projects.MarkSettled(id, finalStatus)
+	if err != nil && !errors.Is(err, data.ErrEditConflict) {
+		return err
+	}
+
+	app.logger.Info("project settled", "project_id", id, "status", finalStatus, "payments", len(held))
+
+	return nil
+}
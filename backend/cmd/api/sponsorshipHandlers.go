@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/validator"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) createSponsorshipHandler(c echo.Context) error {
+	user := c.Get("user").(*data.User)
+
+	var input struct {
+		SponsorName string     `json:"sponsor_name"`
+		ProjectID   *int       `json:"project_id"`
+		Category    *string    `json:"category"`
+		Cap         float64    `json:"cap"`
+		EndsAt      *time.Time `json:"ends_at"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	if input.Category != nil {
+		category := strings.ToLower(strings.TrimSpace(*input.Category))
+		input.Category = &category
+	}
+
+	sponsorship := &data.Sponsorship{
+		SponsorName: strings.TrimSpace(input.SponsorName),
+		ProjectID:   input.ProjectID,
+		Category:    input.Category,
+		Cap:         input.Cap,
+		EndsAt:      input.EndsAt,
+		CreatedBy:   user.ID,
+	}
+
+	v := validator.New()
+	data.ValidateSponsorship(v, sponsorship)
+	if sponsorship.EndsAt != nil {
+		v.Check(sponsorship.EndsAt.After(time.Now()), "ends_at", "End date must be in the future")
+	}
+	if !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err := app.models.Sponsorships.Insert(sponsorship)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusCreated, envelope{
+		"message":     "Sponsorship created successfully",
+		"sponsorship": sponsorship,
+	})
+}
+
+func (app *application) getSponsorshipsHandler(c echo.Context) error {
+	sponsorships, err := app.models.Sponsorships.GetAll()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":      "Sponsorships returned successfully",
+		"sponsorships": sponsorships,
+	})
+}
+
+// updateSponsorshipHandler changes the sponsor's cap or end date, or stops it
+// from matching new pledges. Pledges already matched stay matched.
+func (app *application) updateSponsorshipHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	sponsorship, err := app.models.Sponsorships.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Sponsorship not found")
+		default:
+			return err
+		}
+	}
+
+	var input struct {
+		SponsorName *string    `json:"sponsor_name"`
+		Cap         *float64   `json:"cap"`
+		IsActive    *bool      `json:"is_active"`
+		EndsAt      *time.Time `json:"ends_at"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	if input.SponsorName != nil {
+		sponsorship.SponsorName = strings.TrimSpace(*input.SponsorName)
+	}
+	if input.Cap != nil {
+		sponsorship.Cap = *input.Cap
+	}
+	if input.IsActive != nil {
+		sponsorship.IsActive = *input.IsActive
+	}
+	if input.EndsAt != nil {
+		sponsorship.EndsAt = input.EndsAt
+	}
+
+	v := validator.New()
+	if data.ValidateSponsorship(v, sponsorship); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err = app.models.Sponsorships.Update(sponsorship)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return echo.NewHTTPError(http.StatusConflict, data.ErrEditConflict.Error())
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":     "Sponsorship updated successfully",
+		"sponsorship": sponsorship,
+	})
+}
@@ -43,6 +43,10 @@ func (app *application) getStatsHandler(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	err = app.models.Stats.GetTotalMatched(stats)
+	if err != nil {
+		return err
+	}
 
 	return c.JSON(http.StatusOK, envelope{
 		"message": "Stats returned successfully",
@@ -234,6 +238,10 @@ func (app *application) getUserStatsHandler(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	err = app.models.Stats.GetMembershipRevenue(stats, user.ID)
+	if err != nil {
+		return err
+	}
 
 	return c.JSON(http.StatusOK, envelope{
 		"message": "User stats returned successfully",
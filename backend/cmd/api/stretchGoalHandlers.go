@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/validator"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) updateStretchGoalsHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	project, err := app.models.Projects.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
+		default:
+			return err
+		}
+	}
+
+	if project.Status == "Cancelled" || project.Status == "Failed" {
+		return echo.NewHTTPError(http.StatusConflict, "Stretch goals cannot be changed on a project that is not taking pledges anymore")
+	}
+
+	var input struct {
+		StretchGoals []*data.StretchGoal `json:"stretch_goals"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	current, err := app.models.StretchGoals.GetAll(id)
+	if err != nil {
+		return err
+	}
+
+	unlocked := []*data.StretchGoal{}
+	for _, goal := range current {
+		if goal.UnlockedAt != nil {
+			unlocked = append(unlocked, goal)
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateStretchGoals(v, project, input.StretchGoals, unlocked); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err = app.models.StretchGoals.ReplaceLocked(id, input.StretchGoals)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return echo.NewHTTPError(http.StatusConflict, "A stretch goal with the same amount was unlocked in the meantime")
+		default:
+			return err
+		}
+	}
+
+	// the project may already be past some of the new goals
+	app.unlockStretchGoals(project.ID)
+
+	goals, err := app.models.StretchGoals.GetAll(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":       "Stretch goals updated successfully",
+		"stretch_goals": goals,
+	})
+}
+
+// unlockStretchGoals unlocks the goals the project's funding reached, posts an
+// update for each of them and lets the backers know. It runs in the
+// background, so a failure is only logged.
+func (app *application) unlockStretchGoals(projectID int) {
+	app.background(func() {
+		goals, err := app.models.StretchGoals.Unlock(projectID)
+		if err != nil {
+			app.logger.Error("unlocking stretch goals failed", "project_id", projectID, "err", err.Error())
+			return
+		}
+		if len(goals) == 0 {
+			return
+		}
+
+		project, err := app.models.Projects.Get(projectID)
+		if err != nil {
+			app.logger.Error("getting project failed", "project_id", projectID, "err", err.Error())
+			return
+		}
+
+		emails, err := app.models.Backing.GetBackerEmails(projectID)
+		if err != nil {
+			app.logger.Error("getting backer emails failed", "project_id", projectID, "err", err.Error())
+		}
+
+		for _, goal := range goals {
+			app.logger.Info("stretch goal unlocked", "project_id", projectID, "stretch_goal_id", goal.ID, "amount", goal.Amount)
+
+			update := &data.Update{
+				Title:     fmt.Sprintf("Stretch goal unlocked: %s", goal.Title),
+				Content:   fmt.Sprintf("We went past %.2f DA and unlocked \"%s\". %s", goal.Amount, goal.Title, goal.Description),
+				ProjectID: projectID,
+			}
+
+			err := app.models.Updates.Insert(update)
+			if err != nil {
+				app.logger.Error("posting stretch goal update failed", "project_id", projectID, "stretch_goal_id", goal.ID, "err", err.Error())
+			}
+
+			data := map[string]interface{}{
+				"ProjectName":     project.Title,
+				"Amount":          goal.Amount,
+				"GoalTitle":       goal.Title,
+				"GoalDescription": goal.Description,
+			}
+			for _, email := range emails {
+				err := app.mailer.Send(email, "stretch_goal.tmpl", data)
+				if err != nil {
+					app.logger.Error(err.Error())
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/validator"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (app *application) updateSurveyHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	project, err := app.models.Projects.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
+		default:
+			return err
+		}
+	}
+
+	if project.Status == "Cancelled" || project.Status == "Failed" {
+		return echo.NewHTTPError(http.StatusConflict, "Surveys can only be made for projects that will deliver their rewards")
+	}
+
+	var input struct {
+		Title       string                 `json:"title"`
+		Description string                 `json:"description"`
+		Questions   []*data.SurveyQuestion `json:"questions"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	rewards, err := app.models.Rewards.GetAll(id)
+	if err != nil {
+		return err
+	}
+
+	rewardIDs := make([]int, 0, len(*rewards))
+	for _, reward := range *rewards {
+		rewardIDs = append(rewardIDs, reward.ID)
+	}
+
+	survey := &data.Survey{
+		ProjectID:   id,
+		Title:       input.Title,
+		Description: input.Description,
+		Questions:   input.Questions,
+	}
+
+	v := validator.New()
+
+	if data.ValidateSurvey(v, survey, rewardIDs); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err = app.models.Surveys.Upsert(survey)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrSurveySent):
+			return echo.NewHTTPError(http.StatusConflict, "The survey cannot be changed after it was sent to backers")
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Survey saved successfully",
+		"survey":  survey,
+	})
+}
+
+func (app *application) getSurveyHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	survey, err := app.models.Surveys.GetByProject(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "This project has no survey")
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Survey returned successfully",
+		"survey":  survey,
+	})
+}
+
+// sendSurveyHandler emails the survey to the backers it asks something to. It
+// can be sent again later to reach backers who pledged since, the others
+// aren't emailed twice.
+func (app *application) sendSurveyHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	project, err := app.models.Projects.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Project not found")
+		default:
+			return err
+		}
+	}
+
+	if project.Status != "Completed" {
+		return echo.NewHTTPError(http.StatusConflict, "Surveys can only be sent once the project is funded")
+	}
+
+	survey, err := app.models.Surveys.GetByProject(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "This project has no survey")
+		default:
+			return err
+		}
+	}
+
+	recipients, err := app.models.Surveys.Send(survey.ID)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+		for _, recipient := range recipients {
+			app.sendSurveyEmail(recipient, false)
+		}
+	})
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":    fmt.Sprintf("Survey sent to %d backers", len(recipients)),
+		"recipients": len(recipients),
+	})
+}
+
+func (app *application) getSurveyResponsesHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	survey, err := app.models.Surveys.GetByProject(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "This project has no survey")
+		default:
+			return err
+		}
+	}
+
+	responses, err := app.models.Surveys.GetResponses(survey.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":   "Survey responses returned successfully",
+		"survey":    survey,
+		"responses": responses,
+	})
+}
+
+func (app *application) getBackerSurveyHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	backer := c.Get("user").(*data.User)
+
+	backingID, err := app.models.Backing.GetBacking(backer.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "This user didn't back this project")
+		default:
+			return err
+		}
+	}
+
+	survey, response, err := app.models.Surveys.GetForBacking(id, *backingID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "No survey was sent for this backing")
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message":  "Survey returned successfully",
+		"survey":   survey,
+		"response": response,
+	})
+}
+
+func (app *application) answerSurveyHandler(c echo.Context) error {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	var input struct {
+		Answers []*data.SurveyAnswer `json:"answers"`
+	}
+
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+	}
+
+	backer := c.Get("user").(*data.User)
+
+	backingID, err := app.models.Backing.GetBacking(backer.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "This user didn't back this project")
+		default:
+			return err
+		}
+	}
+
+	survey, _, err := app.models.Surveys.GetForBacking(id, *backingID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "No survey was sent for this backing")
+		default:
+			return err
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateSurveyAnswers(v, survey.Questions, input.Answers); !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	addressQuestions := map[int]bool{}
+	for _, question := range survey.Questions {
+		if question.Type == data.SurveyQuestionAddress {
+			addressQuestions[question.ID] = true
+		}
+	}
+
+	addresses := map[int]*data.ShippingAddress{}
+	for _, answer := range input.Answers {
+		if !addressQuestions[answer.QuestionID] || answer.AddressID == 0 {
+			continue
+		}
+
+		address, err := app.models.Addresses.Get(answer.AddressID, backer.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrNoRecordFound):
+				v.AddError("answers", fmt.Sprintf("Address %d not found", answer.AddressID))
+				continue
+			default:
+				return err
+			}
+		}
+		addresses[address.ID] = address
+	}
+	if !v.Valid() {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, v.Errors)
+	}
+
+	err = app.models.Surveys.SaveAnswers(survey.ID, *backingID, input.Answers, addresses)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return echo.NewHTTPError(http.StatusNotFound, "No survey was sent for this backing")
+		default:
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, envelope{
+		"message": "Survey answered successfully",
+		"answers": input.Answers,
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"projectx/internal/data"
+	"time"
+)
+
+const (
+	surveyReminderInterval = time.Hour
+	surveyReminderDelay    = 3 * 24 * time.Hour
+	surveyMaxReminders     = 3
+)
+
+// surveyReminderWorker reminds the backers who didn't answer their survey,
+// every surveyReminderDelay and at most surveyMaxReminders times.
+func (app *application) surveyReminderWorker(ctx context.Context) {
+	ticker := time.NewTicker(surveyReminderInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.remindSurveyRecipients()
+		}
+	}
+}
+
+func (app *application) remindSurveyRecipients() {
+	recipients, err := app.models.Surveys.GetDueReminders(time.Now().Add(-surveyReminderDelay), surveyMaxReminders)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	for _, recipient := range recipients {
+		if !app.sendSurveyEmail(recipient, true) {
+			continue
+		}
+
+		err := app.models.Surveys.MarkReminded(recipient.SurveyID, recipient.BackingID)
+		if err != nil {
+			app.logger.Error("marking survey reminder failed", "survey_id", recipient.SurveyID, "backing_id", recipient.BackingID, "err", err.Error())
+		}
+	}
+}
+
+func (app *application) sendSurveyEmail(recipient *data.SurveyRecipient, reminder bool) bool {
+	err := app.mailer.Send(recipient.BackerEmail, "survey.tmpl", map[string]interface{}{
+		"ProjectName": recipient.ProjectTitle,
+		"SurveyTitle": recipient.SurveyTitle,
+		"Reminder":    reminder,
+	})
+	if err != nil {
+		app.logger.Error("sending survey failed", "survey_id", recipient.SurveyID, "backing_id", recipient.BackingID, "err", err.Error())
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"projectx/internal/data"
+	"projectx/internal/payments"
+	"projectx/internal/validator"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+const maxWebhookBodyBytes = int64(65536)
+
+func (app *application) stripeWebhookHandler(c echo.Context) error {
+	payload, err := io.ReadAll(io.LimitReader(c.Request().Body, maxWebhookBodyBytes))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Error while reading the request body")
+	}
+
+	event, err := webhook.ConstructEvent(payload, c.Request().Header.Get("Stripe-Signature"), app.config.stripe.webhookSecret)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook signature")
+	}
+
+	switch event.Type {
+	case "payment_intent.succeeded", "payment_intent.amount_capturable_updated":
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+		}
+		err = app.reconcilePaymentSucceeded(&pi)
+	case "payment_intent.payment_failed", "payment_intent.canceled":
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+		}
+		err = app.reconcilePaymentFailed(&pi)
+	case "charge.refunded":
+		var ch stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &ch); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+		}
+		err = app.reconcileChargeRefunded(&ch)
+	case "charge.dispute.created":
+		var dispute stripe.Dispute
+		if err := json.Unmarshal(event.Data.Raw, &dispute); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+		}
+		err = app.reconcileChargeDisputed(&dispute)
+	case "account.updated":
+		var account stripe.Account
+		if err := json.Unmarshal(event.Data.Raw, &account); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+		}
+		err = app.reconcileAccountUpdated(&account)
+	case "invoice.paid", "invoice.payment_failed":
+		var invoice stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+		}
+		if invoice.Subscription != nil {
+			err = app.reconcileSubscription(invoice.Subscription.ID)
+		}
+	case "customer.subscription.updated", "customer.subscription.deleted":
+		var subscription stripe.Subscription
+		if err := json.Unmarshal(event.Data.Raw, &subscription); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Error while processing data")
+		}
+		err = app.reconcileSubscription(subscription.ID)
+	default:
+		app.logger.Info("ignoring stripe event", "id", event.ID, "type", event.Type)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, envelope{"received": true})
+}
+
+func (app *application) reconcilePaymentSucceeded(pi *stripe.PaymentIntent) error {
+	projectID, err := strconv.Atoi(pi.Metadata["project_id"])
+	if err != nil {
+		app.logger.Info("payment intent without project metadata", "transaction_id", pi.ID)
+		return nil
+	}
+	backerID, err := strconv.Atoi(pi.Metadata["backer_id"])
+	if err != nil {
+		app.logger.Info("payment intent without backer metadata", "transaction_id", pi.ID)
+		return nil
+	}
+
+	paymentMethod := "card"
+	if pi.PaymentMethod != nil && pi.PaymentMethod.ID != "" {
+		paymentMethod = pi.PaymentMethod.ID
+	}
+
+	if pi.Metadata["pledge_change"] != "" {
+		return app.reconcilePledgeChange(pi, paymentMethod)
+	}
+
+	backing := data.Backing{
+		BackerID:  backerID,
+		ProjectID: projectID,
+		IPAddress: pi.Metadata["ip_address"],
+		IsLate:    pi.Metadata["late"] == "true",
+	}
+
+	payment := data.Payment{
+		Amount:        float64(pi.Amount),
+		Status:        string(pi.Status),
+		TransactionID: pi.ID,
+		PaymentMethod: paymentMethod,
+	}
+
+	rewards := &data.PledgedRewards{
+		RewardIDs: parseIDList(pi.Metadata["reward_ids"]),
+		Variants:  parseVariants(pi.Metadata["variant_ids"]),
+	}
+	if len(rewards.RewardIDs) > 0 {
+		addressID, _ := strconv.Atoi(pi.Metadata["address_id"])
+
+		v := validator.New()
+		rewards.Shipment, err = app.validatePledge(v, projectID, float64(pi.Amount)+intentDiscount(pi.Metadata), rewards.RewardIDs, rewards.Variants, nil, addressID, backerID, true, backing.IsLate)
+		if err != nil {
+			return err
+		}
+		if !v.Valid() {
+			app.logger.Warn("backing recorded from webhook with an invalid pledge", "transaction_id", pi.ID, "errors", fmt.Sprint(v.Errors))
+		}
+	}
+
+	created, err := app.models.Backing.ReconcileSucceeded(&backing, &payment, rewards)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRewardSoldOut), errors.Is(err, data.ErrNoRecordFound):
+			app.logger.Warn("payment given back, its rewards can't be pledged anymore", "transaction_id", pi.ID, "err", err.Error())
+			return app.givePaymentBack(pi.ID, payment.Status)
+		default:
+			return err
+		}
+	}
+
+	if !created {
+		return nil
+	}
+
+	app.logger.Info("backing recorded from webhook", "backing_id", backing.BackingID, "transaction_id", payment.TransactionID)
+
+	app.scoreBacking(backing.BackingID)
+	app.unlockStretchGoals(projectID)
+
+	backer, err := app.models.Users.GetByID(backerID)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"TransactionID":   payment.TransactionID,
+			"TransactionDate": payment.CreatedAt,
+			"PaymentMethod":   "card",
+			"Amount":          payment.Amount / 100,
+		}
+		err := app.mailer.Send(backer.Email, "fund_receipt.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	return nil
+}
+
+func (app *application) reconcilePledgeChange(pi *stripe.PaymentIntent, paymentMethod string) error {
+	change, err := app.models.Backing.GetPledgeChangeByTransactionID(pi.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			app.logger.Info("payment intent without a pledge change", "transaction_id", pi.ID)
+			return nil
+		default:
+			return err
+		}
+	}
+
+	switch change.Status {
+	case data.PledgeChangePending:
+		intent := &payments.Intent{ID: pi.ID, Amount: pi.Amount, Status: string(pi.Status)}
+
+		err := app.applyPledgeRaise(change, intent, paymentMethod)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				return nil
+			case errors.Is(err, data.ErrRewardSoldOut), errors.Is(err, data.ErrNoRecordFound):
+				app.logger.Warn("pledge change canceled, its rewards can't be pledged anymore", "backing_id", change.BackingID, "transaction_id", pi.ID, "err", err.Error())
+				return nil
+			case errors.Is(err, errPledgeChangeInvalid):
+				app.logger.Warn("pledge change canceled, it isn't valid anymore", "backing_id", change.BackingID, "transaction_id", pi.ID, "err", err.Error())
+				return nil
+			default:
+				return err
+			}
+		}
+
+		app.logger.Info("pledge change recorded from webhook", "backing_id", change.BackingID, "transaction_id", pi.ID)
+	case data.PledgeChangeApplied:
+		payment := data.Payment{
+			Amount:        float64(pi.Amount),
+			Status:        string(pi.Status),
+			TransactionID: pi.ID,
+			PaymentMethod: paymentMethod,
+		}
+
+		_, err := app.models.Backing.ReconcileSucceeded(&data.Backing{BackerID: change.BackerID, ProjectID: change.ProjectID}, &payment, nil)
+		if err != nil {
+			return err
+		}
+	case data.PledgeChangeCanceled:
+		// the backer paid for a change that was already dropped, give the
+		// money back
+		err = app.givePaymentBack(pi.ID, string(pi.Status))
+		if err != nil {
+			return err
+		}
+
+		app.logger.Warn("payment for a canceled pledge change given back", "backing_id", change.BackingID, "transaction_id", pi.ID)
+	}
+
+	return nil
+}
+
+func (app *application) reconcilePaymentFailed(pi *stripe.PaymentIntent) error {
+	status := "failed"
+	if pi.Status == stripe.PaymentIntentStatusCanceled {
+		status = "canceled"
+	}
+
+	// a hold released by a refund or a pledge lowering is recorded by them
+	err := app.finishPendingRefunds(pi.ID)
+	if err != nil {
+		return err
+	}
+
+	err = app.finishPendingLowering(pi.ID)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Rewards.ReleaseReservations(pi.ID)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.PromoCodes.ReleaseReservation(pi.ID)
+	if err != nil {
+		return err
+	}
+
+	if pi.Metadata["pledge_change"] != "" {
+		err = app.models.Backing.CancelPledgeChange(pi.ID)
+		if err != nil && !errors.Is(err, data.ErrNoRecordFound) {
+			return err
+		}
+	}
+
+	err = app.models.Backing.ReconcileFailed(pi.ID, status)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	app.logger.Info("payment marked as "+status+" from webhook", "transaction_id", pi.ID)
+
+	return nil
+}
+
+func (app *application) reconcileChargeRefunded(ch *stripe.Charge) error {
+	if ch.PaymentIntent == nil {
+		return nil
+	}
+
+	// refunds made by our own refunds and pledge lowerings are recorded by
+	// them, which are finished first so they're skipped below
+	err := app.finishPendingRefunds(ch.PaymentIntent.ID)
+	if err != nil {
+		return err
+	}
+
+	err = app.finishPendingLowering(ch.PaymentIntent.ID)
+	if err != nil {
+		return err
+	}
+
+	refundDate := time.Now()
+
+	var cancellations []*data.Cancellation
+
+	switch {
+	case ch.Refunds != nil && len(ch.Refunds.Data) > 0:
+		// refunds already recorded by our own handlers or by an earlier event
+		// are skipped, so every refund of the charge can be replayed
+		for _, refund := range ch.Refunds.Data {
+			if refund.Status == stripe.RefundStatusFailed || refund.Status == stripe.RefundStatusCanceled {
+				continue
+			}
+			cancellations = append(cancellations, &data.Cancellation{
+				Amount:   float64(refund.Amount),
+				RefundID: refund.ID,
+			})
+		}
+	case ch.Refunded:
+		cancellations = append(cancellations, &data.Cancellation{RefundID: ch.ID})
+	default:
+		app.logger.Info("ignoring partial refund without refund details", "transaction_id", ch.PaymentIntent.ID, "amount_refunded", ch.AmountRefunded)
+		return nil
+	}
+
+	for _, cancellation := range cancellations {
+		cancellation.Category = data.RefundPaymentProvider
+		cancellation.Reason = "Refunded through the payment provider"
+		cancellation.Date = refundDate
+
+		payment, backing, err := app.models.Backing.ReconcileRefunded(ch.PaymentIntent.ID, cancellation)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrNoRecordFound):
+				continue
+			case errors.Is(err, data.ErrRefundTooLarge):
+				app.logger.Warn("refund is more than what is left on the payment", "transaction_id", ch.PaymentIntent.ID, "refund_id", cancellation.RefundID, "amount", cancellation.Amount)
+				continue
+			default:
+				return err
+			}
+		}
+
+		app.logger.Info("payment marked as "+payment.Status+" from webhook", "backing_id", backing.BackingID, "transaction_id", payment.TransactionID)
+
+		backer, err := app.models.Users.GetByID(backing.BackerID)
+		if err != nil {
+			return err
+		}
+
+		project, err := app.models.Projects.Get(backing.ProjectID)
+		if err != nil {
+			return err
+		}
+
+		transactionDate := payment.CreatedAt.String()
+
+		app.sendRefundEmail(backer.Email, project.Title, payment.TransactionID, &transactionDate, []*data.Cancellation{cancellation})
+	}
+
+	return nil
+}
+
+func (app *application) reconcileChargeDisputed(dispute *stripe.Dispute) error {
+	if dispute.PaymentIntent == nil {
+		return nil
+	}
+
+	description := fmt.Sprintf("Payment %s was disputed with the card issuer (reason: %s)", dispute.PaymentIntent.ID, dispute.Reason)
+
+	backing, err := app.models.Backing.ReconcileDisputed(dispute.PaymentIntent.ID, description)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	app.logger.Info("payment marked as disputed from webhook", "backing_id", backing.BackingID, "transaction_id", dispute.PaymentIntent.ID)
+
+	return nil
+}
+
+func (app *application) reconcileAccountUpdated(account *stripe.Account) error {
+	payoutAccount, err := app.models.PayoutAccounts.GetByProviderID(account.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	return app.syncPayoutAccount(payoutAccount)
+}
+
+func (app *application) reconcileSubscription(subscriptionID string) error {
+	membership, err := app.models.Memberships.GetBySubscriptionID(subscriptionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	return app.syncMembership(membership)
+}
@@ -0,0 +1,220 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"projectx/internal/validator"
+	"regexp"
+	"time"
+)
+
+var CountryCodeRX = regexp.MustCompile("^[A-Z]{2}$")
+
+type ShippingAddress struct {
+	ID         int       `json:"address_id"`
+	UserID     int       `json:"-"`
+	FullName   string    `json:"full_name"`
+	Line1      string    `json:"line1"`
+	Line2      string    `json:"line2"`
+	City       string    `json:"city"`
+	Region     string    `json:"region"`
+	PostalCode string    `json:"postal_code"`
+	Country    string    `json:"country"`
+	Phone      string    `json:"phone"`
+	CreatedAt  time.Time `json:"-"`
+	UpdatedAt  time.Time `json:"-"`
+	Version    int       `json:"version"`
+}
+
+func ValidateAddress(v *validator.Validator, address *ShippingAddress) {
+	v.Check(address.FullName != "", "full_name", "Full name must be provided")
+	v.Check(validator.MaxChars(address.FullName, 100), "full_name", "Full name cannot be more than 100 characters")
+	v.Check(address.Line1 != "", "line1", "Address must be provided")
+	v.Check(validator.MaxChars(address.Line1, 200), "line1", "Address cannot be more than 200 characters")
+	v.Check(validator.MaxChars(address.Line2, 200), "line2", "Address complement cannot be more than 200 characters")
+	v.Check(address.City != "", "city", "City must be provided")
+	v.Check(validator.MaxChars(address.City, 100), "city", "City cannot be more than 100 characters")
+	v.Check(validator.MaxChars(address.Region, 100), "region", "Region cannot be more than 100 characters")
+	v.Check(validator.MaxChars(address.PostalCode, 20), "postal_code", "Postal code cannot be more than 20 characters")
+	v.Check(validator.Matches(address.Country, CountryCodeRX), "country", "Country must be a two letter ISO code")
+	v.Check(validator.MaxChars(address.Phone, 30), "phone", "Phone cannot be more than 30 characters")
+}
+
+type AddressModel struct {
+	DB *sql.DB
+}
+
+func (m AddressModel) Insert(address *ShippingAddress) error {
+	query := `INSERT INTO shipping_address (user_id, full_name, line1, line2, city, region, postal_code, country, phone)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING address_id, created_at, updated_at, version`
+
+	args := []interface{}{
+		address.UserID,
+		address.FullName,
+		address.Line1,
+		address.Line2,
+		address.City,
+		address.Region,
+		address.PostalCode,
+		address.Country,
+		address.Phone,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&address.ID, &address.CreatedAt, &address.UpdatedAt, &address.Version)
+}
+
+func (m AddressModel) GetAllForUser(userID int) ([]*ShippingAddress, error) {
+	query := `SELECT address_id, user_id, full_name, line1, line2, city, region, postal_code, country, phone, created_at, updated_at, version
+	FROM shipping_address
+	WHERE user_id = $1
+	ORDER BY address_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []*ShippingAddress{}
+
+	for rows.Next() {
+		var address ShippingAddress
+
+		err := rows.Scan(
+			&address.ID,
+			&address.UserID,
+			&address.FullName,
+			&address.Line1,
+			&address.Line2,
+			&address.City,
+			&address.Region,
+			&address.PostalCode,
+			&address.Country,
+			&address.Phone,
+			&address.CreatedAt,
+			&address.UpdatedAt,
+			&address.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		addresses = append(addresses, &address)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return addresses, nil
+}
+
+func (m AddressModel) Get(id, userID int) (*ShippingAddress, error) {
+	if id < 1 {
+		return nil, ErrNoRecordFound
+	}
+
+	query := `SELECT address_id, user_id, full_name, line1, line2, city, region, postal_code, country, phone, created_at, updated_at, version
+	FROM shipping_address
+	WHERE address_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var address ShippingAddress
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&address.ID,
+		&address.UserID,
+		&address.FullName,
+		&address.Line1,
+		&address.Line2,
+		&address.City,
+		&address.Region,
+		&address.PostalCode,
+		&address.Country,
+		&address.Phone,
+		&address.CreatedAt,
+		&address.UpdatedAt,
+		&address.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &address, nil
+}
+
+func (m AddressModel) Update(address *ShippingAddress) error {
+	query := `UPDATE shipping_address SET
+	full_name = $1, line1 = $2, line2 = $3, city = $4, region = $5, postal_code = $6, country = $7, phone = $8, updated_at = NOW(), version = version + 1
+	WHERE address_id = $9 AND user_id = $10 AND version = $11
+	RETURNING updated_at, version`
+
+	args := []interface{}{
+		address.FullName,
+		address.Line1,
+		address.Line2,
+		address.City,
+		address.Region,
+		address.PostalCode,
+		address.Country,
+		address.Phone,
+		address.ID,
+		address.UserID,
+		address.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&address.UpdatedAt, &address.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m AddressModel) Delete(id, userID int) error {
+	if id < 1 {
+		return ErrNoRecordFound
+	}
+
+	query := `DELETE FROM shipping_address WHERE address_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecordFound
+	}
+
+	return nil
+}
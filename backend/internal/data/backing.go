@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"projectx/internal/validator"
 	"time"
 )
@@ -13,18 +14,37 @@ type Backing struct {
 	CreatedAt time.Time `json:"created_at"`
 	BackerID  int       `json:"backer_id"`
 	ProjectID int       `json:"project_id"`
+	IPAddress string    `json:"-"`
+	IsLate    bool      `json:"is_late"`
 }
 
 type Payment struct {
-	PaymentID     int       `json:"payment_id"`
-	Amount        float64   `json:"amount"`
-	Status        string    `json:"status"`
-	TransactionID string    `json:"transaction_id"`
-	PaymentMethod string    `json:"payment_method"`
-	BackingID     int       `json:"backing_id"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
-	Version       int       `json:"-"`
+	PaymentID      int       `json:"payment_id"`
+	Amount         float64   `json:"amount"`
+	Status         string    `json:"status"`
+	TransactionID  string    `json:"transaction_id"`
+	PaymentMethod  string    `json:"payment_method"`
+	BackingID      int       `json:"backing_id"`
+	RefundedAmount float64   `json:"refunded_amount"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Version        int       `json:"-"`
+}
+
+// PledgedRewards are the rewards a payment was made for, with the variant
+// picked for each and the shipment computed for them.
+type PledgedRewards struct {
+	RewardIDs []int
+	Variants  map[int]int
+	Shipment  *Shipment
+}
+
+type HeldPayment struct {
+	PaymentID     int
+	BackingID     int
+	Amount        float64
+	TransactionID string
+	BackerEmail   string
 }
 
 type Cancellation struct {
@@ -32,22 +52,69 @@ type Cancellation struct {
 	Reason         string    `json:"reason"`
 	Date           time.Time `json:"date"`
 	BackingID      int       `json:"backing_id"`
+	PaymentID      int       `json:"payment_id"`
+	Amount         float64   `json:"amount"`
+	Category       string    `json:"category"`
+	RefundID       string    `json:"refund_id,omitempty"`
+	RefundedBy     *int      `json:"refunded_by"`
+	Status         string    `json:"status"`
 	CreatedAt      time.Time `json:"created_at"`
 }
 
+// minimumPledge is 100DA in minor units.
+const minimumPledge = 10000
+
 func ValidateAmount(v *validator.Validator, amount float64) {
-	v.Check(amount >= 10000, "amount", "Pledge amount should be at least 100DA")
+	v.Check(amount >= minimumPledge, "amount", "Pledge amount should be at least 100DA")
+}
+
+// ValidatePledge checks that the selected rewards can be pledged for on this
+// project, with the variants picked for them, and that the amount, in minor
+// units, pays for all of them and for their shipping. Late pledges pay the late
+// price of the rewards that have one.
+func ValidatePledge(v *validator.Validator, projectID int, amount float64, rewardIDs []int, variants map[int]int, rewards []*Reward, shipping float64, late bool) {
+	v.Check(validator.Unique(rewardIDs), "rewards", "A reward cannot be selected more than once")
+
+	found := make(map[int]*Reward, len(rewards))
+	for _, reward := range rewards {
+		found[reward.ID] = reward
+	}
+
+	total := 0.0
+	for _, id := range rewardIDs {
+		reward, ok := found[id]
+		if !ok {
+			v.AddError("rewards", fmt.Sprintf("Reward %d not found", id))
+			continue
+		}
+		v.Check(reward.ProjectID == projectID, "rewards", fmt.Sprintf("Reward %d doesn't belong to this project", id))
+		v.Check(reward.IsAvailable, "rewards", fmt.Sprintf("Reward %d is not available anymore", id))
+		if late && reward.LateAmount != nil {
+			total += *reward.LateAmount
+		} else {
+			total += reward.Amount
+		}
+	}
+
+	validateSelection(v, rewardIDs, found, variants)
+
+	v.Check(amount >= total+shipping, "amount", fmt.Sprintf("Pledge amount should cover the selected rewards and their shipping (%.2fDA)", (total+shipping)/100))
 }
 
 func ValidateReason(v *validator.Validator, reason string) {
 	v.Check(validator.MaxChars(reason, 500), "reason", "Reason cannot be more than 50 characters")
 }
 
+var ErrDuplicateTransaction = errors.New("this payment has already been recorded")
+
 type BackingModel struct {
 	DB *sql.DB
 }
 
-func (m BackingModel) Insert(backing *Backing, payment *Payment) error {
+// Insert records the backing with its payment and claims its rewards, all in
+// one transaction. A reward that sold out in the meantime returns
+// ErrRewardSoldOut and nothing is recorded.
+func (m BackingModel) Insert(backing *Backing, payment *Payment, rewards *PledgedRewards) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -57,51 +124,100 @@ func (m BackingModel) Insert(backing *Backing, payment *Payment) error {
 	}
 	defer tx.Rollback()
 
-	query := `INSERT INTO backing (backer_id, project_id) VALUES ($1, $2) RETURNING backing_id, created_at`
+	err = insertBacking(ctx, tx, backing, payment, rewards)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func lockTransaction(ctx context.Context, tx *sql.Tx, transactionID string) (bool, error) {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, transactionID)
+	if err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM payment WHERE transaction_id = $1)`, transactionID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func insertBacking(ctx context.Context, tx *sql.Tx, backing *Backing, payment *Payment, rewards *PledgedRewards) error {
+	query := `INSERT INTO backing (backer_id, project_id, ip_address, is_late) VALUES ($1, $2, NULLIF($3, ''), $4) RETURNING backing_id, created_at`
 
 	args := []interface{}{
 		backing.BackerID,
 		backing.ProjectID,
+		backing.IPAddress,
+		backing.IsLate,
 	}
 
-	err = tx.QueryRowContext(ctx, query, args...).Scan(&backing.BackingID, &backing.CreatedAt)
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&backing.BackingID, &backing.CreatedAt)
+	if err != nil {
+		return err
+	}
 
+	payment.BackingID = backing.BackingID
+
+	err = insertPayment(ctx, tx, backing.ProjectID, payment)
 	if err != nil {
 		return err
 	}
 
-	query = `INSERT INTO payment (amount, status, transaction_id, payment_method, backing_id)
+	err = redeemPromoCode(ctx, tx, payment.TransactionID, backing.BackingID)
+	if err != nil {
+		return err
+	}
+
+	if rewards == nil || len(rewards.RewardIDs) == 0 {
+		return nil
+	}
+
+	return claimRewards(ctx, tx, backing.ProjectID, backing.BackingID, payment.TransactionID, rewards.RewardIDs, rewards.Variants, rewards.Shipment)
+}
+
+func insertPayment(ctx context.Context, tx *sql.Tx, projectID int, payment *Payment) error {
+	query := `INSERT INTO payment (amount, status, transaction_id, payment_method, backing_id)
 	VALUES ($1, $2, $3, $4, $5)
 	RETURNING payment_id, created_at, updated_at, version`
 
-	args = []interface{}{
+	args := []interface{}{
 		payment.Amount,
 		payment.Status,
 		payment.TransactionID,
 		payment.PaymentMethod,
-		backing.BackingID,
+		payment.BackingID,
 	}
 
-	err = tx.QueryRowContext(ctx, query, args...).Scan(
+	err := tx.QueryRowContext(ctx, query, args...).Scan(
 		&payment.PaymentID,
 		&payment.CreatedAt,
 		&payment.UpdatedAt,
 		&payment.Version,
 	)
-
 	if err != nil {
-		return err
-	}
-
-	if err = tx.Commit(); err != nil {
-		return err
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "payment_transaction_id_key"`:
+			return ErrDuplicateTransaction
+		default:
+			return err
+		}
 	}
 
-	return nil
+	return postPledge(ctx, tx, projectID, payment)
 }
 
 func (m BackingModel) GetBackersCountByProject(id int) (int, error) {
-	query := `SELECT COUNT(*) OVER() FROM backing b INNER JOIN payment p ON b.backing_id = p.backing_id WHERE project_id = $1 AND p.status <> 'refunded'`
+	query := `SELECT COUNT(DISTINCT b.backing_id) FROM backing b INNER JOIN payment p ON b.backing_id = p.backing_id WHERE project_id = $1 AND p.status IN ('succeeded', 'partially_refunded', 'requires_capture')`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -124,7 +240,7 @@ func (m BackingModel) DidIBackProject(backerID int, projectID int) (bool, error)
 	query := `
 	SELECT EXISTS (
 		SELECT 1 FROM backing b INNER JOIN payment p ON b.backing_id = p.backing_id WHERE
-		b.backer_id = $1 AND b.project_id = $2 AND p.status <> 'refunded'
+		b.backer_id = $1 AND b.project_id = $2 AND p.status IN ('succeeded', 'partially_refunded', 'requires_capture')
 	) AS did_i_back_it
 	`
 
@@ -190,7 +306,187 @@ func (m BackingModel) CheckBacking(backingID int) (*int, *string, error) {
 	return &paymentID, &transactionID, nil
 }
 
-func (m BackingModel) Refund(backingID int, reason string, paymentID int, refundDate time.Time) (*string, error) {
+func (m BackingModel) Delete(id int) error {
+	if id < 1 {
+		return ErrNoRecordFound
+	}
+
+	query := `DELETE FROM backing WHERE backing_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecordFound
+	}
+
+	return nil
+}
+
+func (m BackingModel) Update(payment Payment) error {
+	query := `UPDATE payment SET status = $1 WHERE payment_id = $2 RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		payment.Status,
+		payment.PaymentID,
+	}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&payment.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m BackingModel) GetPaymentByTransactionID(transactionID string) (*Payment, *Backing, error) {
+	query := `SELECT pa.payment_id, pa.amount, pa.status, pa.transaction_id, pa.payment_method, pa.backing_id, pa.created_at, pa.updated_at, pa.version, b.backer_id, b.project_id, b.created_at
+	FROM payment pa
+	INNER JOIN backing b ON b.backing_id = pa.backing_id
+	WHERE pa.transaction_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var payment Payment
+	var backing Backing
+
+	err := m.DB.QueryRowContext(ctx, query, transactionID).Scan(
+		&payment.PaymentID,
+		&payment.Amount,
+		&payment.Status,
+		&payment.TransactionID,
+		&payment.PaymentMethod,
+		&payment.BackingID,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+		&payment.Version,
+		&backing.BackerID,
+		&backing.ProjectID,
+		&backing.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrNoRecordFound
+		default:
+			return nil, nil, err
+		}
+	}
+	backing.BackingID = payment.BackingID
+
+	return &payment, &backing, nil
+}
+
+// ReconcileSucceeded is keyed on the transaction ID, so replayed events and
+// payments already recorded by the client don't create a second backing. Like
+// Insert, it returns ErrRewardSoldOut when a new backing can't get its rewards.
+func (m BackingModel) ReconcileSucceeded(backing *Backing, payment *Payment, rewards *PledgedRewards) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	exists, err := lockTransaction(ctx, tx, payment.TransactionID)
+	if err != nil {
+		return false, err
+	}
+
+	if exists {
+		query := `UPDATE payment SET status = $1, version = version + 1
+		WHERE transaction_id = $2 AND status <> $1 AND status IN ('processing', 'requires_action', 'requires_confirmation', 'requires_capture')
+		RETURNING payment_id`
+
+		var paymentID int
+
+		err = tx.QueryRowContext(ctx, query, payment.Status, payment.TransactionID).Scan(&paymentID)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+
+		if payment.Status == "succeeded" {
+			err = postCapture(ctx, tx, paymentID)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		return false, tx.Commit()
+	}
+
+	err = insertBacking(ctx, tx, backing, payment, rewards)
+	if err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (m BackingModel) ReconcileFailed(transactionID, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE payment SET status = $1, version = version + 1
+	WHERE transaction_id = $2 AND status NOT IN ('failed', 'canceled', 'refunded')
+	RETURNING payment_id`
+
+	var paymentID int
+
+	err = tx.QueryRowContext(ctx, query, status, transactionID).Scan(&paymentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNoRecordFound
+		default:
+			return err
+		}
+	}
+
+	err = postRefund(ctx, tx, paymentID, 0)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m BackingModel) ReconcileDisputed(transactionID, description string) (*Backing, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -200,30 +496,33 @@ func (m BackingModel) Refund(backingID int, reason string, paymentID int, refund
 	}
 	defer tx.Rollback()
 
-	var createdAt string
+	query := `UPDATE payment SET status = 'disputed', version = version + 1
+	WHERE transaction_id = $1 AND status <> 'disputed'
+	RETURNING backing_id`
+
+	var backing Backing
 
-	updateQuery := `UPDATE payment SET status = 'refunded' WHERE payment_id = $1 RETURNING created_at`
-	err = tx.QueryRowContext(ctx, updateQuery, paymentID).Scan(&createdAt)
+	err = tx.QueryRowContext(ctx, query, transactionID).Scan(&backing.BackingID)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrEditConflict
+			return nil, ErrNoRecordFound
 		default:
 			return nil, err
 		}
 	}
 
-	insertQuery := `INSERT INTO cancellation (reason, date, backing_id)
-	VALUES ($1, $2, $3)`
+	query = `SELECT backer_id, project_id, created_at FROM backing WHERE backing_id = $1`
 
-	args := []interface{}{
-		reason,
-		refundDate,
-		backingID,
+	err = tx.QueryRowContext(ctx, query, backing.BackingID).Scan(&backing.BackerID, &backing.ProjectID, &backing.CreatedAt)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = tx.ExecContext(ctx, insertQuery, args...)
+	query = `INSERT INTO dispute (status, type, description, context, reporter_id, project_id)
+	VALUES ('pending', 'chargeback', $1, 'project', $2, $3)`
 
+	_, err = tx.ExecContext(ctx, query, description, backing.BackerID, backing.ProjectID)
 	if err != nil {
 		return nil, err
 	}
@@ -232,47 +531,60 @@ func (m BackingModel) Refund(backingID int, reason string, paymentID int, refund
 		return nil, err
 	}
 
-	return &createdAt, nil
+	return &backing, nil
 }
 
-func (m BackingModel) Delete(id int) error {
-	if id < 1 {
-		return ErrNoRecordFound
-	}
-
-	query := `DELETE FROM backing WHERE backing_id = $1`
+func (m BackingModel) GetHeldPayments(projectID int) ([]*HeldPayment, error) {
+	query := `SELECT pa.payment_id, pa.backing_id, pa.amount, pa.transaction_id, u.email
+	FROM payment pa
+	INNER JOIN backing b ON b.backing_id = pa.backing_id
+	INNER JOIN user_t u ON u.user_id = b.backer_id
+	WHERE b.project_id = $1 AND pa.status = 'requires_capture'
+	ORDER BY pa.payment_id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	rows, err := m.DB.QueryContext(ctx, query, projectID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
+	payments := []*HeldPayment{}
+
+	for rows.Next() {
+		var payment HeldPayment
+
+		err := rows.Scan(&payment.PaymentID, &payment.BackingID, &payment.Amount, &payment.TransactionID, &payment.BackerEmail)
+		if err != nil {
+			return nil, err
+		}
+
+		payments = append(payments, &payment)
 	}
-	if rowsAffected == 0 {
-		return ErrNoRecordFound
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return payments, nil
 }
 
-func (m BackingModel) Update(payment Payment) error {
-	query := `UPDATE payment SET status = $1 WHERE payment_id = $2 RETURNING updated_at`
-
+func (m BackingModel) SettlePayment(paymentID int, status string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{
-		payment.Status,
-		payment.PaymentID,
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&payment.UpdatedAt)
+	query := `UPDATE payment SET status = $1, version = version + 1
+	WHERE payment_id = $2 AND status = 'requires_capture'
+	RETURNING payment_id`
+
+	err = tx.QueryRowContext(ctx, query, status, paymentID).Scan(&paymentID)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -282,5 +594,14 @@ func (m BackingModel) Update(payment Payment) error {
 		}
 	}
 
-	return nil
+	if status == "succeeded" {
+		err = postCapture(ctx, tx, paymentID)
+	} else {
+		err = postRefund(ctx, tx, paymentID, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
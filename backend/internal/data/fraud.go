@@ -0,0 +1,201 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// BackingReviewThreshold is the score from which a backing is flagged for
+	// review in the admin backings table.
+	BackingReviewThreshold = 50
+	// ProjectReviewThreshold is the score from which the backed project is
+	// flagged as suspicious. A project is flagged as well once it has
+	// FlaggedBackingsPerProject flagged backings.
+	ProjectReviewThreshold    = 80
+	FlaggedBackingsPerProject = 3
+)
+
+// freeEmailDomains are shared by too many people to tell anything about who
+// backs a project.
+var freeEmailDomains = []string{"gmail.com", "yahoo.com", "yahoo.fr", "hotmail.com", "hotmail.fr", "outlook.com", "live.com", "icloud.com", "proton.me", "protonmail.com"}
+
+// FraudFacts is what the fraud rules look at for one backing.
+type FraudFacts struct {
+	BackingID            int
+	ProjectID            int
+	Amount               float64
+	AccountAge           time.Duration
+	BackerEmail          string
+	CreatorEmail         string
+	RecentFromIP         int
+	RecentFromAccount    int
+	SmallFromNewAccounts int
+	RecentRefunds        int
+}
+
+type FraudScore struct {
+	BackingID      int      `json:"backing_id"`
+	Score          int      `json:"score"`
+	Signals        []string `json:"signals"`
+	Flagged        bool     `json:"flagged"`
+	ProjectFlagged bool     `json:"project_flagged"`
+}
+
+// ScoreBacking runs the fraud rules over the facts of a backing.
+func ScoreBacking(facts *FraudFacts) *FraudScore {
+	score := &FraudScore{BackingID: facts.BackingID, Signals: []string{}}
+
+	add := func(signal string, points int) {
+		score.Score += points
+		score.Signals = append(score.Signals, signal)
+	}
+
+	switch {
+	case facts.RecentFromIP >= 10:
+		add("ip_velocity", 40)
+	case facts.RecentFromIP >= 3:
+		add("ip_velocity", 25)
+	}
+
+	if facts.RecentFromAccount >= 3 {
+		add("account_velocity", 20)
+	}
+
+	newAccount := facts.AccountAge < 24*time.Hour
+	if newAccount && facts.Amount < 2*minimumPledge {
+		add("small_pledge_new_account", 15)
+	}
+	if facts.SmallFromNewAccounts >= 5 {
+		add("small_pledges_from_new_accounts", 30)
+	}
+
+	backerDomain := emailDomain(facts.BackerEmail)
+	if backerDomain != "" && backerDomain == emailDomain(facts.CreatorEmail) && !slices.Contains(freeEmailDomains, backerDomain) {
+		add("creator_email_domain", 30)
+	}
+
+	if facts.RecentRefunds >= 2 {
+		add("repeated_refunds", 20)
+	}
+
+	score.Flagged = score.Score >= BackingReviewThreshold
+
+	return score
+}
+
+func emailDomain(email string) string {
+	_, domain, found := strings.Cut(strings.ToLower(email), "@")
+	if !found {
+		return ""
+	}
+	return domain
+}
+
+// GetFraudFacts gathers what the fraud rules need to score a backing. Recent
+// means within the last hour for velocity and the last 30 days for refunds.
+func (m BackingModel) GetFraudFacts(backingID int) (*FraudFacts, error) {
+	query := `SELECT b.backing_id, b.project_id,
+		COALESCE((SELECT SUM(pa.amount) FROM payment pa WHERE pa.backing_id = b.backing_id), 0),
+		EXTRACT(EPOCH FROM b.created_at - u.created_at)::bigint,
+		u.email, c.email,
+		(SELECT COUNT(*) FROM backing o WHERE o.ip_address = b.ip_address AND o.backing_id <> b.backing_id AND o.created_at > b.created_at - INTERVAL '1 hour'),
+		(SELECT COUNT(*) FROM backing o WHERE o.backer_id = b.backer_id AND o.backing_id <> b.backing_id AND o.created_at > b.created_at - INTERVAL '1 hour'),
+		(SELECT COUNT(DISTINCT o.backing_id)
+			FROM backing o
+			INNER JOIN user_t ou ON ou.user_id = o.backer_id
+			INNER JOIN payment opa ON opa.backing_id = o.backing_id
+			WHERE o.project_id = b.project_id AND o.backing_id <> b.backing_id
+			AND o.created_at > b.created_at - INTERVAL '1 day'
+			AND o.created_at - ou.created_at < INTERVAL '1 day'
+			AND opa.amount < $2),
+		(SELECT COUNT(*) FROM cancellation ca INNER JOIN backing o ON o.backing_id = ca.backing_id
+			WHERE o.backer_id = b.backer_id AND ca.category = 'backer_request' AND ca.status <> 'failed' AND ca.created_at > NOW() - INTERVAL '30 days')
+	FROM backing b
+	INNER JOIN user_t u ON u.user_id = b.backer_id
+	INNER JOIN project p ON p.project_id = b.project_id
+	INNER JOIN user_t c ON c.user_id = p.creator_id
+	WHERE b.backing_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var facts FraudFacts
+	var accountAge int64
+
+	err := m.DB.QueryRowContext(ctx, query, backingID, 2*minimumPledge).Scan(
+		&facts.BackingID,
+		&facts.ProjectID,
+		&facts.Amount,
+		&accountAge,
+		&facts.BackerEmail,
+		&facts.CreatorEmail,
+		&facts.RecentFromIP,
+		&facts.RecentFromAccount,
+		&facts.SmallFromNewAccounts,
+		&facts.RecentRefunds,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	facts.AccountAge = time.Duration(accountAge) * time.Second
+
+	return &facts, nil
+}
+
+// SaveFraudScore stores the score of a backing and flags its project as
+// suspicious when the backing, or the number of flagged backings, calls for
+// it.
+func (m BackingModel) SaveFraudScore(projectID int, score *FraudScore) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE backing SET fraud_score = $1, fraud_signals = COALESCE($2::text[], '{}'), flagged_for_review = $3 WHERE backing_id = $4`
+
+	_, err = tx.ExecContext(ctx, query, score.Score, pq.Array(score.Signals), score.Flagged, score.BackingID)
+	if err != nil {
+		return err
+	}
+
+	var flaggedBackings int
+
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM backing WHERE project_id = $1 AND flagged_for_review`, projectID).Scan(&flaggedBackings)
+	if err != nil {
+		return err
+	}
+
+	if score.Score >= ProjectReviewThreshold || flaggedBackings >= FlaggedBackingsPerProject {
+		query = `UPDATE project SET is_suspicious = TRUE, version = version + 1 WHERE project_id = $1 AND NOT is_suspicious`
+
+		result, err := tx.ExecContext(ctx, query, projectID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		score.ProjectFlagged = rowsAffected > 0
+	}
+
+	return tx.Commit()
+}
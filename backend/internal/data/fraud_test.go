@@ -0,0 +1,125 @@
+package data
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestScoreBacking(t *testing.T) {
+	// a backing none of the rules pick up, each case changes what it tests
+	clean := func() *FraudFacts {
+		return &FraudFacts{
+			BackingID:    1,
+			Amount:       50000,
+			AccountAge:   30 * 24 * time.Hour,
+			BackerEmail:  "backer@gmail.com",
+			CreatorEmail: "creator@studio.dz",
+		}
+	}
+
+	tests := []struct {
+		name        string
+		change      func(f *FraudFacts)
+		wantScore   int
+		wantSignals []string
+		wantFlagged bool
+	}{
+		{
+			name:        "clean backing",
+			change:      func(f *FraudFacts) {},
+			wantSignals: []string{},
+		},
+		{
+			name:        "a few backings from the same ip",
+			change:      func(f *FraudFacts) { f.RecentFromIP = 3 },
+			wantScore:   25,
+			wantSignals: []string{"ip_velocity"},
+		},
+		{
+			name:        "many backings from the same ip",
+			change:      func(f *FraudFacts) { f.RecentFromIP = 10 },
+			wantScore:   40,
+			wantSignals: []string{"ip_velocity"},
+		},
+		{
+			name:        "many backings from the same account",
+			change:      func(f *FraudFacts) { f.RecentFromAccount = 3 },
+			wantScore:   20,
+			wantSignals: []string{"account_velocity"},
+		},
+		{
+			name: "small pledge from a new account",
+			change: func(f *FraudFacts) {
+				f.AccountAge = time.Hour
+				f.Amount = minimumPledge
+			},
+			wantScore:   15,
+			wantSignals: []string{"small_pledge_new_account"},
+		},
+		{
+			name:        "large pledge from a new account",
+			change:      func(f *FraudFacts) { f.AccountAge = time.Hour },
+			wantSignals: []string{},
+		},
+		{
+			name:        "small pledges from new accounts",
+			change:      func(f *FraudFacts) { f.SmallFromNewAccounts = 5 },
+			wantScore:   30,
+			wantSignals: []string{"small_pledges_from_new_accounts"},
+		},
+		{
+			name: "same email domain as the creator",
+			change: func(f *FraudFacts) {
+				f.BackerEmail = "friend@Studio.dz"
+			},
+			wantScore:   30,
+			wantSignals: []string{"creator_email_domain"},
+		},
+		{
+			name: "same free email domain as the creator",
+			change: func(f *FraudFacts) {
+				f.CreatorEmail = "creator@gmail.com"
+			},
+			wantSignals: []string{},
+		},
+		{
+			name:        "repeated refunds",
+			change:      func(f *FraudFacts) { f.RecentRefunds = 2 },
+			wantScore:   20,
+			wantSignals: []string{"repeated_refunds"},
+		},
+		{
+			name: "flagged once over the threshold",
+			change: func(f *FraudFacts) {
+				f.RecentFromIP = 10
+				f.RecentFromAccount = 3
+			},
+			wantScore:   60,
+			wantSignals: []string{"ip_velocity", "account_velocity"},
+			wantFlagged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			facts := clean()
+			tt.change(facts)
+
+			score := ScoreBacking(facts)
+
+			if score.BackingID != facts.BackingID {
+				t.Errorf("backing id = %d, want %d", score.BackingID, facts.BackingID)
+			}
+			if score.Score != tt.wantScore {
+				t.Errorf("score = %d, want %d", score.Score, tt.wantScore)
+			}
+			if !slices.Equal(score.Signals, tt.wantSignals) {
+				t.Errorf("signals = %v, want %v", score.Signals, tt.wantSignals)
+			}
+			if score.Flagged != tt.wantFlagged {
+				t.Errorf("flagged = %t, want %t", score.Flagged, tt.wantFlagged)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"projectx/internal/validator"
+	"slices"
+	"time"
+)
+
+const (
+	FulfillmentPending      = "pending"
+	FulfillmentInProduction = "in_production"
+	FulfillmentShipped      = "shipped"
+	FulfillmentDelivered    = "delivered"
+	FulfillmentFailed       = "failed"
+)
+
+var FulfillmentStatuses = []string{FulfillmentPending, FulfillmentInProduction, FulfillmentShipped, FulfillmentDelivered, FulfillmentFailed}
+
+// fulfillmentTransitions lists the statuses a backed reward can move to from
+// each status. A failed delivery can be produced or shipped again.
+var fulfillmentTransitions = map[string][]string{
+	FulfillmentPending:      {FulfillmentInProduction, FulfillmentShipped, FulfillmentFailed},
+	FulfillmentInProduction: {FulfillmentShipped, FulfillmentFailed},
+	FulfillmentShipped:      {FulfillmentShipped, FulfillmentDelivered, FulfillmentFailed},
+	FulfillmentFailed:       {FulfillmentInProduction, FulfillmentShipped},
+	FulfillmentDelivered:    {},
+}
+
+var ErrInvalidFulfillment = errors.New("invalid fulfillment status change")
+
+type Fulfillment struct {
+	Status         string              `json:"status"`
+	Carrier        string              `json:"carrier,omitempty"`
+	TrackingNumber string              `json:"tracking_number,omitempty"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+	History        []*FulfillmentEvent `json:"history"`
+}
+
+type FulfillmentEvent struct {
+	Status         string    `json:"status"`
+	Carrier        string    `json:"carrier,omitempty"`
+	TrackingNumber string    `json:"tracking_number,omitempty"`
+	ChangedBy      *int      `json:"changed_by"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// FulfillmentUpdate changes the status of a backed reward. A nil carrier or
+// tracking number keeps the current one.
+type FulfillmentUpdate struct {
+	BackingID      int     `json:"backing_id"`
+	RewardID       int     `json:"reward_id"`
+	Status         string  `json:"status"`
+	Carrier        *string `json:"carrier"`
+	TrackingNumber *string `json:"tracking_number"`
+}
+
+// FulfillmentChange is a status change that was applied, along with what is
+// needed to let the backer know about it.
+type FulfillmentChange struct {
+	BackingID      int    `json:"backing_id"`
+	RewardID       int    `json:"reward_id"`
+	Status         string `json:"status"`
+	Carrier        string `json:"carrier,omitempty"`
+	TrackingNumber string `json:"tracking_number,omitempty"`
+	BackerID       int    `json:"-"`
+	BackerEmail    string `json:"-"`
+	ProjectID      int    `json:"-"`
+	ProjectTitle   string `json:"-"`
+	RewardTitle    string `json:"-"`
+}
+
+func ValidateFulfillmentUpdates(v *validator.Validator, updates []*FulfillmentUpdate) {
+	v.Check(len(updates) > 0, "updates", "At least one update must be provided")
+	v.Check(len(updates) <= 500, "updates", "Cannot update more than 500 rewards at once")
+
+	seen := map[[2]int]bool{}
+	for i, u := range updates {
+		key := [2]int{u.BackingID, u.RewardID}
+		v.Check(!seen[key], "updates", fmt.Sprintf("Update %d is a duplicate", i+1))
+		seen[key] = true
+
+		v.Check(validator.In(u.Status, FulfillmentStatuses...), "updates", fmt.Sprintf("Status of update %d is invalid", i+1))
+		if u.Carrier != nil {
+			v.Check(validator.MaxChars(*u.Carrier, 100), "updates", fmt.Sprintf("Carrier of update %d cannot be more than 100 characters", i+1))
+		}
+		if u.TrackingNumber != nil {
+			v.Check(validator.MaxChars(*u.TrackingNumber, 100), "updates", fmt.Sprintf("Tracking number of update %d cannot be more than 100 characters", i+1))
+		}
+	}
+}
+
+type FulfillmentModel struct {
+	DB *sql.DB
+}
+
+// Update applies the status changes to rewards backed on the project, all or
+// none of them.
+func (m FulfillmentModel) Update(projectID, changedBy int, updates []*FulfillmentUpdate) ([]*FulfillmentChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	changes := []*FulfillmentChange{}
+
+	for _, u := range updates {
+		change, err := lockBackingReward(ctx, tx, u.BackingID, u.RewardID)
+		if err != nil {
+			return nil, err
+		}
+		if change.ProjectID != projectID {
+			return nil, fmt.Errorf("backing %d reward %d: %w", u.BackingID, u.RewardID, ErrNoRecordFound)
+		}
+
+		err = applyFulfillment(ctx, tx, change, u, changedBy)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// ConfirmReceipt marks a shipped reward as delivered on behalf of its backer.
+func (m FulfillmentModel) ConfirmReceipt(backingID, rewardID, backerID int) (*FulfillmentChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	change, err := lockBackingReward(ctx, tx, backingID, rewardID)
+	if err != nil {
+		return nil, err
+	}
+	if change.BackerID != backerID {
+		return nil, ErrNoRecordFound
+	}
+	if change.Status != FulfillmentShipped {
+		return nil, fmt.Errorf("reward hasn't been shipped yet: %w", ErrInvalidFulfillment)
+	}
+
+	err = applyFulfillment(ctx, tx, change, &FulfillmentUpdate{BackingID: backingID, RewardID: rewardID, Status: FulfillmentDelivered}, backerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+func lockBackingReward(ctx context.Context, tx *sql.Tx, backingID, rewardID int) (*FulfillmentChange, error) {
+	query := `SELECT br.fulfillment_status, COALESCE(br.carrier, ''), COALESCE(br.tracking_number, ''), b.backer_id, u.email, b.project_id, p.title, r.title
+	FROM backing_reward br
+	INNER JOIN backing b ON b.backing_id = br.backing_id
+	INNER JOIN user_t u ON u.user_id = b.backer_id
+	INNER JOIN project p ON p.project_id = b.project_id
+	INNER JOIN reward r ON r.reward_id = br.reward_id
+	WHERE br.backing_id = $1 AND br.reward_id = $2
+	FOR UPDATE OF br`
+
+	change := &FulfillmentChange{BackingID: backingID, RewardID: rewardID}
+
+	err := tx.QueryRowContext(ctx, query, backingID, rewardID).Scan(
+		&change.Status,
+		&change.Carrier,
+		&change.TrackingNumber,
+		&change.BackerID,
+		&change.BackerEmail,
+		&change.ProjectID,
+		&change.ProjectTitle,
+		&change.RewardTitle,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, fmt.Errorf("backing %d reward %d: %w", backingID, rewardID, ErrNoRecordFound)
+		default:
+			return nil, err
+		}
+	}
+
+	return change, nil
+}
+
+func applyFulfillment(ctx context.Context, tx *sql.Tx, change *FulfillmentChange, u *FulfillmentUpdate, changedBy int) error {
+	if !slices.Contains(fulfillmentTransitions[change.Status], u.Status) {
+		return fmt.Errorf("backing %d reward %d cannot go from %s to %s: %w", u.BackingID, u.RewardID, change.Status, u.Status, ErrInvalidFulfillment)
+	}
+
+	change.Status = u.Status
+	if u.Carrier != nil {
+		change.Carrier = *u.Carrier
+	}
+	if u.TrackingNumber != nil {
+		change.TrackingNumber = *u.TrackingNumber
+	}
+
+	query := `UPDATE backing_reward
+	SET fulfillment_status = $1, carrier = NULLIF($2, ''), tracking_number = NULLIF($3, ''), fulfillment_updated_at = NOW()
+	WHERE backing_id = $4 AND reward_id = $5`
+
+	_, err := tx.ExecContext(ctx, query, change.Status, change.Carrier, change.TrackingNumber, change.BackingID, change.RewardID)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO fulfillment_event (backing_id, reward_id, status, carrier, tracking_number, changed_by)
+	VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)`
+
+	_, err = tx.ExecContext(ctx, query, change.BackingID, change.RewardID, change.Status, change.Carrier, change.TrackingNumber, changedBy)
+	return err
+}
+
+func loadFulfillmentHistory(ctx context.Context, q querier, backingID int) (map[int][]*FulfillmentEvent, error) {
+	query := `SELECT reward_id, status, COALESCE(carrier, ''), COALESCE(tracking_number, ''), changed_by, created_at
+	FROM fulfillment_event
+	WHERE backing_id = $1
+	ORDER BY created_at, event_id`
+
+	rows, err := q.QueryContext(ctx, query, backingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := map[int][]*FulfillmentEvent{}
+
+	for rows.Next() {
+		var rewardID int
+		var event FulfillmentEvent
+
+		err := rows.Scan(&rewardID, &event.Status, &event.Carrier, &event.TrackingNumber, &event.ChangedBy, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		history[rewardID] = append(history[rewardID], &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
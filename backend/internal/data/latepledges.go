@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"projectx/internal/validator"
+	"time"
+)
+
+// LatePrice is what a reward costs when pledged for after the campaign.
+// A nil LateAmount goes back to the campaign price.
+type LatePrice struct {
+	RewardID   int      `json:"reward_id"`
+	LateAmount *float64 `json:"late_amount"`
+}
+
+// TakesLatePledges tells whether the project takes pledges at now although
+// its campaign is over.
+func (p *Project) TakesLatePledges(now time.Time) bool {
+	if p.Status != "Completed" || !p.LatePledges {
+		return false
+	}
+	return p.LatePledgesUntil == nil || now.Before(*p.LatePledgesUntil)
+}
+
+func ValidateLatePledges(v *validator.Validator, until *time.Time, prices []LatePrice) {
+	if until != nil {
+		v.Check(until.After(time.Now()), "until", "Late pledges end date must be in the future")
+	}
+
+	ids := make([]int, len(prices))
+	for i, price := range prices {
+		ids[i] = price.RewardID
+		if price.LateAmount != nil {
+			v.Check(*price.LateAmount >= minimumPledge, "prices", fmt.Sprintf("Late price of reward %d must be at least %dDA", price.RewardID, minimumPledge/100))
+		}
+	}
+	v.Check(validator.Unique(ids), "prices", "A reward cannot be priced more than once")
+}
+
+// UpdateLatePledges turns late pledges on or off for the project and sets the
+// late price of its rewards.
+func (m ProjectModel) UpdateLatePledges(projectID int, enabled bool, until *time.Time, prices []LatePrice) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE project SET late_pledges = $1, late_pledges_until = $2, version = version + 1
+	WHERE project_id = $3 AND status = 'Completed' AND deleted_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, enabled, until, projectID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	query = `UPDATE reward SET late_amount = $1, updated_at = NOW() WHERE reward_id = $2 AND project_id = $3`
+
+	for _, price := range prices {
+		result, err := tx.ExecContext(ctx, query, price.LateAmount, price.RewardID, projectID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("reward %d: %w", price.RewardID, ErrNoRecordFound)
+		}
+	}
+
+	return tx.Commit()
+}
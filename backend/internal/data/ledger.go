@@ -0,0 +1,342 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	LedgerPledge  = "pledge"
+	LedgerRefund  = "refund"
+	LedgerCapture = "capture"
+	LedgerFee     = "fee"
+	LedgerPayout  = "payout"
+)
+
+// Every ledger entry moves its amount out of the credit account and into the
+// debit account. Pledges to all-or-nothing projects sit in project_held until
+// they are captured. Fees go to the platform or to the payment processor.
+const (
+	AccountBacker      = "backer"
+	AccountProject     = "project"
+	AccountProjectHeld = "project_held"
+	AccountPlatform    = "platform"
+	AccountProcessor   = "processor"
+	AccountCreator     = "creator"
+)
+
+type LedgerEntry struct {
+	EntryID       int    `json:"entry_id"`
+	ProjectID     int    `json:"project_id"`
+	PaymentID     *int   `json:"payment_id,omitempty"`
+	PayoutID      *int   `json:"payout_id,omitempty"`
+	EntryType     string `json:"entry_type"`
+	DebitAccount  string `json:"debit_account"`
+	CreditAccount string `json:"credit_account"`
+	Amount        int64  `json:"amount"`
+	CreatedAt     string `json:"created_at"`
+}
+
+type FundingDrift struct {
+	ProjectID       int             `json:"project_id"`
+	Title           string          `json:"title"`
+	LedgerFunding   int64           `json:"ledger_funding"`
+	ExpectedFunding int64           `json:"expected_funding"`
+	Drift           int64           `json:"drift"`
+	Payments        []*PaymentDrift `json:"payments"`
+}
+
+// PaymentDrift with a nil PaymentID stands for ledger entries whose payment was
+// deleted, which shouldn't count towards the project anymore.
+type PaymentDrift struct {
+	PaymentID     *int   `json:"payment_id"`
+	TransactionID string `json:"transaction_id,omitempty"`
+	Status        string `json:"status,omitempty"`
+	Recorded      int64  `json:"recorded"`
+	Expected      int64  `json:"expected"`
+}
+
+type LedgerModel struct {
+	DB *sql.DB
+}
+
+func minorUnits(amount float64) int64 {
+	return int64(math.Round(amount))
+}
+
+func insertLedgerEntry(ctx context.Context, tx *sql.Tx, entry *LedgerEntry) error {
+	query := `INSERT INTO funding_ledger (project_id, payment_id, payout_id, entry_type, debit_account, credit_account, amount)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING entry_id, created_at`
+
+	args := []interface{}{
+		entry.ProjectID,
+		entry.PaymentID,
+		entry.PayoutID,
+		entry.EntryType,
+		entry.DebitAccount,
+		entry.CreditAccount,
+		entry.Amount,
+	}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&entry.EntryID, &entry.CreatedAt)
+}
+
+func postPledge(ctx context.Context, tx *sql.Tx, projectID int, payment *Payment) error {
+	account := AccountProject
+	if payment.Status == "requires_capture" {
+		account = AccountProjectHeld
+	}
+
+	err := insertLedgerEntry(ctx, tx, &LedgerEntry{
+		ProjectID:     projectID,
+		PaymentID:     &payment.PaymentID,
+		EntryType:     LedgerPledge,
+		DebitAccount:  account,
+		CreditAccount: AccountBacker,
+		Amount:        minorUnits(payment.Amount),
+	})
+	if err != nil {
+		return err
+	}
+
+	return postMatch(ctx, tx, projectID, payment.PaymentID, minorUnits(payment.Amount))
+}
+
+// pledgedBalance returns how much of a payment is still pledged and the account
+// holding it. ErrNoRecordFound means nothing was ever pledged for the payment.
+func pledgedBalance(ctx context.Context, tx *sql.Tx, paymentID int) (projectID int, account string, balance int64, err error) {
+	query := `SELECT project_id,
+		CASE WHEN bool_or(entry_type = 'capture') THEN 'project' ELSE MAX(debit_account) FILTER (WHERE entry_type = 'pledge') END,
+		SUM(CASE entry_type WHEN 'pledge' THEN amount WHEN 'refund' THEN -amount ELSE 0 END)
+	FROM funding_ledger
+	WHERE payment_id = $1 AND entry_type IN ('pledge', 'refund', 'capture')
+	GROUP BY project_id`
+
+	err = tx.QueryRowContext(ctx, query, paymentID).Scan(&projectID, &account, &balance)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, "", 0, ErrNoRecordFound
+		default:
+			return 0, "", 0, err
+		}
+	}
+
+	return projectID, account, balance, nil
+}
+
+// postRefund gives amount of a payment back to the backer, or whatever is left
+// of it when amount is 0. Sponsors get back what they matched beyond what is
+// left.
+func postRefund(ctx context.Context, tx *sql.Tx, paymentID int, amount int64) error {
+	projectID, account, balance, err := pledgedBalance(ctx, tx, paymentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoRecordFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if amount == 0 || amount > balance {
+		amount = balance
+	}
+	if amount <= 0 {
+		return nil
+	}
+
+	err = insertLedgerEntry(ctx, tx, &LedgerEntry{
+		ProjectID:     projectID,
+		PaymentID:     &paymentID,
+		EntryType:     LedgerRefund,
+		DebitAccount:  AccountBacker,
+		CreditAccount: account,
+		Amount:        amount,
+	})
+	if err != nil {
+		return err
+	}
+
+	return reverseMatches(ctx, tx, paymentID, balance-amount)
+}
+
+func postCapture(ctx context.Context, tx *sql.Tx, paymentID int) error {
+	projectID, account, balance, err := pledgedBalance(ctx, tx, paymentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoRecordFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if account != AccountProjectHeld || balance <= 0 {
+		return nil
+	}
+
+	return insertLedgerEntry(ctx, tx, &LedgerEntry{
+		ProjectID:     projectID,
+		PaymentID:     &paymentID,
+		EntryType:     LedgerCapture,
+		DebitAccount:  AccountProject,
+		CreditAccount: AccountProjectHeld,
+		Amount:        balance,
+	})
+}
+
+func (m LedgerModel) GetFundingDrift() ([]*FundingDrift, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return fundingDrift(ctx, tx)
+}
+
+// RepairFundingDrift posts the entries that bring every drifting payment back
+// in line with its status. The ledger is locked against new entries while the
+// drift is computed and repaired.
+func (m LedgerModel) RepairFundingDrift() ([]*FundingDrift, []*LedgerEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `LOCK TABLE funding_ledger IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	drifts, err := fundingDrift(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	corrections := []*LedgerEntry{}
+
+	for _, drift := range drifts {
+		for _, payment := range drift.Payments {
+			entry := &LedgerEntry{
+				ProjectID: drift.ProjectID,
+				PaymentID: payment.PaymentID,
+			}
+
+			diff := payment.Expected - payment.Recorded
+			switch {
+			case diff > 0:
+				entry.EntryType = LedgerPledge
+				entry.DebitAccount = AccountProject
+				if payment.Status == "requires_capture" {
+					entry.DebitAccount = AccountProjectHeld
+				}
+				entry.CreditAccount = AccountBacker
+				entry.Amount = diff
+			case diff < 0:
+				entry.EntryType = LedgerRefund
+				entry.DebitAccount = AccountBacker
+				entry.CreditAccount = AccountProject
+				if payment.PaymentID != nil {
+					_, account, _, err := pledgedBalance(ctx, tx, *payment.PaymentID)
+					if err != nil {
+						return nil, nil, err
+					}
+					entry.CreditAccount = account
+				}
+				entry.Amount = -diff
+			default:
+				continue
+			}
+
+			err = insertLedgerEntry(ctx, tx, entry)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			corrections = append(corrections, entry)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return drifts, corrections, nil
+}
+
+func fundingDrift(ctx context.Context, tx *sql.Tx) ([]*FundingDrift, error) {
+	query := `
+	WITH recorded AS (
+		SELECT project_id, payment_id, SUM(CASE entry_type WHEN 'pledge' THEN amount WHEN 'refund' THEN -amount ELSE 0 END) AS amount
+		FROM funding_ledger
+		GROUP BY project_id, payment_id
+	),
+	expected AS (
+		SELECT b.project_id, pa.payment_id, pa.transaction_id, pa.status,
+			CASE WHEN pa.status IN ('failed', 'canceled', 'refunded') THEN 0 ELSE ROUND(pa.amount - pa.refunded_amount)::bigint END AS amount
+		FROM payment pa
+		INNER JOIN backing b ON b.backing_id = pa.backing_id
+	)
+	SELECT pr.project_id, pr.title, e.payment_id, COALESCE(e.transaction_id, ''), COALESCE(e.status, ''), COALESCE(r.amount, 0), COALESCE(e.amount, 0)
+	FROM expected e
+	FULL OUTER JOIN recorded r ON r.payment_id = e.payment_id AND r.project_id = e.project_id
+	INNER JOIN project pr ON pr.project_id = COALESCE(e.project_id, r.project_id)
+	WHERE COALESCE(r.amount, 0) <> COALESCE(e.amount, 0)
+	ORDER BY pr.project_id, e.payment_id`
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drifts := []*FundingDrift{}
+	var current *FundingDrift
+
+	for rows.Next() {
+		var projectID int
+		var title string
+		var payment PaymentDrift
+
+		err := rows.Scan(&projectID, &title, &payment.PaymentID, &payment.TransactionID, &payment.Status, &payment.Recorded, &payment.Expected)
+		if err != nil {
+			return nil, err
+		}
+
+		if current == nil || current.ProjectID != projectID {
+			current = &FundingDrift{ProjectID: projectID, Title: title}
+			drifts = append(drifts, current)
+		}
+
+		current.Payments = append(current.Payments, &payment)
+		current.Drift += payment.Recorded - payment.Expected
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, drift := range drifts {
+		query := `SELECT (project_funding($1) * 100)::bigint`
+
+		err := tx.QueryRowContext(ctx, query, drift.ProjectID).Scan(&drift.LedgerFunding)
+		if err != nil {
+			return nil, err
+		}
+		drift.ExpectedFunding = drift.LedgerFunding - drift.Drift
+	}
+
+	return drifts, nil
+}
@@ -0,0 +1,424 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"projectx/internal/validator"
+	"time"
+)
+
+const (
+	MembershipIncomplete = "incomplete"
+	MembershipActive     = "active"
+	MembershipPastDue    = "past_due"
+	MembershipCancelled  = "cancelled"
+)
+
+var ErrAlreadyMember = errors.New("user is already a member of this creator")
+
+// MembershipTier is a monthly support level a creator offers once one of their
+// projects is completed. Amount is in minor units, like reward amounts.
+type MembershipTier struct {
+	ID          int       `json:"id"`
+	CreatorID   int       `json:"creator_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Amount      float64   `json:"amount"`
+	PlanID      string    `json:"-"`
+	IsActive    bool      `json:"is_active"`
+	Members     int       `json:"members"`
+	CreatedAt   time.Time `json:"-"`
+	Version     int       `json:"version"`
+}
+
+// Membership is a user's monthly support of a creator. Members keep their
+// perks until CurrentPeriodEnd when they cancel.
+type Membership struct {
+	ID                int        `json:"id"`
+	TierID            int        `json:"tier_id"`
+	TierTitle         string     `json:"tier_title"`
+	Amount            float64    `json:"amount"`
+	CreatorID         int        `json:"creator_id"`
+	MemberID          int        `json:"member_id"`
+	MemberEmail       string     `json:"-"`
+	SubscriptionID    string     `json:"-"`
+	Status            string     `json:"status"`
+	CurrentPeriodEnd  *time.Time `json:"current_period_end"`
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end"`
+	PastDueSince      *time.Time `json:"past_due_since"`
+	CancelledAt       *time.Time `json:"cancelled_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+	Version           int        `json:"version"`
+}
+
+// MembershipPayment is a paid month of a membership, in minor units.
+type MembershipPayment struct {
+	InvoiceID string
+	Amount    int64
+}
+
+func ValidateMembershipTier(v *validator.Validator, tier *MembershipTier) {
+	v.Check(tier.Title != "", "title", "Title must be provided")
+	v.Check(validator.MaxChars(tier.Title, 70), "title", "Title cannot be more than 70 characters")
+	v.Check(validator.MaxChars(tier.Description, 1000), "description", "Description cannot be more than 1000 characters")
+	v.Check(tier.Amount >= minimumPledge, "amount", "Amount must be at least the minimum pledge")
+}
+
+type MembershipModel struct {
+	DB *sql.DB
+}
+
+// HasCompletedProject tells whether the creator has a project that reached the
+// end of its campaign, which memberships are kept for.
+func (m MembershipModel) HasCompletedProject(creatorID int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM project WHERE creator_id = $1 AND status = 'Completed' AND deleted_at IS NULL)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var completed bool
+
+	err := m.DB.QueryRowContext(ctx, query, creatorID).Scan(&completed)
+	return completed, err
+}
+
+func (m MembershipModel) InsertTier(tier *MembershipTier) error {
+	query := `INSERT INTO membership_tier (creator_id, title, description, amount, plan_id)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING tier_id, is_active, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{tier.CreatorID, tier.Title, tier.Description, tier.Amount, tier.PlanID}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&tier.ID, &tier.IsActive, &tier.CreatedAt, &tier.Version)
+}
+
+const membershipTierColumns = `t.tier_id, t.creator_id, t.title, t.description, t.amount, t.plan_id, t.is_active, t.created_at, t.version,
+	(SELECT COUNT(*) FROM membership m WHERE m.tier_id = t.tier_id AND m.status IN ('active', 'past_due'))`
+
+func scanMembershipTier(row rowScanner) (*MembershipTier, error) {
+	var tier MembershipTier
+
+	err := row.Scan(
+		&tier.ID,
+		&tier.CreatorID,
+		&tier.Title,
+		&tier.Description,
+		&tier.Amount,
+		&tier.PlanID,
+		&tier.IsActive,
+		&tier.CreatedAt,
+		&tier.Version,
+		&tier.Members,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tier, nil
+}
+
+func (m MembershipModel) GetTier(id int) (*MembershipTier, error) {
+	query := `SELECT ` + membershipTierColumns + ` FROM membership_tier t WHERE t.tier_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tier, err := scanMembershipTier(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	return tier, nil
+}
+
+// GetTiers returns the creator's tiers, only the ones open to new members
+// when activeOnly is set.
+func (m MembershipModel) GetTiers(creatorID int, activeOnly bool) ([]*MembershipTier, error) {
+	query := `SELECT ` + membershipTierColumns + ` FROM membership_tier t
+	WHERE t.creator_id = $1 AND (t.is_active OR NOT $2)
+	ORDER BY t.amount`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, creatorID, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tiers := []*MembershipTier{}
+
+	for rows.Next() {
+		tier, err := scanMembershipTier(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tiers = append(tiers, tier)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tiers, nil
+}
+
+// UpdateTier saves the tier's title, description and whether it takes new
+// members. The amount is part of the billing plan and can't change.
+func (m MembershipModel) UpdateTier(tier *MembershipTier) error {
+	query := `UPDATE membership_tier SET title = $1, description = $2, is_active = $3, version = version + 1
+	WHERE tier_id = $4 AND version = $5
+	RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{tier.Title, tier.Description, tier.IsActive, tier.ID, tier.Version}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&tier.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Insert records a new membership along with its first payment, if any. A
+// user can only have one membership per creator at a time.
+func (m MembershipModel) Insert(membership *Membership, payment *MembershipPayment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// serializes joins of the same member
+	_, err = tx.ExecContext(ctx, `SELECT 1 FROM user_t WHERE user_id = $1 FOR UPDATE`, membership.MemberID)
+	if err != nil {
+		return err
+	}
+
+	query := `SELECT EXISTS (SELECT 1 FROM membership m INNER JOIN membership_tier t ON t.tier_id = m.tier_id
+		WHERE m.member_id = $1 AND t.creator_id = $2 AND m.status <> 'cancelled')`
+
+	var member bool
+
+	err = tx.QueryRowContext(ctx, query, membership.MemberID, membership.CreatorID).Scan(&member)
+	if err != nil {
+		return err
+	}
+	if member {
+		return ErrAlreadyMember
+	}
+
+	query = `INSERT INTO membership (tier_id, member_id, subscription_id, status, current_period_end, past_due_since)
+	VALUES ($1, $2, $3, $4, $5, CASE WHEN $4 = 'past_due' THEN NOW() END)
+	RETURNING membership_id, past_due_since, created_at, version`
+
+	args := []interface{}{membership.TierID, membership.MemberID, membership.SubscriptionID, membership.Status, membership.CurrentPeriodEnd}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&membership.ID, &membership.PastDueSince, &membership.CreatedAt, &membership.Version)
+	if err != nil {
+		return err
+	}
+
+	if err = insertMembershipPayment(ctx, tx, membership.ID, payment); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertMembershipPayment(ctx context.Context, tx *sql.Tx, membershipID int, payment *MembershipPayment) error {
+	if payment == nil {
+		return nil
+	}
+
+	query := `INSERT INTO membership_payment (membership_id, invoice_id, amount) VALUES ($1, $2, $3) ON CONFLICT (invoice_id) DO NOTHING`
+
+	_, err := tx.ExecContext(ctx, query, membershipID, payment.InvoiceID, payment.Amount)
+	return err
+}
+
+const membershipColumns = `m.membership_id, m.tier_id, t.title, t.amount, t.creator_id, m.member_id, u.email, m.subscription_id, m.status,
+	m.current_period_end, m.cancel_at_period_end, m.past_due_since, m.cancelled_at, m.created_at, m.version`
+
+const membershipTables = `membership m
+	INNER JOIN membership_tier t ON t.tier_id = m.tier_id
+	INNER JOIN user_t u ON u.user_id = m.member_id`
+
+func scanMembership(row rowScanner) (*Membership, error) {
+	var membership Membership
+
+	err := row.Scan(
+		&membership.ID,
+		&membership.TierID,
+		&membership.TierTitle,
+		&membership.Amount,
+		&membership.CreatorID,
+		&membership.MemberID,
+		&membership.MemberEmail,
+		&membership.SubscriptionID,
+		&membership.Status,
+		&membership.CurrentPeriodEnd,
+		&membership.CancelAtPeriodEnd,
+		&membership.PastDueSince,
+		&membership.CancelledAt,
+		&membership.CreatedAt,
+		&membership.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &membership, nil
+}
+
+func (m MembershipModel) getOne(where string, arg interface{}) (*Membership, error) {
+	query := `SELECT ` + membershipColumns + ` FROM ` + membershipTables + ` WHERE ` + where
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	membership, err := scanMembership(m.DB.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	return membership, nil
+}
+
+func (m MembershipModel) Get(id int) (*Membership, error) {
+	return m.getOne(`m.membership_id = $1`, id)
+}
+
+func (m MembershipModel) GetBySubscriptionID(subscriptionID string) (*Membership, error) {
+	return m.getOne(`m.subscription_id = $1`, subscriptionID)
+}
+
+func (m MembershipModel) getAll(where string, args ...interface{}) ([]*Membership, error) {
+	query := `SELECT ` + membershipColumns + ` FROM ` + membershipTables + ` WHERE ` + where
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*Membership{}
+
+	for rows.Next() {
+		membership, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		memberships = append(memberships, membership)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+func (m MembershipModel) GetAllForMember(memberID int) ([]*Membership, error) {
+	return m.getAll(`m.member_id = $1 ORDER BY m.created_at DESC`, memberID)
+}
+
+func (m MembershipModel) GetAllForCreator(creatorID int) ([]*Membership, error) {
+	return m.getAll(`t.creator_id = $1 AND m.status <> 'cancelled' ORDER BY m.created_at`, creatorID)
+}
+
+// GetDue returns the memberships to check with the gateway: the ones whose
+// paid period is over and the ones still waiting on a payment.
+func (m MembershipModel) GetDue() ([]*Membership, error) {
+	return m.getAll(`m.status <> 'cancelled' AND (m.current_period_end IS NULL OR m.current_period_end < NOW() OR m.status <> 'active')
+	ORDER BY m.current_period_end NULLS FIRST LIMIT 100`)
+}
+
+// Sync records what the gateway reports for the membership and the payment of
+// its latest month, if any. Payments are only ever recorded once.
+func (m MembershipModel) Sync(membership *Membership, status string, periodEnd *time.Time, cancelAtPeriodEnd bool, payment *MembershipPayment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE membership SET status = $1, current_period_end = $2, cancel_at_period_end = $3,
+		past_due_since = CASE WHEN $1 = 'past_due' THEN COALESCE(past_due_since, NOW()) END,
+		cancelled_at = CASE WHEN $1 = 'cancelled' THEN COALESCE(cancelled_at, NOW()) END,
+		version = version + 1
+	WHERE membership_id = $4 AND version = $5
+	RETURNING status, current_period_end, cancel_at_period_end, past_due_since, cancelled_at, version`
+
+	args := []interface{}{status, periodEnd, cancelAtPeriodEnd, membership.ID, membership.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
+		&membership.Status,
+		&membership.CurrentPeriodEnd,
+		&membership.CancelAtPeriodEnd,
+		&membership.PastDueSince,
+		&membership.CancelledAt,
+		&membership.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if err = insertMembershipPayment(ctx, tx, membership.ID, payment); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsMember tells whether the user currently supports the creator. Past due
+// members keep their perks while the payment is retried.
+func (m MembershipModel) IsMember(userID, creatorID int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM membership m INNER JOIN membership_tier t ON t.tier_id = m.tier_id
+		WHERE m.member_id = $1 AND t.creator_id = $2 AND m.status IN ('active', 'past_due'))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var member bool
+
+	err := m.DB.QueryRowContext(ctx, query, userID, creatorID).Scan(&member)
+	return member, err
+}
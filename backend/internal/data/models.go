@@ -17,35 +17,57 @@ var (
 var SupportedCategories = []string{"technology", "art", "music", "games", "film & video", "publishing & writing", "design", "food & craft", "social good", "miscellaneous"}
 
 type Models struct {
-	Projects    ProjectModel
-	Users       UserModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Backing     BackingModel
-	Rewards     RewardModel
-	Updates     UpdateModel
-	Comments    CommentsModel
-	Stats       StatsModel
-	Tables      TablesModel
-	Disputes    DisputeModel
-	Feedback    FeedbackModel
-	Experts     ExpertsModel
+	Projects       ProjectModel
+	Users          UserModel
+	Permissions    PermissionModel
+	Tokens         TokenModel
+	Backing        BackingModel
+	Rewards        RewardModel
+	Updates        UpdateModel
+	Comments       CommentsModel
+	Stats          StatsModel
+	Tables         TablesModel
+	Disputes       DisputeModel
+	Feedback       FeedbackModel
+	Experts        ExpertsModel
+	Ledger         LedgerModel
+	Addresses      AddressModel
+	Fulfillment    FulfillmentModel
+	Payouts        PayoutModel
+	RefundPolicy   RefundPolicyModel
+	StretchGoals   StretchGoalModel
+	Surveys        SurveyModel
+	PayoutAccounts PayoutAccountModel
+	Memberships    MembershipModel
+	Sponsorships   SponsorshipModel
+	PromoCodes     PromoCodeModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Projects:    ProjectModel{DB: db},
-		Users:       UserModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Backing:     BackingModel{DB: db},
-		Rewards:     RewardModel{DB: db},
-		Updates:     UpdateModel{DB: db},
-		Comments:    CommentsModel{DB: db},
-		Stats:       StatsModel{DB: db},
-		Tables:      TablesModel{DB: db},
-		Disputes:    DisputeModel{DB: db},
-		Feedback:    FeedbackModel{DB: db},
-		Experts:     ExpertsModel{DB: db},
+		Projects:       ProjectModel{DB: db},
+		Users:          UserModel{DB: db},
+		Permissions:    PermissionModel{DB: db},
+		Tokens:         TokenModel{DB: db},
+		Backing:        BackingModel{DB: db},
+		Rewards:        RewardModel{DB: db},
+		Updates:        UpdateModel{DB: db},
+		Comments:       CommentsModel{DB: db},
+		Stats:          StatsModel{DB: db},
+		Tables:         TablesModel{DB: db},
+		Disputes:       DisputeModel{DB: db},
+		Feedback:       FeedbackModel{DB: db},
+		Experts:        ExpertsModel{DB: db},
+		Ledger:         LedgerModel{DB: db},
+		Addresses:      AddressModel{DB: db},
+		Fulfillment:    FulfillmentModel{DB: db},
+		Payouts:        PayoutModel{DB: db},
+		RefundPolicy:   RefundPolicyModel{DB: db},
+		StretchGoals:   StretchGoalModel{DB: db},
+		Surveys:        SurveyModel{DB: db},
+		PayoutAccounts: PayoutAccountModel{DB: db},
+		Memberships:    MembershipModel{DB: db},
+		Sponsorships:   SponsorshipModel{DB: db},
+		PromoCodes:     PromoCodeModel{DB: db},
 	}
 }
@@ -0,0 +1,196 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"projectx/internal/validator"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	PayoutAccountPending  = "pending"
+	PayoutAccountVerified = "verified"
+	PayoutAccountRejected = "rejected"
+)
+
+var (
+	CurrencyCodeRX  = regexp.MustCompile("^[A-Z]{3}$")
+	BankAccountRX   = regexp.MustCompile("^[A-Z0-9]{4,34}$")
+	RoutingNumberRX = regexp.MustCompile("^[A-Z0-9]{0,20}$")
+)
+
+// PayoutAccount is where a creator's payouts are sent. Bank details are only
+// kept encrypted, AccountLast4 is what can be shown back.
+type PayoutAccount struct {
+	UserID                 int        `json:"-"`
+	ProviderAccountID      string     `json:"-"`
+	LegalName              string     `json:"legal_name"`
+	Country                string     `json:"country"`
+	Currency               string     `json:"currency"`
+	AccountHolderName      string     `json:"account_holder_name"`
+	AccountNumberEncrypted string     `json:"-"`
+	RoutingNumberEncrypted string     `json:"-"`
+	AccountLast4           string     `json:"account_last4"`
+	Status                 string     `json:"status"`
+	StatusReason           string     `json:"status_reason"`
+	VerifiedAt             *time.Time `json:"verified_at"`
+	CreatedAt              time.Time  `json:"-"`
+	UpdatedAt              time.Time  `json:"-"`
+	Version                int        `json:"version"`
+}
+
+// ValidatePayoutAccount checks the account along with the bank details, which
+// are only in clear text before they get encrypted.
+func ValidatePayoutAccount(v *validator.Validator, account *PayoutAccount, accountNumber, routingNumber string) {
+	v.Check(account.LegalName != "", "legal_name", "Legal name must be provided")
+	v.Check(validator.MaxChars(account.LegalName, 100), "legal_name", "Legal name cannot be more than 100 characters")
+	v.Check(strings.Contains(strings.TrimSpace(account.LegalName), " "), "legal_name", "Legal name must include a first and a last name")
+	v.Check(validator.Matches(account.Country, CountryCodeRX), "country", "Country must be a two letter ISO code")
+	v.Check(validator.Matches(account.Currency, CurrencyCodeRX), "currency", "Currency must be a three letter ISO code")
+	v.Check(account.AccountHolderName != "", "account_holder_name", "Account holder name must be provided")
+	v.Check(validator.MaxChars(account.AccountHolderName, 100), "account_holder_name", "Account holder name cannot be more than 100 characters")
+	v.Check(validator.Matches(accountNumber, BankAccountRX), "account_number", "Account number must be between 4 and 34 letters or digits")
+	v.Check(validator.Matches(routingNumber, RoutingNumberRX), "routing_number", "Routing number cannot be more than 20 letters or digits")
+}
+
+type PayoutAccountModel struct {
+	DB *sql.DB
+}
+
+const payoutAccountColumns = `user_id, provider_account_id, legal_name, country, currency, account_holder_name, account_number_encrypted, routing_number_encrypted,
+	account_last4, status, status_reason, verified_at, created_at, updated_at, version`
+
+func scanPayoutAccount(row rowScanner) (*PayoutAccount, error) {
+	var account PayoutAccount
+
+	err := row.Scan(
+		&account.UserID,
+		&account.ProviderAccountID,
+		&account.LegalName,
+		&account.Country,
+		&account.Currency,
+		&account.AccountHolderName,
+		&account.AccountNumberEncrypted,
+		&account.RoutingNumberEncrypted,
+		&account.AccountLast4,
+		&account.Status,
+		&account.StatusReason,
+		&account.VerifiedAt,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+		&account.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &account, nil
+}
+
+func (m PayoutAccountModel) Get(userID int) (*PayoutAccount, error) {
+	query := `SELECT ` + payoutAccountColumns + ` FROM payout_account WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanPayoutAccount(m.DB.QueryRowContext(ctx, query, userID))
+}
+
+func (m PayoutAccountModel) GetByProviderID(providerAccountID string) (*PayoutAccount, error) {
+	query := `SELECT ` + payoutAccountColumns + ` FROM payout_account WHERE provider_account_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanPayoutAccount(m.DB.QueryRowContext(ctx, query, providerAccountID))
+}
+
+// Save creates the user's payout account or replaces its details.
+func (m PayoutAccountModel) Save(account *PayoutAccount) error {
+	query := `INSERT INTO payout_account (user_id, provider_account_id, legal_name, country, currency, account_holder_name,
+		account_number_encrypted, routing_number_encrypted, account_last4, status, status_reason, verified_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, CASE WHEN $10 = 'verified' THEN NOW() END)
+	ON CONFLICT (user_id) DO UPDATE SET
+		provider_account_id = EXCLUDED.provider_account_id,
+		legal_name = EXCLUDED.legal_name,
+		country = EXCLUDED.country,
+		currency = EXCLUDED.currency,
+		account_holder_name = EXCLUDED.account_holder_name,
+		account_number_encrypted = EXCLUDED.account_number_encrypted,
+		routing_number_encrypted = EXCLUDED.routing_number_encrypted,
+		account_last4 = EXCLUDED.account_last4,
+		status = EXCLUDED.status,
+		status_reason = EXCLUDED.status_reason,
+		verified_at = CASE WHEN EXCLUDED.status = 'verified' THEN COALESCE(payout_account.verified_at, NOW()) END,
+		version = payout_account.version + 1
+	RETURNING verified_at, created_at, updated_at, version`
+
+	args := []interface{}{
+		account.UserID,
+		account.ProviderAccountID,
+		account.LegalName,
+		account.Country,
+		account.Currency,
+		account.AccountHolderName,
+		account.AccountNumberEncrypted,
+		account.RoutingNumberEncrypted,
+		account.AccountLast4,
+		account.Status,
+		account.StatusReason,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&account.VerifiedAt, &account.CreatedAt, &account.UpdatedAt, &account.Version)
+}
+
+// UpdateStatus records the verification status the provider reports for the
+// account.
+func (m PayoutAccountModel) UpdateStatus(account *PayoutAccount, status, reason string) error {
+	query := `UPDATE payout_account SET status = $1, status_reason = $2,
+		verified_at = CASE WHEN $1 = 'verified' THEN COALESCE(verified_at, NOW()) END, version = version + 1
+	WHERE user_id = $3 AND version = $4
+	RETURNING status, status_reason, verified_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, status, reason, account.UserID, account.Version).Scan(
+		&account.Status,
+		&account.StatusReason,
+		&account.VerifiedAt,
+		&account.UpdatedAt,
+		&account.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m PayoutAccountModel) IsVerified(userID int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM payout_account WHERE user_id = $1 AND status = 'verified')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var verified bool
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&verified)
+	return verified, err
+}
@@ -0,0 +1,458 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	PayoutPending   = "pending"
+	PayoutScheduled = "scheduled"
+	PayoutPaid      = "paid"
+	PayoutFailed    = "failed"
+)
+
+var PayoutStatuses = []string{PayoutPending, PayoutScheduled, PayoutPaid, PayoutFailed}
+
+var (
+	ErrOpenDisputes       = errors.New("project has open disputes")
+	ErrInvalidPayoutState = errors.New("payout cannot be changed in its current status")
+)
+
+// FeeSchedule is what the platform keeps out of the money raised by a project.
+// Percentages apply to the amount raised, the fixed processing fee is charged
+// once per payment, in minor units.
+type FeeSchedule struct {
+	PlatformPercent   float64
+	ProcessingPercent float64
+	ProcessingFixed   int64
+}
+
+// Payout amounts are in minor units. Gross is what leaves the project account,
+// fees included.
+type Payout struct {
+	PayoutID      int        `json:"payout_id"`
+	ProjectID     int        `json:"project_id"`
+	ProjectTitle  string     `json:"project_title"`
+	CreatorID     int        `json:"creator_id"`
+	Gross         int64      `json:"gross"`
+	PlatformFee   int64      `json:"platform_fee"`
+	ProcessingFee int64      `json:"processing_fee"`
+	Net           int64      `json:"net"`
+	Status        string     `json:"status"`
+	ApprovedBy    *int       `json:"approved_by"`
+	ScheduledAt   *time.Time `json:"scheduled_at"`
+	PaidAt        *time.Time `json:"paid_at"`
+	FailureReason *string    `json:"failure_reason"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Version       int        `json:"version"`
+}
+
+type PayoutModel struct {
+	DB *sql.DB
+}
+
+// projectAccount returns what the project's payments brought into the project
+// account and what was already taken out of it as fees and payouts.
+func projectAccount(ctx context.Context, tx *sql.Tx, projectID int) (raised int64, payments int64, platformFees int64, processingFees int64, paidOut int64, err error) {
+	query := `
+	WITH balances AS (
+		SELECT SUM(CASE WHEN debit_account = 'project' THEN amount WHEN credit_account = 'project' THEN -amount ELSE 0 END) AS balance
+		FROM funding_ledger
+		WHERE project_id = $1 AND payment_id IS NOT NULL
+		GROUP BY payment_id
+	)
+	SELECT COALESCE(SUM(balance), 0), COUNT(*) FROM balances WHERE balance > 0`
+
+	err = tx.QueryRowContext(ctx, query, projectID).Scan(&raised, &payments)
+	if err != nil {
+		return
+	}
+
+	query = `SELECT
+		COALESCE(SUM(amount) FILTER (WHERE entry_type = 'fee' AND debit_account = 'platform'), 0),
+		COALESCE(SUM(amount) FILTER (WHERE entry_type = 'fee' AND debit_account = 'processor'), 0),
+		COALESCE(SUM(amount) FILTER (WHERE entry_type = 'payout'), 0)
+	FROM funding_ledger
+	WHERE project_id = $1 AND credit_account = 'project'`
+
+	err = tx.QueryRowContext(ctx, query, projectID).Scan(&platformFees, &processingFees, &paidOut)
+	return
+}
+
+// computePayout works out what can be paid out to the creator now. Fees are
+// computed over everything the project raised, minus the fees taken by earlier
+// payouts, so a project can be paid out more than once.
+func computePayout(ctx context.Context, tx *sql.Tx, projectID int, fees FeeSchedule) (*Payout, error) {
+	raised, payments, platformFees, processingFees, paidOut, err := projectAccount(ctx, tx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	available := raised - platformFees - processingFees - paidOut
+
+	platformFee := int64(math.Round(float64(raised)*fees.PlatformPercent/100)) - platformFees
+	processingFee := int64(math.Round(float64(raised)*fees.ProcessingPercent/100)) + payments*fees.ProcessingFixed - processingFees
+	platformFee = max(platformFee, 0)
+	processingFee = max(processingFee, 0)
+
+	return &Payout{
+		ProjectID:     projectID,
+		Gross:         available,
+		PlatformFee:   platformFee,
+		ProcessingFee: processingFee,
+		Net:           available - platformFee - processingFee,
+		Status:        PayoutPending,
+	}, nil
+}
+
+func hasOpenDisputes(ctx context.Context, tx *sql.Tx, projectID int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM dispute WHERE project_id = $1 AND status IN ('pending', 'under review'))`
+
+	var open bool
+	err := tx.QueryRowContext(ctx, query, projectID).Scan(&open)
+	return open, err
+}
+
+// CreatePending creates a pending payout for every completed project that has
+// money left to pay out and no payout waiting already.
+func (m PayoutModel) CreatePending(fees FeeSchedule) ([]*Payout, error) {
+	query := `SELECT p.project_id, p.creator_id, p.title
+	FROM project p
+	WHERE p.status = 'Completed'
+	AND NOT EXISTS (SELECT 1 FROM payout po WHERE po.project_id = p.project_id AND po.status IN ('pending', 'scheduled'))
+	ORDER BY p.project_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := []*Payout{}
+	for rows.Next() {
+		var p Payout
+		if err := rows.Scan(&p.ProjectID, &p.CreatorID, &p.ProjectTitle); err != nil {
+			return nil, err
+		}
+		projects = append(projects, &p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	created := []*Payout{}
+
+	for _, p := range projects {
+		payout, err := m.createPending(ctx, p, fees)
+		if err != nil {
+			return created, err
+		}
+		if payout != nil {
+			created = append(created, payout)
+		}
+	}
+
+	return created, nil
+}
+
+func (m PayoutModel) createPending(ctx context.Context, project *Payout, fees FeeSchedule) (*Payout, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payout, err := computePayout(ctx, tx, project.ProjectID, fees)
+	if err != nil {
+		return nil, err
+	}
+	if payout.Net <= 0 {
+		return nil, nil
+	}
+	payout.CreatorID = project.CreatorID
+	payout.ProjectTitle = project.ProjectTitle
+
+	query := `INSERT INTO payout (project_id, creator_id, gross, platform_fee, processing_fee, net)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING payout_id, status, created_at, updated_at, version`
+
+	args := []interface{}{payout.ProjectID, payout.CreatorID, payout.Gross, payout.PlatformFee, payout.ProcessingFee, payout.Net}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&payout.PayoutID, &payout.Status, &payout.CreatedAt, &payout.UpdatedAt, &payout.Version)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), `duplicate key value violates unique constraint "idx_payout_open_project"`):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return payout, nil
+}
+
+const payoutColumns = `po.payout_id, po.project_id, p.title, po.creator_id, po.gross, po.platform_fee, po.processing_fee, po.net, po.status,
+	po.approved_by, po.scheduled_at, po.paid_at, po.failure_reason, po.created_at, po.updated_at, po.version`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPayout(row rowScanner) (*Payout, error) {
+	var payout Payout
+
+	err := row.Scan(
+		&payout.PayoutID,
+		&payout.ProjectID,
+		&payout.ProjectTitle,
+		&payout.CreatorID,
+		&payout.Gross,
+		&payout.PlatformFee,
+		&payout.ProcessingFee,
+		&payout.Net,
+		&payout.Status,
+		&payout.ApprovedBy,
+		&payout.ScheduledAt,
+		&payout.PaidAt,
+		&payout.FailureReason,
+		&payout.CreatedAt,
+		&payout.UpdatedAt,
+		&payout.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payout, nil
+}
+
+func (m PayoutModel) queryPayouts(query string, args ...interface{}) ([]*Payout, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payouts := []*Payout{}
+	for rows.Next() {
+		payout, err := scanPayout(rows)
+		if err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, payout)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payouts, nil
+}
+
+func (m PayoutModel) GetAllForCreator(creatorID int) ([]*Payout, error) {
+	query := `SELECT ` + payoutColumns + `
+	FROM payout po
+	INNER JOIN project p ON p.project_id = po.project_id
+	WHERE po.creator_id = $1
+	ORDER BY po.created_at DESC, po.payout_id DESC`
+
+	return m.queryPayouts(query, creatorID)
+}
+
+// GetAll returns every payout, or only those with the given status.
+func (m PayoutModel) GetAll(status string) ([]*Payout, error) {
+	query := `SELECT ` + payoutColumns + `
+	FROM payout po
+	INNER JOIN project p ON p.project_id = po.project_id
+	WHERE ($1 = '' OR po.status::text = $1)
+	ORDER BY po.created_at DESC, po.payout_id DESC`
+
+	return m.queryPayouts(query, status)
+}
+
+func (m PayoutModel) GetDue() ([]*Payout, error) {
+	query := `SELECT ` + payoutColumns + `
+	FROM payout po
+	INNER JOIN project p ON p.project_id = po.project_id
+	WHERE po.status = 'scheduled' AND po.scheduled_at <= NOW()
+	ORDER BY po.scheduled_at, po.payout_id`
+
+	return m.queryPayouts(query)
+}
+
+func lockPayout(ctx context.Context, tx *sql.Tx, id int) (*Payout, error) {
+	query := `SELECT ` + payoutColumns + `
+	FROM payout po
+	INNER JOIN project p ON p.project_id = po.project_id
+	WHERE po.payout_id = $1
+	FOR UPDATE OF po`
+
+	payout, err := scanPayout(tx.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	return payout, nil
+}
+
+// Approve schedules a pending payout. Projects with open disputes can't be
+// paid out until the disputes are closed.
+func (m PayoutModel) Approve(id, adminID int, scheduledAt time.Time) (*Payout, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payout, err := lockPayout(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if payout.Status != PayoutPending {
+		return nil, ErrInvalidPayoutState
+	}
+
+	open, err := hasOpenDisputes(ctx, tx, payout.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	if open {
+		return nil, ErrOpenDisputes
+	}
+
+	query := `UPDATE payout
+	SET status = 'scheduled', approved_by = $1, scheduled_at = $2, updated_at = NOW(), version = version + 1
+	WHERE payout_id = $3
+	RETURNING status, approved_by, scheduled_at, updated_at, version`
+
+	err = tx.QueryRowContext(ctx, query, adminID, scheduledAt, id).Scan(&payout.Status, &payout.ApprovedBy, &payout.ScheduledAt, &payout.UpdatedAt, &payout.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return payout, nil
+}
+
+// Pay takes a scheduled payout's fees and net amount out of the project
+// account. A payout whose project no longer holds enough money, because of
+// refunds since it was computed, is marked failed so that a new one gets
+// computed. Payouts of projects with open disputes are left scheduled.
+func (m PayoutModel) Pay(id int) (*Payout, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payout, err := lockPayout(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if payout.Status != PayoutScheduled {
+		return nil, ErrInvalidPayoutState
+	}
+
+	open, err := hasOpenDisputes(ctx, tx, payout.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	if open {
+		return nil, ErrOpenDisputes
+	}
+
+	_, err = tx.ExecContext(ctx, `LOCK TABLE funding_ledger IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return nil, err
+	}
+
+	raised, _, platformFees, processingFees, paidOut, err := projectAccount(ctx, tx, payout.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if raised-platformFees-processingFees-paidOut < payout.Gross {
+		reason := "project balance dropped below the payout amount"
+		query := `UPDATE payout SET status = 'failed', failure_reason = $1, updated_at = NOW(), version = version + 1
+		WHERE payout_id = $2
+		RETURNING status, failure_reason, updated_at, version`
+
+		err = tx.QueryRowContext(ctx, query, reason, id).Scan(&payout.Status, &payout.FailureReason, &payout.UpdatedAt, &payout.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+
+		return payout, nil
+	}
+
+	entries := []*LedgerEntry{
+		{EntryType: LedgerFee, DebitAccount: AccountPlatform, Amount: payout.PlatformFee},
+		{EntryType: LedgerFee, DebitAccount: AccountProcessor, Amount: payout.ProcessingFee},
+		{EntryType: LedgerPayout, DebitAccount: AccountCreator, Amount: payout.Net},
+	}
+
+	for _, entry := range entries {
+		if entry.Amount <= 0 {
+			continue
+		}
+
+		entry.ProjectID = payout.ProjectID
+		entry.PayoutID = &payout.PayoutID
+		entry.CreditAccount = AccountProject
+
+		err = insertLedgerEntry(ctx, tx, entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := `UPDATE payout SET status = 'paid', paid_at = NOW(), updated_at = NOW(), version = version + 1
+	WHERE payout_id = $1
+	RETURNING status, paid_at, updated_at, version`
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&payout.Status, &payout.PaidAt, &payout.UpdatedAt, &payout.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return payout, nil
+}
@@ -0,0 +1,617 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	PledgeChangePending  = "pending"
+	PledgeChangeApplied  = "applied"
+	PledgeChangeCanceled = "canceled"
+)
+
+// PledgeChange records a backer changing the amount or the rewards of their
+// pledge. Raising the amount is only applied once the payment of the
+// difference, TransactionID, went through. Lowering it is recorded pending with
+// its Adjustments before any money is given back, and applied afterwards.
+type PledgeChange struct {
+	HistoryID     int                  `json:"history_id"`
+	BackingID     int                  `json:"backing_id"`
+	ProjectID     int                  `json:"project_id"`
+	BackerID      int                  `json:"-"`
+	Status        string               `json:"status"`
+	OldAmount     float64              `json:"old_amount"`
+	NewAmount     float64              `json:"new_amount"`
+	OldRewards    []int                `json:"old_rewards"`
+	NewRewards    []int                `json:"new_rewards"`
+	NewVariants   map[int]int          `json:"new_variants"`
+	AddressID     *int                 `json:"address_id"`
+	TransactionID *string              `json:"transaction_id"`
+	RefundIDs     []string             `json:"refund_ids"`
+	Adjustments   []*PaymentAdjustment `json:"adjustments"`
+	CreatedAt     time.Time            `json:"created_at"`
+	AppliedAt     *time.Time           `json:"applied_at"`
+}
+
+// PaymentAdjustment takes Amount off a payment when a pledge is lowered. A
+// payment taken off entirely is given Status, refunded or canceled. Held
+// payments are released when taken off entirely and captured for less at
+// settlement otherwise, the others are refunded. RefundID is set once the money
+// was given back.
+type PaymentAdjustment struct {
+	PaymentID     int     `json:"payment_id"`
+	TransactionID string  `json:"transaction_id"`
+	Amount        float64 `json:"amount"`
+	Held          bool    `json:"held"`
+	Status        string  `json:"status,omitempty"`
+	RefundID      string  `json:"refund_id,omitempty"`
+}
+
+// ReservationID is what the rewards added by the change are reserved under:
+// the payment of the difference for a raise, the change itself otherwise.
+func (c *PledgeChange) ReservationID() string {
+	if c.TransactionID != nil {
+		return *c.TransactionID
+	}
+	return fmt.Sprintf("pledge_change_%d", c.HistoryID)
+}
+
+// PlanPledgeLowering splits amount over the active payments of a backing,
+// latest first, without giving anything back yet.
+func PlanPledgeLowering(activePayments []*Payment, amount float64) []*PaymentAdjustment {
+	adjustments := []*PaymentAdjustment{}
+
+	for _, payment := range activePayments {
+		if amount <= 0 {
+			break
+		}
+
+		remaining := payment.Amount - payment.RefundedAmount
+		if remaining <= 0 {
+			continue
+		}
+
+		adjustment := &PaymentAdjustment{
+			PaymentID:     payment.PaymentID,
+			TransactionID: payment.TransactionID,
+			Amount:        min(amount, remaining),
+			Held:          payment.Status == "requires_capture",
+		}
+
+		if adjustment.Amount == remaining {
+			adjustment.Status = "refunded"
+			if adjustment.Held {
+				adjustment.Status = "canceled"
+			}
+		}
+
+		adjustments = append(adjustments, adjustment)
+		amount -= adjustment.Amount
+	}
+
+	return adjustments
+}
+
+// GetActivePayments returns the payments of a backing that still count
+// towards the project, latest first.
+func (m BackingModel) GetActivePayments(backingID int) ([]*Payment, error) {
+	query := `SELECT payment_id, amount, refunded_amount, status, transaction_id, payment_method, backing_id, created_at, updated_at, version
+	FROM payment
+	WHERE backing_id = $1 AND status IN ('succeeded', 'partially_refunded', 'requires_capture')
+	ORDER BY payment_id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, backingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*Payment{}
+
+	for rows.Next() {
+		var payment Payment
+
+		err := rows.Scan(
+			&payment.PaymentID,
+			&payment.Amount,
+			&payment.RefundedAmount,
+			&payment.Status,
+			&payment.TransactionID,
+			&payment.PaymentMethod,
+			&payment.BackingID,
+			&payment.CreatedAt,
+			&payment.UpdatedAt,
+			&payment.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		payments = append(payments, &payment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+func (m BackingModel) GetRewardIDs(backingID int) ([]int, error) {
+	query := `SELECT COALESCE(array_agg(reward_id ORDER BY reward_id), '{}') FROM backing_reward WHERE backing_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var ids pq.Int64Array
+
+	err := m.DB.QueryRowContext(ctx, query, backingID).Scan(&ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return intSlice(ids), nil
+}
+
+const pledgeChangeColumns = `h.history_id, h.backing_id, b.project_id, b.backer_id, h.status, h.old_amount, h.new_amount, h.old_rewards, h.new_rewards,
+	h.new_variants, h.address_id, h.transaction_id, h.refund_ids, h.adjustments, h.created_at, h.applied_at`
+
+func scanPledgeChange(row rowScanner) (*PledgeChange, error) {
+	var change PledgeChange
+	var oldRewards, newRewards pq.Int64Array
+	var refundIDs pq.StringArray
+	var newVariants, adjustments []byte
+
+	err := row.Scan(
+		&change.HistoryID,
+		&change.BackingID,
+		&change.ProjectID,
+		&change.BackerID,
+		&change.Status,
+		&change.OldAmount,
+		&change.NewAmount,
+		&oldRewards,
+		&newRewards,
+		&newVariants,
+		&change.AddressID,
+		&change.TransactionID,
+		&refundIDs,
+		&adjustments,
+		&change.CreatedAt,
+		&change.AppliedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	change.OldRewards = intSlice(oldRewards)
+	change.NewRewards = intSlice(newRewards)
+	change.RefundIDs = refundIDs
+
+	if err = json.Unmarshal(newVariants, &change.NewVariants); err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(adjustments, &change.Adjustments); err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+func (m BackingModel) getPledgeChange(where string, arg interface{}) (*PledgeChange, error) {
+	query := `SELECT ` + pledgeChangeColumns + `
+	FROM backing_history h
+	INNER JOIN backing b ON b.backing_id = h.backing_id
+	WHERE ` + where + `
+	ORDER BY h.history_id DESC
+	LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	change, err := scanPledgeChange(m.DB.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	return change, nil
+}
+
+func (m BackingModel) GetPendingPledgeChange(backingID int) (*PledgeChange, error) {
+	return m.getPledgeChange(`h.backing_id = $1 AND h.status = 'pending'`, backingID)
+}
+
+func (m BackingModel) GetPledgeChange(historyID int) (*PledgeChange, error) {
+	return m.getPledgeChange(`h.history_id = $1`, historyID)
+}
+
+func (m BackingModel) GetPledgeChangeByTransactionID(transactionID string) (*PledgeChange, error) {
+	return m.getPledgeChange(`h.transaction_id = $1`, transactionID)
+}
+
+// GetPendingPledgeLowering returns the pending lowering that gives money back
+// from the payment, if any.
+func (m BackingModel) GetPendingPledgeLowering(transactionID string) (*PledgeChange, error) {
+	return m.getPledgeChange(`h.status = 'pending' AND h.adjustments @> jsonb_build_array(jsonb_build_object('transaction_id', $1::text))`, transactionID)
+}
+
+// GetStalePledgeLowerings returns the lowerings still pending since before,
+// left behind when giving the money back or recording it failed.
+func (m BackingModel) GetStalePledgeLowerings(before time.Time) ([]*PledgeChange, error) {
+	query := `SELECT ` + pledgeChangeColumns + `
+	FROM backing_history h
+	INNER JOIN backing b ON b.backing_id = h.backing_id
+	WHERE h.status = 'pending' AND h.transaction_id IS NULL AND h.created_at < $1
+	ORDER BY h.history_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*PledgeChange{}
+
+	for rows.Next() {
+		change, err := scanPledgeChange(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+func (m BackingModel) GetPledgeHistory(backingID int) ([]*PledgeChange, error) {
+	query := `SELECT ` + pledgeChangeColumns + `
+	FROM backing_history h
+	INNER JOIN backing b ON b.backing_id = h.backing_id
+	WHERE h.backing_id = $1
+	ORDER BY h.created_at DESC, h.history_id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, backingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*PledgeChange{}
+
+	for rows.Next() {
+		change, err := scanPledgeChange(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, change)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// InsertPledgeChange records a change waiting for the payment of the
+// difference, or for its adjustments to give the money back. A backing only
+// has one pending change at a time, ErrEditConflict is returned otherwise.
+func (m BackingModel) InsertPledgeChange(change *PledgeChange) error {
+	query := `INSERT INTO backing_history (backing_id, status, old_amount, new_amount, old_rewards, new_rewards, address_id, transaction_id, new_variants, adjustments)
+	VALUES ($1, 'pending', $2, $3, COALESCE($4::bigint[], '{}'), COALESCE($5::bigint[], '{}'), $6, $7, $8, $9)
+	RETURNING history_id, status, created_at`
+
+	newVariants, err := variantsJSON(change.NewVariants)
+	if err != nil {
+		return err
+	}
+
+	adjustments, err := adjustmentsJSON(change.Adjustments)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{
+		change.BackingID,
+		change.OldAmount,
+		change.NewAmount,
+		pq.Array(change.OldRewards),
+		pq.Array(change.NewRewards),
+		change.AddressID,
+		change.TransactionID,
+		newVariants,
+		adjustments,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&change.HistoryID, &change.Status, &change.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_backing_history_pending"`:
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m BackingModel) CancelPledgeChange(transactionID string) error {
+	return m.cancelPledgeChange(`transaction_id = $1`, transactionID)
+}
+
+// CancelPledgeLowering drops a pending lowering none of whose money was given
+// back.
+func (m BackingModel) CancelPledgeLowering(historyID int) error {
+	return m.cancelPledgeChange(`history_id = $1 AND transaction_id IS NULL`, historyID)
+}
+
+func (m BackingModel) cancelPledgeChange(where string, arg interface{}) error {
+	query := `UPDATE backing_history SET status = 'canceled' WHERE ` + where + ` AND status = 'pending'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, arg)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecordFound
+	}
+
+	return nil
+}
+
+// ApplyPledgeChange records the payment of a raised pledge or the adjustments
+// of a lowered one, swaps the rewards and moves the funding accordingly, all in
+// one transaction. A pending change that was already applied or canceled
+// returns ErrEditConflict, one adding a reward that sold out ErrRewardSoldOut.
+func (m BackingModel) ApplyPledgeChange(change *PledgeChange, payment *Payment, shipment *Shipment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var projectID int
+
+	err = tx.QueryRowContext(ctx, `SELECT project_id FROM backing WHERE backing_id = $1 FOR UPDATE`, change.BackingID).Scan(&projectID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNoRecordFound
+		default:
+			return err
+		}
+	}
+	change.ProjectID = projectID
+
+	change.RefundIDs = []string{}
+	for _, adjustment := range change.Adjustments {
+		if adjustment.RefundID != "" {
+			change.RefundIDs = append(change.RefundIDs, adjustment.RefundID)
+		}
+	}
+
+	adjustments, err := adjustmentsJSON(change.Adjustments)
+	if err != nil {
+		return err
+	}
+
+	if change.HistoryID != 0 {
+		query := `UPDATE backing_history SET status = 'applied', applied_at = NOW(), refund_ids = $2, adjustments = $3
+		WHERE history_id = $1 AND status = 'pending'
+		RETURNING status, applied_at`
+
+		err = tx.QueryRowContext(ctx, query, change.HistoryID, pq.Array(change.RefundIDs), adjustments).Scan(&change.Status, &change.AppliedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+	} else {
+		query := `INSERT INTO backing_history (backing_id, status, old_amount, new_amount, old_rewards, new_rewards, address_id, refund_ids, new_variants, applied_at)
+		VALUES ($1, 'applied', $2, $3, COALESCE($4::bigint[], '{}'), COALESCE($5::bigint[], '{}'), $6, COALESCE($7::text[], '{}'), $8, NOW())
+		RETURNING history_id, status, created_at, applied_at`
+
+		newVariants, err := variantsJSON(change.NewVariants)
+		if err != nil {
+			return err
+		}
+
+		args := []interface{}{
+			change.BackingID,
+			change.OldAmount,
+			change.NewAmount,
+			pq.Array(change.OldRewards),
+			pq.Array(change.NewRewards),
+			change.AddressID,
+			pq.Array(change.RefundIDs),
+			newVariants,
+		}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&change.HistoryID, &change.Status, &change.CreatedAt, &change.AppliedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	if payment != nil {
+		payment.BackingID = change.BackingID
+
+		err = insertPayment(ctx, tx, projectID, payment)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, adjustment := range change.Adjustments {
+		if adjustment.Held && adjustment.Status == "" {
+			err = lowerHold(ctx, tx, adjustment)
+		} else {
+			err = applyAdjustment(ctx, tx, change.BackingID, adjustment)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	removed := Difference(change.OldRewards, change.NewRewards)
+	if len(removed) > 0 {
+		_, err = tx.ExecContext(ctx, releaseRewardsQuery, change.BackingID, pq.Array(removed))
+		if err != nil {
+			return err
+		}
+	}
+
+	if added := Difference(change.NewRewards, change.OldRewards); len(added) > 0 {
+		err = claimRewards(ctx, tx, projectID, change.BackingID, change.ReservationID(), added, change.NewVariants, shipment)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Difference returns the ids of a that aren't in b.
+func Difference(a, b []int) []int {
+	result := []int{}
+	for _, id := range a {
+		if !slices.Contains(b, id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func variantsJSON(variants map[int]int) (string, error) {
+	if variants == nil {
+		variants = map[int]int{}
+	}
+
+	js, err := json.Marshal(variants)
+	if err != nil {
+		return "", err
+	}
+
+	return string(js), nil
+}
+
+func adjustmentsJSON(adjustments []*PaymentAdjustment) (string, error) {
+	if adjustments == nil {
+		adjustments = []*PaymentAdjustment{}
+	}
+
+	js, err := json.Marshal(adjustments)
+	if err != nil {
+		return "", err
+	}
+
+	return string(js), nil
+}
+
+func intSlice(ids pq.Int64Array) []int {
+	result := make([]int, len(ids))
+	for i, id := range ids {
+		result[i] = int(id)
+	}
+	return result
+}
+
+// lowerHold takes the adjustment off what will be captured from a held payment
+// at settlement. Nothing was charged yet, so the hold is the only thing that
+// changes.
+func lowerHold(ctx context.Context, tx *sql.Tx, adjustment *PaymentAdjustment) error {
+	query := `UPDATE payment SET amount = amount - $1, version = version + 1
+	WHERE payment_id = $2 AND status = 'requires_capture' AND amount - refunded_amount > $1`
+
+	result, err := tx.ExecContext(ctx, query, adjustment.Amount, adjustment.PaymentID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return postRefund(ctx, tx, adjustment.PaymentID, minorUnits(adjustment.Amount))
+}
+
+// applyAdjustment records the money given back on a captured payment, or a hold
+// released in full, as a completed cancellation, like any other refund. The
+// amount charged is left as it is.
+func applyAdjustment(ctx context.Context, tx *sql.Tx, backingID int, adjustment *PaymentAdjustment) error {
+	c := &Cancellation{
+		Reason:    "The backer lowered their pledge",
+		Date:      time.Now(),
+		BackingID: backingID,
+		PaymentID: adjustment.PaymentID,
+		Amount:    adjustment.Amount,
+		Category:  RefundBackerRequest,
+		RefundID:  adjustment.RefundID,
+		Status:    RefundCompleted,
+	}
+
+	payment, full, err := lockRefundedPayment(ctx, tx, c)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRefundTooLarge):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = insertCancellation(ctx, tx, c)
+	if err != nil {
+		return err
+	}
+
+	return applyRefund(ctx, tx, payment, c, full)
+}
@@ -0,0 +1,89 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanPledgeLowering(t *testing.T) {
+	captured := &Payment{PaymentID: 3, TransactionID: "pi_3", Amount: 5000, RefundedAmount: 1000, Status: "partially_refunded"}
+	held := &Payment{PaymentID: 2, TransactionID: "pi_2", Amount: 3000, Status: "requires_capture"}
+	older := &Payment{PaymentID: 1, TransactionID: "pi_1", Amount: 2000, Status: "succeeded"}
+
+	tests := []struct {
+		name           string
+		activePayments []*Payment
+		amount         float64
+		want           []*PaymentAdjustment
+	}{
+		{
+			name:           "part of the latest payment",
+			activePayments: []*Payment{captured, held},
+			amount:         1500,
+			want: []*PaymentAdjustment{
+				{PaymentID: 3, TransactionID: "pi_3", Amount: 1500},
+			},
+		},
+		{
+			name:           "all that is left on the latest payment",
+			activePayments: []*Payment{captured, held},
+			amount:         4000,
+			want: []*PaymentAdjustment{
+				{PaymentID: 3, TransactionID: "pi_3", Amount: 4000, Status: "refunded"},
+			},
+		},
+		{
+			name:           "spills over onto a hold",
+			activePayments: []*Payment{captured, held},
+			amount:         5000,
+			want: []*PaymentAdjustment{
+				{PaymentID: 3, TransactionID: "pi_3", Amount: 4000, Status: "refunded"},
+				{PaymentID: 2, TransactionID: "pi_2", Amount: 1000, Held: true},
+			},
+		},
+		{
+			name:           "whole hold is released",
+			activePayments: []*Payment{held, older},
+			amount:         4000,
+			want: []*PaymentAdjustment{
+				{PaymentID: 2, TransactionID: "pi_2", Amount: 3000, Held: true, Status: "canceled"},
+				{PaymentID: 1, TransactionID: "pi_1", Amount: 1000},
+			},
+		},
+		{
+			name:           "payments with nothing left are skipped",
+			activePayments: []*Payment{{PaymentID: 4, TransactionID: "pi_4", Amount: 1000, RefundedAmount: 1000, Status: "partially_refunded"}, older},
+			amount:         500,
+			want: []*PaymentAdjustment{
+				{PaymentID: 1, TransactionID: "pi_1", Amount: 500},
+			},
+		},
+		{
+			name:           "nothing to lower",
+			activePayments: []*Payment{captured},
+			amount:         0,
+			want:           []*PaymentAdjustment{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PlanPledgeLowering(tt.activePayments, tt.amount)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("adjustments = %s, want %s", adjustmentsString(t, got), adjustmentsString(t, tt.want))
+			}
+		})
+	}
+}
+
+func adjustmentsString(t *testing.T, adjustments []*PaymentAdjustment) string {
+	t.Helper()
+
+	js, err := adjustmentsJSON(adjustments)
+	if err != nil {
+		t.Fatalf("encoding adjustments: %v", err)
+	}
+
+	return js
+}
@@ -0,0 +1,243 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	CancellationByCreator  = "creator"
+	CancellationTakedown   = "takedown"
+	CancellationFailedGoal = "failed_goal"
+)
+
+const RefundProjectCancelled = "project_cancelled"
+
+var ErrAlreadyCancelled = errors.New("project is already being cancelled")
+
+// ProjectCancellation refunds every backer of a project in the background.
+// A taken down project is soft deleted once everyone got their money back.
+type ProjectCancellation struct {
+	ProjectID     int        `json:"project_id"`
+	Type          string     `json:"type"`
+	Reason        string     `json:"reason"`
+	RequestedBy   *int       `json:"requested_by"`
+	RefundedCount int        `json:"refunded_count"`
+	FailedCount   int        `json:"failed_count"`
+	CreatedAt     time.Time  `json:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+}
+
+type RefundablePayment struct {
+	Payment
+	BackerEmail string
+}
+
+// StartCancellation stops the project from taking pledges and queues the
+// refund of its backers.
+func (m ProjectModel) StartCancellation(c *ProjectCancellation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	status := "Cancelled"
+	if c.Type == CancellationFailedGoal {
+		status = "Failed"
+	}
+
+	// settled_at is set as well so settlement leaves the project alone
+	query := `UPDATE project SET status = $1, settled_at = COALESCE(settled_at, NOW()), version = version + 1
+	WHERE project_id = $2 AND deleted_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, status, c.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecordFound
+	}
+
+	query = `INSERT INTO project_cancellation (project_id, type, reason, requested_by)
+	VALUES ($1, $2, NULLIF($3, ''), $4)
+	RETURNING created_at`
+
+	err = tx.QueryRowContext(ctx, query, c.ProjectID, c.Type, c.Reason, c.RequestedBy).Scan(&c.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "project_cancellation_pkey"`:
+			return ErrAlreadyCancelled
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+const projectCancellationColumns = `project_id, type, COALESCE(reason, ''), requested_by, refunded_count, failed_count, created_at, finished_at`
+
+func scanProjectCancellation(row rowScanner) (*ProjectCancellation, error) {
+	var c ProjectCancellation
+
+	err := row.Scan(&c.ProjectID, &c.Type, &c.Reason, &c.RequestedBy, &c.RefundedCount, &c.FailedCount, &c.CreatedAt, &c.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (m ProjectModel) GetCancellation(projectID int) (*ProjectCancellation, error) {
+	query := `SELECT ` + projectCancellationColumns + ` FROM project_cancellation WHERE project_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	c, err := scanProjectCancellation(m.DB.QueryRowContext(ctx, query, projectID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (m ProjectModel) GetUnfinishedCancellations() ([]*ProjectCancellation, error) {
+	query := `SELECT ` + projectCancellationColumns + ` FROM project_cancellation WHERE finished_at IS NULL ORDER BY created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cancellations := []*ProjectCancellation{}
+
+	for rows.Next() {
+		c, err := scanProjectCancellation(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		cancellations = append(cancellations, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cancellations, nil
+}
+
+func (m ProjectModel) RecordCancellationProgress(projectID, refunded, failed int) error {
+	query := `UPDATE project_cancellation SET refunded_count = refunded_count + $1, failed_count = $2 WHERE project_id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, refunded, failed, projectID)
+	return err
+}
+
+// FinishCancellation is called once every backer was refunded.
+func (m ProjectModel) FinishCancellation(projectID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var cancellationType string
+
+	query := `UPDATE project_cancellation SET finished_at = NOW(), failed_count = 0
+	WHERE project_id = $1 AND finished_at IS NULL
+	RETURNING type`
+
+	err = tx.QueryRowContext(ctx, query, projectID).Scan(&cancellationType)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if cancellationType == CancellationTakedown {
+		_, err = tx.ExecContext(ctx, `UPDATE project SET deleted_at = NOW(), version = version + 1 WHERE project_id = $1`, projectID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetRefundablePayments returns up to limit payments of the project that still
+// hold money, after the payment afterID.
+func (m BackingModel) GetRefundablePayments(projectID, afterID, limit int) ([]*RefundablePayment, error) {
+	query := `SELECT pa.payment_id, pa.amount, pa.refunded_amount, pa.status, pa.transaction_id, pa.backing_id, pa.created_at, u.email
+	FROM payment pa
+	INNER JOIN backing b ON b.backing_id = pa.backing_id
+	INNER JOIN user_t u ON u.user_id = b.backer_id
+	WHERE b.project_id = $1 AND pa.payment_id > $2 AND pa.status IN ('succeeded', 'partially_refunded', 'requires_capture')
+	ORDER BY pa.payment_id
+	LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, projectID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*RefundablePayment{}
+
+	for rows.Next() {
+		var payment RefundablePayment
+
+		err := rows.Scan(
+			&payment.PaymentID,
+			&payment.Amount,
+			&payment.RefundedAmount,
+			&payment.Status,
+			&payment.TransactionID,
+			&payment.BackingID,
+			&payment.CreatedAt,
+			&payment.BackerEmail,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		payments = append(payments, &payment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
@@ -13,26 +13,39 @@ import (
 )
 
 type Project struct {
-	ID              int            `json:"project_id"`
-	Title           string         `json:"title"`
-	Description     string         `json:"description"`
-	FundingGoal     float64        `json:"funding_goal"`
-	CurrentFunding  float64        `json:"current_funding"`
-	Categories      pq.StringArray `json:"categories"`
-	Deadline        time.Time      `json:"deadline"`
-	Status          string         `json:"status"`
-	ProjectImg      string         `json:"project_img"`
-	Campaign        string         `json:"campaign"`
-	CreatedAt       time.Time      `json:"-"`
-	UpdatedAt       time.Time      `json:"-"`
-	LaunchedAt      time.Time      `json:"launched_at"`
-	Version         int32          `json:"version"`
-	CreatorID       int            `json:"creator_id"`
-	Rewards         []Reward       `json:"rewards,omitempty"`
-	IsSuspicious    bool           `json:"is_suspicious"`
-	ExpertsDecision string         `json:"experts_decision"`
+	ID               int               `json:"project_id"`
+	Title            string            `json:"title"`
+	Description      string            `json:"description"`
+	FundingGoal      float64           `json:"funding_goal"`
+	CurrentFunding   float64           `json:"current_funding"`
+	Categories       pq.StringArray    `json:"categories"`
+	Deadline         time.Time         `json:"deadline"`
+	Status           string            `json:"status"`
+	ProjectImg       string            `json:"project_img"`
+	Campaign         string            `json:"campaign"`
+	CreatedAt        time.Time         `json:"-"`
+	UpdatedAt        time.Time         `json:"-"`
+	LaunchedAt       time.Time         `json:"launched_at"`
+	Version          int32             `json:"version"`
+	CreatorID        int               `json:"creator_id"`
+	Rewards          []Reward          `json:"rewards,omitempty"`
+	RefundPolicy     *RefundPolicy     `json:"refund_policy,omitempty"`
+	StretchGoals     []*StretchGoal    `json:"stretch_goals,omitempty"`
+	IsSuspicious     bool              `json:"is_suspicious"`
+	ExpertsDecision  string            `json:"experts_decision"`
+	FundingModel     string            `json:"funding_model"`
+	LatePledges      bool              `json:"late_pledges"`
+	LatePledgesUntil *time.Time        `json:"late_pledges_until"`
+	LateFunding      float64           `json:"late_funding"`
+	MatchedFunding   float64           `json:"matched_funding"`
+	Sponsors         []*ProjectSponsor `json:"sponsors,omitempty"`
 }
 
+const (
+	FundingAllOrNothing = "all_or_nothing"
+	FundingFlexible     = "flexible"
+)
+
 type Review struct {
 	ID         int       `json:"review_id"`
 	Status     string    `json:"status"`
@@ -61,6 +74,8 @@ func ValidateProject(v *validator.Validator, project *Project) {
 	v.Check(project.FundingGoal != 0, "funding goal", "Funding goal must be provided")
 	v.Check(project.FundingGoal > 0, "funding goal", "Funding goal must be positive")
 
+	v.Check(validator.In(project.FundingModel, FundingAllOrNothing, FundingFlexible), "funding_model", "Funding model should be either all_or_nothing or flexible")
+
 	v.Check(project.Deadline.GoString() != "", "deadline", "Deadline must be provided")
 	v.Check(project.Deadline.After(time.Now()), "deadline", "Deadline should be after the date of today")
 	v.Check((project.Deadline.Sub(time.Now()).Hours()/24/30) <= 4, "deadline", "Deadline should not exceed 4 months since the date of today")
@@ -87,15 +102,18 @@ func ValidateReview(v *validator.Validator, review *Review) {
 	v.Check(validator.InBetween(review.Feedback, 10, 500), "feedback", "Feedback should be between 10 and 500 characters")
 }
 
-func (m ProjectModel) GetAll(title string, categories []string, filters Filter) ([]*Project, MetaData, error) {
+// GetAll lists the projects open to discovery. latePledges keeps the ones
+// taking late pledges.
+func (m ProjectModel) GetAll(title string, categories []string, latePledges bool, filters Filter) ([]*Project, MetaData, error) {
 	offset := (filters.Page - 1) * filters.PageSize
 
 	query := fmt.Sprintf(`
-	SELECT COUNT(*) OVER(), project_id, title, description, categories, funding_goal, current_funding, deadline, status, project_img, campaign, created_at, updated_at, launched_at, version, creator_id, experts_decision
-	FROM project
+	SELECT COUNT(*) OVER(), project_id, title, description, categories, funding_goal, current_funding, deadline, status, project_img, campaign, created_at, updated_at, launched_at, version, creator_id, experts_decision, funding_model, late_pledges, late_pledges_until, late_funding
+	FROM (SELECT *, project_funding(project_id) AS current_funding, project_late_funding(project_id) AS late_funding FROM project WHERE deleted_at IS NULL) project
 	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1='') 
 	AND (categories && $2 OR $2 = '{}')
 	AND (status = 'Completed' OR status = 'Live')
+	AND ($5 = FALSE OR (late_pledges AND status = 'Completed' AND (late_pledges_until IS NULL OR late_pledges_until > NOW())))
 	ORDER BY %s %s, project_id ASC
 	LIMIT $3 OFFSET $4
 	`, filters.sortColumn(), filters.sortDirection())
@@ -105,7 +123,7 @@ func (m ProjectModel) GetAll(title string, categories []string, filters Filter)
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{title, pq.Array(categories), filters.PageSize, offset}
+	args := []interface{}{title, pq.Array(categories), filters.PageSize, offset, latePledges}
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -136,6 +154,10 @@ func (m ProjectModel) GetAll(title string, categories []string, filters Filter)
 			&project.Version,
 			&project.CreatorID,
 			&project.ExpertsDecision,
+			&project.FundingModel,
+			&project.LatePledges,
+			&project.LatePledgesUntil,
+			&project.LateFunding,
 		)
 		if err != nil {
 			return nil, MetaData{}, err
@@ -158,8 +180,8 @@ func (m ProjectModel) GetAll(title string, categories []string, filters Filter)
 func (m ProjectModel) Insert(project *Project) error {
 	query := `
 	INSERT INTO project 
-	(title, description, categories, funding_goal, deadline, creator_id)
-	VALUES ($1, $2, $3, $4, $5, $6)
+	(title, description, categories, funding_goal, deadline, creator_id, funding_model)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	RETURNING project_id, status, created_at, updated_at, version
 	`
 	args := []interface{}{
@@ -169,6 +191,7 @@ func (m ProjectModel) Insert(project *Project) error {
 		project.FundingGoal,
 		project.Deadline,
 		project.CreatorID,
+		project.FundingModel,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -184,7 +207,7 @@ func (m ProjectModel) Get(id int) (*Project, error) {
 	var project Project
 	var projectImgVar sql.NullString
 	var campaignVar sql.NullString
-	query := `SELECT project_id, title, description, categories, funding_goal, current_funding, deadline, status, project_img, campaign, created_at, updated_at, launched_at, version, creator_id, experts_decision FROM project WHERE project_id = $1`
+	query := `SELECT project_id, title, description, categories, funding_goal, project_funding(project_id) AS current_funding, deadline, status, project_img, campaign, created_at, updated_at, launched_at, version, creator_id, experts_decision, funding_model, late_pledges, late_pledges_until, project_late_funding(project_id) AS late_funding FROM project WHERE project_id = $1 AND deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -206,6 +229,10 @@ func (m ProjectModel) Get(id int) (*Project, error) {
 		&project.Version,
 		&project.CreatorID,
 		&project.ExpertsDecision,
+		&project.FundingModel,
+		&project.LatePledges,
+		&project.LatePledgesUntil,
+		&project.LateFunding,
 	)
 	if err != nil {
 		switch {
@@ -228,7 +255,7 @@ func (m ProjectModel) GetPublic(id int) (*Project, error) {
 	var project Project
 	var projectImgVar sql.NullString
 	var campaignVar sql.NullString
-	query := `SELECT project_id, title, description, categories, funding_goal, current_funding, deadline, status, project_img, campaign, created_at, updated_at, launched_at, version, creator_id, experts_decision FROM project WHERE project_id = $1 AND (status = 'Live' OR status = 'Completed')`
+	query := `SELECT project_id, title, description, categories, funding_goal, project_funding(project_id) AS current_funding, deadline, status, project_img, campaign, created_at, updated_at, launched_at, version, creator_id, experts_decision, funding_model, late_pledges, late_pledges_until, project_late_funding(project_id) AS late_funding, project_matched_funding(project_id) AS matched_funding FROM project WHERE project_id = $1 AND (status = 'Live' OR status = 'Completed') AND deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -250,6 +277,11 @@ func (m ProjectModel) GetPublic(id int) (*Project, error) {
 		&project.Version,
 		&project.CreatorID,
 		&project.ExpertsDecision,
+		&project.FundingModel,
+		&project.LatePledges,
+		&project.LatePledgesUntil,
+		&project.LateFunding,
+		&project.MatchedFunding,
 	)
 	if err != nil {
 		switch {
@@ -269,7 +301,7 @@ func (m ProjectModel) GetPublic(id int) (*Project, error) {
 func (m ProjectModel) Update(project *Project) error {
 	query := `
 		UPDATE project SET 
-		title = $1, description = $2, categories = $3, funding_goal = $4, current_funding = $5, deadline = $6, status = $7, project_img = $8, campaign = $9, launched_at = $10, is_suspicious = $11, version = version + 1
+		title = $1, description = $2, categories = $3, funding_goal = $4, deadline = $5, status = $6, project_img = $7, campaign = $8, launched_at = $9, is_suspicious = $10, funding_model = $11, version = version + 1
 		WHERE project_id = $12 AND version = $13 RETURNING updated_at, version
 	`
 
@@ -278,13 +310,13 @@ func (m ProjectModel) Update(project *Project) error {
 		project.Description,
 		project.Categories,
 		project.FundingGoal,
-		project.CurrentFunding,
 		project.Deadline,
 		project.Status,
 		project.ProjectImg,
 		project.Campaign,
 		project.LaunchedAt,
 		project.IsSuspicious,
+		project.FundingModel,
 		project.ID,
 		project.Version,
 	}
@@ -304,32 +336,6 @@ func (m ProjectModel) Update(project *Project) error {
 	return nil
 }
 
-func (m ProjectModel) Delete(id int) error {
-	if id < 1 {
-		return ErrNoRecordFound
-	}
-
-	query := `DELETE FROM project WHERE project_id = $1`
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	result, err := m.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if rowsAffected == 0 {
-		return ErrNoRecordFound
-	}
-
-	return nil
-}
-
 func (m ProjectModel) ProjectOwnership(projectID, creatorID int) (bool, error) {
 	query := `
 	SELECT EXISTS (
@@ -355,8 +361,8 @@ func (m ProjectModel) ProjectOwnership(projectID, creatorID int) (bool, error) {
 
 func (m ProjectModel) GetAllByCreator(creatorID int) ([]*Project, error) {
 	query := `
-		SELECT project_id, title, description, categories, funding_goal, current_funding, deadline, status, project_img, campaign, created_at, updated_at, launched_at, version, creator_id, experts_decision
-		FROM project WHERE creator_id = $1
+		SELECT project_id, title, description, categories, funding_goal, project_funding(project_id) AS current_funding, deadline, status, project_img, campaign, created_at, updated_at, launched_at, version, creator_id, experts_decision, funding_model
+		FROM project WHERE creator_id = $1 AND deleted_at IS NULL
 	`
 
 	projects := []*Project{}
@@ -391,6 +397,7 @@ func (m ProjectModel) GetAllByCreator(creatorID int) ([]*Project, error) {
 			&project.Version,
 			&project.CreatorID,
 			&project.ExpertsDecision,
+			&project.FundingModel,
 		)
 		if err != nil {
 			return nil, err
@@ -410,8 +417,8 @@ func (m ProjectModel) GetAllByCreator(creatorID int) ([]*Project, error) {
 
 func (m ProjectModel) GetAllByCreatorPublic(creatorID int) ([]*Project, error) {
 	query := `
-		SELECT project_id, title, description, categories, funding_goal, current_funding, deadline, status, project_img, campaign, created_at, updated_at, launched_at, version, creator_id, experts_decision
-		FROM project WHERE creator_id = $1 AND (status = 'Live' OR status = 'Completed')
+		SELECT project_id, title, description, categories, funding_goal, project_funding(project_id) AS current_funding, deadline, status, project_img, campaign, created_at, updated_at, launched_at, version, creator_id, experts_decision, funding_model
+		FROM project WHERE creator_id = $1 AND (status = 'Live' OR status = 'Completed') AND deleted_at IS NULL
 	`
 
 	projects := []*Project{}
@@ -446,6 +453,7 @@ func (m ProjectModel) GetAllByCreatorPublic(creatorID int) ([]*Project, error) {
 			&project.Version,
 			&project.CreatorID,
 			&project.ExpertsDecision,
+			&project.FundingModel,
 		)
 		if err != nil {
 			return nil, err
@@ -465,8 +473,8 @@ func (m ProjectModel) GetAllByCreatorPublic(creatorID int) ([]*Project, error) {
 
 func (m ProjectModel) GetAllByBacker(backerID int) ([]*Project, error) {
 	query := `
-		SELECT pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, pr.current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, pr.version, pr.creator_id, pr.experts_decision 
-		FROM project pr INNER JOIN backing b ON pr.project_id = b.project_id WHERE b.backer_id = $1
+		SELECT pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, project_funding(pr.project_id) AS current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, pr.version, pr.creator_id, pr.experts_decision, pr.funding_model 
+		FROM project pr INNER JOIN backing b ON pr.project_id = b.project_id WHERE b.backer_id = $1 AND pr.deleted_at IS NULL
 	`
 
 	projects := []*Project{}
@@ -501,6 +509,7 @@ func (m ProjectModel) GetAllByBacker(backerID int) ([]*Project, error) {
 			&project.Version,
 			&project.CreatorID,
 			&project.ExpertsDecision,
+			&project.FundingModel,
 		)
 		if err != nil {
 			return nil, err
@@ -520,8 +529,8 @@ func (m ProjectModel) GetAllByBacker(backerID int) ([]*Project, error) {
 
 func (m ProjectModel) GetAllSavedByCurrentUser(userID int) ([]*Project, error) {
 	query := `
-	SELECT pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, pr.current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, pr.version, pr.creator_id, pr.experts_decision 
-	FROM project pr INNER JOIN save s ON pr.project_id = s.project_id WHERE s.user_id = $1
+	SELECT pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, project_funding(pr.project_id) AS current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, pr.version, pr.creator_id, pr.experts_decision, pr.funding_model 
+	FROM project pr INNER JOIN save s ON pr.project_id = s.project_id WHERE s.user_id = $1 AND pr.deleted_at IS NULL
 `
 
 	projects := []*Project{}
@@ -556,6 +565,7 @@ func (m ProjectModel) GetAllSavedByCurrentUser(userID int) ([]*Project, error) {
 			&project.Version,
 			&project.CreatorID,
 			&project.ExpertsDecision,
+			&project.FundingModel,
 		)
 		if err != nil {
 			return nil, err
@@ -628,8 +638,8 @@ func (m ProjectModel) GetAllByReviewer(reviewerID, page, pageSize int) ([]*Proje
 	offset := (page - 1) * pageSize
 
 	query := `
-		SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, pr.current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, pr.version, pr.creator_id, pr.is_suspicious, pr.experts_decision
-		FROM project pr INNER JOIN project_review pre ON pr.project_id = pre.project_id WHERE pre.reviewer_id = $1 AND pre.status <> 'Flagged'
+		SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, project_funding(pr.project_id) AS current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, pr.version, pr.creator_id, pr.is_suspicious, pr.experts_decision, pr.funding_model
+		FROM project pr INNER JOIN project_review pre ON pr.project_id = pre.project_id WHERE pre.reviewer_id = $1 AND pre.status <> 'Flagged' AND pr.deleted_at IS NULL
 		LIMIT $2 OFFSET $3
 	`
 
@@ -670,6 +680,7 @@ func (m ProjectModel) GetAllByReviewer(reviewerID, page, pageSize int) ([]*Proje
 			&project.CreatorID,
 			&project.IsSuspicious,
 			&project.ExpertsDecision,
+			&project.FundingModel,
 		)
 		if err != nil {
 			return nil, MetaData{}, err
@@ -693,8 +704,8 @@ func (m ProjectModel) GetAllFlaggedByReviewer(reviewerID, page, pageSize int) ([
 	offset := (page - 1) * pageSize
 
 	query := `
-		SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, pr.current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, pr.version, pr.creator_id, pr.is_suspicious, pr.experts_decision
-		FROM project pr INNER JOIN project_review pre ON pr.project_id = pre.project_id WHERE pre.reviewer_id = $1 AND pre.status = 'Flagged'
+		SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, project_funding(pr.project_id) AS current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, pr.version, pr.creator_id, pr.is_suspicious, pr.experts_decision, pr.funding_model
+		FROM project pr INNER JOIN project_review pre ON pr.project_id = pre.project_id WHERE pre.reviewer_id = $1 AND pre.status = 'Flagged' AND pr.deleted_at IS NULL
 		LIMIT $2 OFFSET $3
 	`
 
@@ -735,6 +746,7 @@ func (m ProjectModel) GetAllFlaggedByReviewer(reviewerID, page, pageSize int) ([
 			&project.CreatorID,
 			&project.IsSuspicious,
 			&project.ExpertsDecision,
+			&project.FundingModel,
 		)
 		if err != nil {
 			return nil, MetaData{}, err
@@ -753,3 +765,55 @@ func (m ProjectModel) GetAllFlaggedByReviewer(reviewerID, page, pageSize int) ([
 
 	return projects, metaData, nil
 }
+
+func (m ProjectModel) GetDueForSettlement() ([]int, error) {
+	query := `SELECT project_id FROM project
+	WHERE funding_model = 'all_or_nothing' AND status = 'Live' AND deadline <= NOW() AND settled_at IS NULL
+	ORDER BY deadline`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (m ProjectModel) MarkSettled(id int, status string) error {
+	query := `UPDATE project SET status = $1, settled_at = NOW(), version = version + 1 WHERE project_id = $2 AND settled_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, status, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
@@ -0,0 +1,355 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"projectx/internal/validator"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	PromoPercentage = "percentage"
+	PromoFixed      = "fixed"
+)
+
+var (
+	PromoCodeRX = regexp.MustCompile("^[A-Z0-9_-]{3,32}$")
+
+	ErrPromoCodeExhausted = errors.New("promo code has reached its usage limit")
+	ErrDuplicatePromoCode = errors.New("a promo code with this code already exists for this project")
+)
+
+// PromoCode takes a percentage or a fixed amount off pledges. A fixed
+// DiscountValue is in the same units as pledge amounts. When RewardIDs is set
+// the discount only applies to those rewards, otherwise to the whole pledge.
+type PromoCode struct {
+	ID             int        `json:"id"`
+	ProjectID      int        `json:"project_id"`
+	Code           string     `json:"code"`
+	DiscountType   string     `json:"discount_type"`
+	DiscountValue  float64    `json:"discount_value"`
+	RewardIDs      []int      `json:"reward_ids"`
+	MaxRedemptions *int       `json:"max_redemptions"`
+	Redemptions    int        `json:"redemptions"`
+	StartsAt       *time.Time `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at"`
+	IsActive       bool       `json:"is_active"`
+	CreatedAt      time.Time  `json:"-"`
+	Version        int        `json:"version"`
+}
+
+func ValidatePromoCode(v *validator.Validator, promo *PromoCode, rewards []*Reward) {
+	v.Check(validator.Matches(promo.Code, PromoCodeRX), "code", "Code must be between 3 and 32 letters, digits, dashes or underscores")
+	v.Check(validator.In(promo.DiscountType, PromoPercentage, PromoFixed), "discount_type", "Discount type must be either percentage or fixed")
+	v.Check(promo.DiscountValue > 0, "discount_value", "Discount must be more than 0")
+	if promo.DiscountType == PromoPercentage {
+		v.Check(promo.DiscountValue <= 100, "discount_value", "Percentage discount cannot be more than 100")
+	}
+	if promo.MaxRedemptions != nil {
+		v.Check(*promo.MaxRedemptions > 0, "max_redemptions", "Usage limit must be more than 0")
+	}
+	if promo.StartsAt != nil && promo.EndsAt != nil {
+		v.Check(promo.EndsAt.After(*promo.StartsAt), "ends_at", "End date must be after the start date")
+	}
+
+	v.Check(validator.Unique(promo.RewardIDs), "reward_ids", "A reward cannot be selected more than once")
+	for _, id := range promo.RewardIDs {
+		index := slices.IndexFunc(rewards, func(r *Reward) bool { return r.ID == id })
+		v.Check(index >= 0 && rewards[index].ProjectID == promo.ProjectID, "reward_ids", fmt.Sprintf("Reward %d doesn't belong to this project", id))
+	}
+}
+
+// IsRedeemable tells whether the code can be used for a pledge made at now.
+func (p *PromoCode) IsRedeemable(now time.Time) bool {
+	if !p.IsActive {
+		return false
+	}
+	if p.StartsAt != nil && now.Before(*p.StartsAt) {
+		return false
+	}
+	if p.EndsAt != nil && !now.Before(*p.EndsAt) {
+		return false
+	}
+
+	return true
+}
+
+// PromoDiscount works out what the code takes off a pledge of amount, shipping
+// left aside. A code tied to rewards only discounts the price of those
+// rewards that were selected.
+func PromoDiscount(v *validator.Validator, promo *PromoCode, amount float64, rewardIDs []int, rewards []*Reward, late bool) float64 {
+	base := amount
+
+	if len(promo.RewardIDs) > 0 {
+		base = 0
+		for _, reward := range rewards {
+			if !slices.Contains(rewardIDs, reward.ID) || !slices.Contains(promo.RewardIDs, reward.ID) {
+				continue
+			}
+			if late && reward.LateAmount != nil {
+				base += *reward.LateAmount
+			} else {
+				base += reward.Amount
+			}
+		}
+
+		if base == 0 {
+			v.AddError("promo_code", "Promo code doesn't apply to the selected rewards")
+			return 0
+		}
+	}
+
+	discount := promo.DiscountValue
+	if promo.DiscountType == PromoPercentage {
+		discount = math.Round(base * promo.DiscountValue / 100)
+	}
+
+	return min(discount, base, amount)
+}
+
+type PromoCodeModel struct {
+	DB *sql.DB
+}
+
+const promoCodeColumns = `pc.promo_code_id, pc.project_id, pc.code, pc.discount_type, pc.discount_value, pc.max_redemptions, pc.starts_at, pc.ends_at,
+	pc.is_active, pc.created_at, pc.version,
+	ARRAY(SELECT reward_id FROM promo_code_reward WHERE promo_code_id = pc.promo_code_id ORDER BY reward_id),
+	(SELECT COUNT(*) FROM promo_redemption WHERE promo_code_id = pc.promo_code_id AND redeemed_at IS NOT NULL)`
+
+func scanPromoCode(row rowScanner) (*PromoCode, error) {
+	var promo PromoCode
+	var rewardIDs pq.Int64Array
+
+	err := row.Scan(
+		&promo.ID,
+		&promo.ProjectID,
+		&promo.Code,
+		&promo.DiscountType,
+		&promo.DiscountValue,
+		&promo.MaxRedemptions,
+		&promo.StartsAt,
+		&promo.EndsAt,
+		&promo.IsActive,
+		&promo.CreatedAt,
+		&promo.Version,
+		&rewardIDs,
+		&promo.Redemptions,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	promo.RewardIDs = make([]int, len(rewardIDs))
+	for i, id := range rewardIDs {
+		promo.RewardIDs[i] = int(id)
+	}
+
+	return &promo, nil
+}
+
+func (m PromoCodeModel) Insert(promo *PromoCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO promo_code (project_id, code, discount_type, discount_value, max_redemptions, starts_at, ends_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING promo_code_id, is_active, created_at, version`
+
+	args := []interface{}{
+		promo.ProjectID,
+		promo.Code,
+		promo.DiscountType,
+		promo.DiscountValue,
+		promo.MaxRedemptions,
+		promo.StartsAt,
+		promo.EndsAt,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&promo.ID, &promo.IsActive, &promo.CreatedAt, &promo.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "promo_code_project_id_code_key"`:
+			return ErrDuplicatePromoCode
+		default:
+			return err
+		}
+	}
+
+	for _, rewardID := range promo.RewardIDs {
+		_, err = tx.ExecContext(ctx, `INSERT INTO promo_code_reward (promo_code_id, reward_id) VALUES ($1, $2)`, promo.ID, rewardID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m PromoCodeModel) getOne(where string, args ...interface{}) (*PromoCode, error) {
+	query := `SELECT ` + promoCodeColumns + ` FROM promo_code pc WHERE ` + where
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	promo, err := scanPromoCode(m.DB.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	return promo, nil
+}
+
+func (m PromoCodeModel) Get(id, projectID int) (*PromoCode, error) {
+	return m.getOne(`pc.promo_code_id = $1 AND pc.project_id = $2`, id, projectID)
+}
+
+func (m PromoCodeModel) GetByCode(projectID int, code string) (*PromoCode, error) {
+	return m.getOne(`pc.project_id = $1 AND pc.code = $2`, projectID, code)
+}
+
+func (m PromoCodeModel) GetAll(projectID int) ([]*PromoCode, error) {
+	query := `SELECT ` + promoCodeColumns + ` FROM promo_code pc WHERE pc.project_id = $1 ORDER BY pc.created_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promos := []*PromoCode{}
+
+	for rows.Next() {
+		promo, err := scanPromoCode(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		promos = append(promos, promo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return promos, nil
+}
+
+// Update saves when the code can be used and how many times. The discount
+// itself can't change once backers may have used it.
+func (m PromoCodeModel) Update(promo *PromoCode) error {
+	query := `UPDATE promo_code SET max_redemptions = $1, starts_at = $2, ends_at = $3, is_active = $4, version = version + 1
+	WHERE promo_code_id = $5 AND version = $6
+	RETURNING version`
+
+	args := []interface{}{
+		promo.MaxRedemptions,
+		promo.StartsAt,
+		promo.EndsAt,
+		promo.IsActive,
+		promo.ID,
+		promo.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&promo.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reserve holds a use of the code for a payment until expiresAt. Uses already
+// redeemed and the ones still held count towards the usage limit, the code is
+// locked while they are counted.
+func (m PromoCodeModel) Reserve(promoCodeID, backerID int, transactionID string, discount float64, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var maxRedemptions *int
+
+	err = tx.QueryRowContext(ctx, `SELECT max_redemptions FROM promo_code WHERE promo_code_id = $1 FOR UPDATE`, promoCodeID).Scan(&maxRedemptions)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNoRecordFound
+		default:
+			return err
+		}
+	}
+
+	if maxRedemptions != nil {
+		query := `SELECT COUNT(*) FROM promo_redemption WHERE promo_code_id = $1 AND (redeemed_at IS NOT NULL OR expires_at > NOW())`
+
+		var used int
+		if err := tx.QueryRowContext(ctx, query, promoCodeID).Scan(&used); err != nil {
+			return err
+		}
+
+		if used >= *maxRedemptions {
+			return ErrPromoCodeExhausted
+		}
+	}
+
+	query := `INSERT INTO promo_redemption (promo_code_id, backer_id, transaction_id, discount, expires_at) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err = tx.ExecContext(ctx, query, promoCodeID, backerID, transactionID, discount, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m PromoCodeModel) ReleaseReservation(transactionID string) error {
+	query := `DELETE FROM promo_redemption WHERE transaction_id = $1 AND redeemed_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, transactionID)
+	return err
+}
+
+// redeemPromoCode turns the use held for a payment into a redemption of the
+// backing. The backer paid the discounted amount, so it's redeemed even when
+// the hold already expired.
+func redeemPromoCode(ctx context.Context, tx *sql.Tx, transactionID string, backingID int) error {
+	query := `UPDATE promo_redemption SET backing_id = $1, redeemed_at = NOW() WHERE transaction_id = $2 AND redeemed_at IS NULL`
+
+	_, err := tx.ExecContext(ctx, query, backingID, transactionID)
+	return err
+}
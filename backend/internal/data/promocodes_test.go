@@ -0,0 +1,102 @@
+package data
+
+import (
+	"projectx/internal/validator"
+	"testing"
+)
+
+func TestPromoDiscount(t *testing.T) {
+	lateAmount := 4000.0
+
+	rewards := []*Reward{
+		{ID: 1, Amount: 3000, LateAmount: &lateAmount},
+		{ID: 2, Amount: 2000},
+	}
+
+	tests := []struct {
+		name      string
+		promo     *PromoCode
+		amount    float64
+		rewardIDs []int
+		late      bool
+		want      float64
+		wantValid bool
+	}{
+		{
+			name:      "percentage of the whole pledge",
+			promo:     &PromoCode{DiscountType: PromoPercentage, DiscountValue: 10},
+			amount:    8000,
+			want:      800,
+			wantValid: true,
+		},
+		{
+			name:      "percentage is rounded",
+			promo:     &PromoCode{DiscountType: PromoPercentage, DiscountValue: 15},
+			amount:    1003,
+			want:      150,
+			wantValid: true,
+		},
+		{
+			name:      "fixed amount",
+			promo:     &PromoCode{DiscountType: PromoFixed, DiscountValue: 1500},
+			amount:    8000,
+			want:      1500,
+			wantValid: true,
+		},
+		{
+			name:      "fixed amount is capped at the pledge",
+			promo:     &PromoCode{DiscountType: PromoFixed, DiscountValue: 10000},
+			amount:    8000,
+			want:      8000,
+			wantValid: true,
+		},
+		{
+			name:      "only the rewards the code is tied to",
+			promo:     &PromoCode{DiscountType: PromoPercentage, DiscountValue: 50, RewardIDs: []int{1}},
+			amount:    8000,
+			rewardIDs: []int{1, 2},
+			want:      1500,
+			wantValid: true,
+		},
+		{
+			name:      "late price of the rewards",
+			promo:     &PromoCode{DiscountType: PromoPercentage, DiscountValue: 50, RewardIDs: []int{1}},
+			amount:    8000,
+			rewardIDs: []int{1},
+			late:      true,
+			want:      2000,
+			wantValid: true,
+		},
+		{
+			name:      "fixed amount is capped at the rewards it is tied to",
+			promo:     &PromoCode{DiscountType: PromoFixed, DiscountValue: 5000, RewardIDs: []int{2}},
+			amount:    8000,
+			rewardIDs: []int{1, 2},
+			want:      2000,
+			wantValid: true,
+		},
+		{
+			name:      "tied rewards not selected",
+			promo:     &PromoCode{DiscountType: PromoFixed, DiscountValue: 1000, RewardIDs: []int{2}},
+			amount:    8000,
+			rewardIDs: []int{1},
+			want:      0,
+			wantValid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+
+			got := PromoDiscount(v, tt.promo, tt.amount, tt.rewardIDs, rewards, tt.late)
+
+			if got != tt.want {
+				t.Errorf("discount = %v, want %v", got, tt.want)
+			}
+			if v.Valid() != tt.wantValid {
+				t.Errorf("valid = %t, want %t (errors: %v)", v.Valid(), tt.wantValid, v.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"projectx/internal/validator"
+	"time"
+)
+
+// MaxRefundLockHours is the longest a creator can stop refunds for before the
+// deadline.
+const MaxRefundLockHours = 168
+
+var ErrRefundNotAllowed = errors.New("refunds are not allowed by the project's refund policy")
+
+// RefundPolicy tells when backers can get their money back by themselves.
+// Projects without a policy of their own follow DefaultRefundPolicy.
+type RefundPolicy struct {
+	ProjectID         int  `json:"-"`
+	DisputeOnly       bool `json:"dispute_only"`
+	FinalHoursLocked  int  `json:"final_hours_locked"`
+	AllowAfterSuccess bool `json:"allow_after_success"`
+	IsDefault         bool `json:"is_default"`
+}
+
+func DefaultRefundPolicy(projectID int) *RefundPolicy {
+	return &RefundPolicy{
+		ProjectID:        projectID,
+		FinalHoursLocked: 48,
+		IsDefault:        true,
+	}
+}
+
+func ValidateRefundPolicy(v *validator.Validator, policy *RefundPolicy) {
+	v.Check(policy.FinalHoursLocked >= 0, "final_hours_locked", "Final hours locked cannot be negative")
+	v.Check(policy.FinalHoursLocked <= MaxRefundLockHours, "final_hours_locked", fmt.Sprintf("Final hours locked cannot be more than %d", MaxRefundLockHours))
+}
+
+// Allows returns ErrRefundNotAllowed, wrapped with why, when a backer can't
+// refund their pledge to the project at now.
+func (p *RefundPolicy) Allows(project *Project, now time.Time) error {
+	if p.DisputeOnly {
+		return fmt.Errorf("refunds are only given through a dispute: %w", ErrRefundNotAllowed)
+	}
+
+	succeeded := project.Status == "Completed" || (now.After(project.Deadline) && project.CurrentFunding >= project.FundingGoal)
+	if succeeded && !p.AllowAfterSuccess {
+		return fmt.Errorf("the project has already succeeded: %w", ErrRefundNotAllowed)
+	}
+
+	lockedFrom := project.Deadline.Add(-time.Duration(p.FinalHoursLocked) * time.Hour)
+	if p.FinalHoursLocked > 0 && now.After(lockedFrom) && now.Before(project.Deadline) {
+		return fmt.Errorf("no refunds in the final %d hours of the campaign: %w", p.FinalHoursLocked, ErrRefundNotAllowed)
+	}
+
+	return nil
+}
+
+type RefundPolicyModel struct {
+	DB *sql.DB
+}
+
+func (m RefundPolicyModel) Get(projectID int) (*RefundPolicy, error) {
+	query := `SELECT dispute_only, final_hours_locked, allow_after_success FROM refund_policy WHERE project_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	policy := RefundPolicy{ProjectID: projectID}
+
+	err := m.DB.QueryRowContext(ctx, query, projectID).Scan(&policy.DisputeOnly, &policy.FinalHoursLocked, &policy.AllowAfterSuccess)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return DefaultRefundPolicy(projectID), nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &policy, nil
+}
+
+func (m RefundPolicyModel) Upsert(policy *RefundPolicy) error {
+	query := `INSERT INTO refund_policy (project_id, dispute_only, final_hours_locked, allow_after_success)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (project_id) DO UPDATE
+	SET dispute_only = EXCLUDED.dispute_only, final_hours_locked = EXCLUDED.final_hours_locked, allow_after_success = EXCLUDED.allow_after_success`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, policy.ProjectID, policy.DisputeOnly, policy.FinalHoursLocked, policy.AllowAfterSuccess)
+	if err != nil {
+		return err
+	}
+
+	policy.IsDefault = false
+
+	return nil
+}
@@ -0,0 +1,533 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"projectx/internal/validator"
+	"time"
+)
+
+const (
+	RefundFraud           = "fraud"
+	RefundNonDelivery     = "non_delivery"
+	RefundDuplicate       = "duplicate"
+	RefundGoodwill        = "goodwill"
+	RefundBackerRequest   = "backer_request"
+	RefundPaymentProvider = "payment_provider"
+)
+
+// AdminRefundCategories are the reasons an admin can give money back for.
+var AdminRefundCategories = []string{RefundFraud, RefundNonDelivery, RefundDuplicate, RefundGoodwill}
+
+const (
+	RefundPending   = "pending"
+	RefundCompleted = "completed"
+	RefundFailed    = "failed"
+)
+
+var ErrRefundTooLarge = errors.New("refund is more than what is left on the payment")
+
+// errRefundRecorded is returned when a refund coming from the payment provider
+// was already recorded, by a refund, a pledge change or an earlier event.
+var errRefundRecorded = errors.New("refund already recorded")
+
+func ValidateRefund(v *validator.Validator, amount float64, category, reason string) {
+	v.Check(amount >= 0, "amount", "Refund amount cannot be negative")
+	v.Check(validator.In(category, AdminRefundCategories...), "category", "Category should be either fraud, non_delivery, duplicate or goodwill")
+	ValidateReason(v, reason)
+}
+
+func (m BackingModel) GetByID(id int) (*Backing, error) {
+	query := `SELECT backing_id, backer_id, project_id, created_at FROM backing WHERE backing_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var backing Backing
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&backing.BackingID, &backing.BackerID, &backing.ProjectID, &backing.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &backing, nil
+}
+
+func (m BackingModel) GetCancellations(backingID int) ([]*Cancellation, error) {
+	query := `SELECT cancellation_id, COALESCE(reason, ''), date, backing_id, COALESCE(payment_id, 0), COALESCE(amount, 0), category,
+		COALESCE(refund_id, ''), refunded_by, status, created_at
+	FROM cancellation
+	WHERE backing_id = $1
+	ORDER BY created_at, cancellation_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, backingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cancellations := []*Cancellation{}
+
+	for rows.Next() {
+		var c Cancellation
+
+		err := rows.Scan(&c.CancellationID, &c.Reason, &c.Date, &c.BackingID, &c.PaymentID, &c.Amount, &c.Category, &c.RefundID, &c.RefundedBy, &c.Status, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		cancellations = append(cancellations, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cancellations, nil
+}
+
+// PlanRefund splits amount over the active payments of a backing, latest
+// first, without giving anything back yet. An amount of 0 takes everything
+// that is left. Problems with the amount are added to v.
+func PlanRefund(v *validator.Validator, activePayments []*Payment, amount float64, category, reason string, refundedBy *int) []*Cancellation {
+	cancellations := []*Cancellation{}
+	left := amount
+	refundDate := time.Now()
+
+	for _, payment := range activePayments {
+		if amount != 0 && left <= 0 {
+			break
+		}
+
+		remaining := payment.Amount - payment.RefundedAmount
+		take := remaining
+		if amount != 0 {
+			take = min(left, remaining)
+		}
+
+		if payment.Status == "requires_capture" && take < remaining {
+			v.AddError("amount", "Payments that are only authorized can only be released in full")
+		}
+
+		cancellations = append(cancellations, &Cancellation{
+			PaymentID:  payment.PaymentID,
+			Amount:     take,
+			Category:   category,
+			Reason:     reason,
+			RefundedBy: refundedBy,
+			Date:       refundDate,
+		})
+		left -= take
+	}
+
+	v.Check(amount == 0 || left <= 0, "amount", "Refund amount is more than what is left on the backing")
+
+	return cancellations
+}
+
+// BeginRefund records the cancellations as pending before their money is given
+// back, all or none of them. A payment that is already being refunded returns
+// ErrEditConflict. It returns when the backing was made.
+func (m BackingModel) BeginRefund(backingID int, cancellations []*Cancellation) (*string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var createdAt string
+
+	err = tx.QueryRowContext(ctx, `SELECT created_at FROM backing WHERE backing_id = $1`, backingID).Scan(&createdAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	for _, c := range cancellations {
+		c.BackingID = backingID
+		c.Status = RefundPending
+
+		_, _, err = lockRefundedPayment(ctx, tx, c)
+		if err != nil {
+			return nil, err
+		}
+
+		err = insertCancellation(ctx, tx, c)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "idx_cancellation_pending"`:
+				return nil, ErrEditConflict
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &createdAt, nil
+}
+
+// CompleteRefund records that the money of a pending cancellation was given
+// back with refundID, and takes it off the payment. It returns
+// ErrNoRecordFound when the cancellation isn't pending anymore.
+func (m BackingModel) CompleteRefund(c *Cancellation, refundID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE cancellation SET status = 'completed', refund_id = NULLIF($2, '')
+	WHERE cancellation_id = $1 AND status = 'pending'
+	RETURNING backing_id, payment_id, amount`
+
+	err = tx.QueryRowContext(ctx, query, c.CancellationID, refundID).Scan(&c.BackingID, &c.PaymentID, &c.Amount)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNoRecordFound
+		default:
+			return err
+		}
+	}
+	c.RefundID = refundID
+	c.Status = RefundCompleted
+
+	payment, full, err := lockRefundedPayment(ctx, tx, c)
+	if err != nil {
+		return err
+	}
+
+	err = applyRefund(ctx, tx, payment, c, full)
+	if err != nil {
+		return err
+	}
+
+	err = releaseRefundedRewards(ctx, tx, c.BackingID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// FailRefund records that the money of a pending cancellation couldn't be
+// given back, which frees the payment for another refund.
+func (m BackingModel) FailRefund(cancellationID int) error {
+	query := `UPDATE cancellation SET status = 'failed' WHERE cancellation_id = $1 AND status = 'pending'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, cancellationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecordFound
+	}
+
+	return nil
+}
+
+// PendingRefund is a cancellation recorded before its money was given back,
+// with the payment it is taken from.
+type PendingRefund struct {
+	Cancellation *Cancellation
+	Payment      *Payment
+}
+
+// GetPendingRefunds returns the pending cancellations of the payment.
+func (m BackingModel) GetPendingRefunds(transactionID string) ([]*PendingRefund, error) {
+	return m.getPendingRefunds(`pa.transaction_id = $1`, transactionID)
+}
+
+// GetStalePendingRefunds returns the cancellations still pending since before,
+// left behind when giving the money back or recording it failed.
+func (m BackingModel) GetStalePendingRefunds(before time.Time) ([]*PendingRefund, error) {
+	return m.getPendingRefunds(`ca.created_at < $1`, before)
+}
+
+func (m BackingModel) getPendingRefunds(where string, arg interface{}) ([]*PendingRefund, error) {
+	query := `SELECT ca.cancellation_id, COALESCE(ca.reason, ''), ca.date, ca.backing_id, ca.payment_id, ca.amount, ca.category, ca.refunded_by, ca.created_at,
+		pa.amount, pa.refunded_amount, pa.status, pa.transaction_id
+	FROM cancellation ca
+	INNER JOIN payment pa ON pa.payment_id = ca.payment_id
+	WHERE ca.status = 'pending' AND ` + where + `
+	ORDER BY ca.cancellation_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []*PendingRefund{}
+
+	for rows.Next() {
+		c := Cancellation{Status: RefundPending}
+		var payment Payment
+
+		err := rows.Scan(
+			&c.CancellationID,
+			&c.Reason,
+			&c.Date,
+			&c.BackingID,
+			&c.PaymentID,
+			&c.Amount,
+			&c.Category,
+			&c.RefundedBy,
+			&c.CreatedAt,
+			&payment.Amount,
+			&payment.RefundedAmount,
+			&payment.Status,
+			&payment.TransactionID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		payment.PaymentID = c.PaymentID
+		payment.BackingID = c.BackingID
+
+		refunds = append(refunds, &PendingRefund{Cancellation: &c, Payment: &payment})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return refunds, nil
+}
+
+// ReconcileRefunded records a refund made through the payment provider. It
+// returns ErrNoRecordFound when there is nothing left to record.
+func (m BackingModel) ReconcileRefunded(transactionID string, c *Cancellation) (*Payment, *Backing, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var backing Backing
+
+	query := `SELECT pa.payment_id, b.backing_id, b.backer_id, b.project_id, b.created_at
+	FROM payment pa
+	INNER JOIN backing b ON b.backing_id = pa.backing_id
+	WHERE pa.transaction_id = $1`
+
+	err = tx.QueryRowContext(ctx, query, transactionID).Scan(&c.PaymentID, &backing.BackingID, &backing.BackerID, &backing.ProjectID, &backing.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrNoRecordFound
+		default:
+			return nil, nil, err
+		}
+	}
+	c.BackingID = backing.BackingID
+
+	payment, err := refundPayment(ctx, tx, c)
+	if err != nil {
+		switch {
+		case errors.Is(err, errRefundRecorded), errors.Is(err, ErrEditConflict):
+			return nil, nil, ErrNoRecordFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	err = releaseRefundedRewards(ctx, tx, backing.BackingID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return payment, &backing, nil
+}
+
+// refundPayment takes c.Amount off what is left on the payment, or all of it
+// when c.Amount is 0, and records it as a completed cancellation.
+func refundPayment(ctx context.Context, tx *sql.Tx, c *Cancellation) (*Payment, error) {
+	if c.RefundID != "" {
+		var recorded bool
+
+		query := `SELECT EXISTS (SELECT 1 FROM cancellation WHERE refund_id = $1)
+			OR EXISTS (SELECT 1 FROM backing_history WHERE $1 = ANY(refund_ids))`
+
+		err := tx.QueryRowContext(ctx, query, c.RefundID).Scan(&recorded)
+		if err != nil {
+			return nil, err
+		}
+		if recorded {
+			return nil, errRefundRecorded
+		}
+	}
+
+	payment, full, err := lockRefundedPayment(ctx, tx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Status = RefundCompleted
+
+	err = insertCancellation(ctx, tx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = applyRefund(ctx, tx, payment, c, full)
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// lockRefundedPayment locks the payment c is taken from and checks what is
+// left on it covers c. A c.Amount of 0 takes all of it. An authorization hold
+// can only be released in full.
+func lockRefundedPayment(ctx context.Context, tx *sql.Tx, c *Cancellation) (*Payment, bool, error) {
+	payment := Payment{PaymentID: c.PaymentID}
+
+	query := `SELECT amount, refunded_amount, status, transaction_id, backing_id, created_at
+	FROM payment
+	WHERE payment_id = $1
+	FOR UPDATE`
+
+	err := tx.QueryRowContext(ctx, query, c.PaymentID).Scan(
+		&payment.Amount,
+		&payment.RefundedAmount,
+		&payment.Status,
+		&payment.TransactionID,
+		&payment.BackingID,
+		&payment.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, false, ErrNoRecordFound
+		default:
+			return nil, false, err
+		}
+	}
+
+	if payment.BackingID != c.BackingID || !validator.In(payment.Status, "succeeded", "partially_refunded", "requires_capture") {
+		return nil, false, ErrEditConflict
+	}
+
+	remaining := payment.Amount - payment.RefundedAmount
+	if c.Amount == 0 {
+		c.Amount = remaining
+	}
+	if c.Amount > remaining {
+		return nil, false, ErrRefundTooLarge
+	}
+
+	full := c.Amount == remaining
+	if payment.Status == "requires_capture" && !full {
+		return nil, false, ErrEditConflict
+	}
+
+	return &payment, full, nil
+}
+
+func insertCancellation(ctx context.Context, tx *sql.Tx, c *Cancellation) error {
+	query := `INSERT INTO cancellation (reason, date, backing_id, payment_id, amount, category, refund_id, refunded_by, status)
+	VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9)
+	RETURNING cancellation_id, created_at`
+
+	args := []interface{}{
+		c.Reason,
+		c.Date,
+		c.BackingID,
+		c.PaymentID,
+		c.Amount,
+		c.Category,
+		c.RefundID,
+		c.RefundedBy,
+		c.Status,
+	}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&c.CancellationID, &c.CreatedAt)
+}
+
+// applyRefund takes c off the payment locked by lockRefundedPayment and moves
+// it out of the project's funding.
+func applyRefund(ctx context.Context, tx *sql.Tx, payment *Payment, c *Cancellation, full bool) error {
+	switch {
+	case payment.Status == "requires_capture":
+		payment.Status = "canceled"
+	case full:
+		payment.Status = "refunded"
+	default:
+		payment.Status = "partially_refunded"
+	}
+	payment.RefundedAmount += c.Amount
+
+	query := `UPDATE payment SET status = $1, refunded_amount = $2, version = version + 1 WHERE payment_id = $3`
+
+	_, err := tx.ExecContext(ctx, query, payment.Status, payment.RefundedAmount, payment.PaymentID)
+	if err != nil {
+		return err
+	}
+
+	amount := minorUnits(c.Amount)
+	if full {
+		amount = 0
+	}
+
+	return postRefund(ctx, tx, payment.PaymentID, amount)
+}
+
+// releaseRefundedRewards gives the rewards of the backing back once none of
+// its payments count anymore.
+func releaseRefundedRewards(ctx context.Context, tx *sql.Tx, backingID int) error {
+	var active bool
+
+	query := `SELECT EXISTS (SELECT 1 FROM payment WHERE backing_id = $1 AND status IN ('succeeded', 'partially_refunded', 'requires_capture'))`
+
+	err := tx.QueryRowContext(ctx, query, backingID).Scan(&active)
+	if err != nil {
+		return err
+	}
+	if active {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, releaseBackingRewardsQuery, backingID)
+	return err
+}
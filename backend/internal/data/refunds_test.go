@@ -0,0 +1,101 @@
+package data
+
+import (
+	"projectx/internal/validator"
+	"testing"
+)
+
+func TestPlanRefund(t *testing.T) {
+	captured := &Payment{PaymentID: 3, Amount: 5000, RefundedAmount: 1000, Status: "partially_refunded"}
+	held := &Payment{PaymentID: 2, Amount: 3000, Status: "requires_capture"}
+	older := &Payment{PaymentID: 1, Amount: 2000, Status: "succeeded"}
+
+	type planned struct {
+		paymentID int
+		amount    float64
+	}
+
+	tests := []struct {
+		name           string
+		activePayments []*Payment
+		amount         float64
+		want           []planned
+		wantValid      bool
+	}{
+		{
+			name:           "everything that is left",
+			activePayments: []*Payment{captured, held, older},
+			amount:         0,
+			want:           []planned{{3, 4000}, {2, 3000}, {1, 2000}},
+			wantValid:      true,
+		},
+		{
+			name:           "part of the latest payment",
+			activePayments: []*Payment{captured, older},
+			amount:         1500,
+			want:           []planned{{3, 1500}},
+			wantValid:      true,
+		},
+		{
+			name:           "spills over onto older payments",
+			activePayments: []*Payment{captured, older},
+			amount:         5000,
+			want:           []planned{{3, 4000}, {1, 1000}},
+			wantValid:      true,
+		},
+		{
+			name:           "whole hold",
+			activePayments: []*Payment{held, older},
+			amount:         3000,
+			want:           []planned{{2, 3000}},
+			wantValid:      true,
+		},
+		{
+			name:           "part of a hold",
+			activePayments: []*Payment{held, older},
+			amount:         1000,
+			want:           []planned{{2, 1000}},
+			wantValid:      false,
+		},
+		{
+			name:           "more than what is left",
+			activePayments: []*Payment{captured, older},
+			amount:         7000,
+			want:           []planned{{3, 4000}, {1, 2000}},
+			wantValid:      false,
+		},
+	}
+
+	refundedBy := 7
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+
+			cancellations := PlanRefund(v, tt.activePayments, tt.amount, RefundGoodwill, "reason", &refundedBy)
+
+			if v.Valid() != tt.wantValid {
+				t.Errorf("valid = %t, want %t (errors: %v)", v.Valid(), tt.wantValid, v.Errors)
+			}
+
+			got := make([]planned, len(cancellations))
+			for i, c := range cancellations {
+				got[i] = planned{c.PaymentID, c.Amount}
+
+				if c.Category != RefundGoodwill || c.Reason != "reason" || c.RefundedBy != &refundedBy || c.Date.IsZero() {
+					t.Errorf("cancellation %d = %+v, want it to carry the category, reason, admin and date", i, c)
+				}
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("planned %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("planned %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
@@ -3,34 +3,54 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"projectx/internal/validator"
+	"slices"
 	"time"
 
 	"github.com/lib/pq"
 )
 
 type Reward struct {
-	ID                int            `json:"id"`
-	ProjectID         int            `json:"project_id"`
-	Title             string         `json:"title"`
-	Description       string         `json:"description"`
-	Amount            float64        `json:"amount"`
-	EstimatedDelivery time.Time      `json:"estimated_delivery"`
-	ImageURL          string         `json:"image_url"`
-	IsAvailable       bool           `json:"is_available"`
-	Includes          pq.StringArray `json:"includes"`
-	CreatedAt         time.Time      `json:"-"`
-	UpdatedAt         time.Time      `json:"-"`
-	Version           int32          `json:"version"`
-	BackingID         int            `json:"backing_id"`
+	ID                int              `json:"id"`
+	ProjectID         int              `json:"project_id"`
+	Title             string           `json:"title"`
+	Description       string           `json:"description"`
+	Amount            float64          `json:"amount"`
+	LateAmount        *float64         `json:"late_amount"`
+	EstimatedDelivery time.Time        `json:"estimated_delivery"`
+	ImageURL          string           `json:"image_url"`
+	IsAvailable       bool             `json:"is_available"`
+	QuantityLimit     *int             `json:"quantity_limit"`
+	Remaining         *int             `json:"remaining"`
+	Includes          pq.StringArray   `json:"includes"`
+	IsAddon           bool             `json:"is_addon"`
+	Variants          []RewardVariant  `json:"variants"`
+	Variant           *RewardVariant   `json:"variant,omitempty"`
+	Shipping          []RewardShipping `json:"shipping"`
+	ShippingFee       *float64         `json:"shipping_fee,omitempty"`
+	ShippingAddress   json.RawMessage  `json:"shipping_address,omitempty"`
+	Fulfillment       *Fulfillment     `json:"fulfillment,omitempty"`
+	CreatedAt         time.Time        `json:"-"`
+	UpdatedAt         time.Time        `json:"-"`
+	Version           int32            `json:"version"`
+	BackingID         int              `json:"backing_id"`
 }
 
 type RewardModel struct {
 	DB *sql.DB
 }
 
+var ErrRewardSoldOut = errors.New("this reward is sold out")
+
+// rewardRemaining counts pledged rewards and reservations that haven't expired
+// yet against the limit. It is NULL for unlimited rewards.
+const rewardRemaining = `GREATEST(r.quantity_limit
+	- (SELECT COUNT(*) FROM backing_reward br WHERE br.reward_id = r.reward_id)
+	- (SELECT COUNT(*) FROM reward_reservation rr WHERE rr.reward_id = r.reward_id AND rr.expires_at > NOW()), 0)`
+
 func IfThenElseMessage(condition bool, a string, b string) string {
 	if condition {
 		return a
@@ -42,19 +62,29 @@ func ValidateReward(v *validator.Validator, reward *Reward, index int32) {
 	v.Check(reward.Title != "", "title", IfThenElseMessage(index == 0, "Title must be provided", fmt.Sprintf("Title %d must be provided", index)))
 	v.Check(validator.MaxChars(reward.Title, 100), "title", IfThenElseMessage(index == 0, "Title cannot be more than 100 characters", fmt.Sprintf("Title %d cannot be more than 100 characters", index)))
 
-	v.Check(reward.Amount >= 10000, "amount", IfThenElseMessage(index == 0, "Amount must be at least 10000", fmt.Sprintf("Amount %d must be at least 10000", index)))
+	// add-ons only come along with a base reward, so they can cost less
+	if reward.IsAddon {
+		v.Check(reward.Amount > 0, "amount", IfThenElseMessage(index == 0, "Amount must be greater than zero", fmt.Sprintf("Amount %d must be greater than zero", index)))
+	} else {
+		v.Check(reward.Amount >= 10000, "amount", IfThenElseMessage(index == 0, "Amount must be at least 10000", fmt.Sprintf("Amount %d must be at least 10000", index)))
+	}
 
 	v.Check(reward.EstimatedDelivery.GoString() != "", "estimated_delivery", IfThenElseMessage(index == 0, "Estimated delivery must be provided", fmt.Sprintf("Estimated delivery %d must be provided", index)))
 	v.Check(reward.EstimatedDelivery.After(time.Now()), "estimated_delivery", IfThenElseMessage(index == 0, "Estimated delivery should be after the date of today", fmt.Sprintf("Estimated delivery %d should be after the date of today", index)))
 
+	v.Check(reward.QuantityLimit == nil || *reward.QuantityLimit > 0, "quantity_limit", IfThenElseMessage(index == 0, "Quantity limit must be greater than zero", fmt.Sprintf("Quantity limit %d must be greater than zero", index)))
+
 	v.Check(len(reward.Includes) > 0, "includes", IfThenElseMessage(index == 0, "Includes must be provided", fmt.Sprintf("Includes %d must be provided", index)))
 	for i, include := range reward.Includes {
 		v.Check(validator.MaxChars(include, 300), "includes", IfThenElseMessage(index == 0, fmt.Sprintf("Include %d cannot be more than 300 characters", i), fmt.Sprintf("Include %d of reward %d cannot be more than 300 characters", i, index)))
 	}
+
+	ValidateRewardShipping(v, reward.Shipping, index)
+	ValidateRewardVariants(v, reward.Variants, index)
 }
 
 func (m RewardModel) InsertAll(reward []Reward, projectID int) error {
-	query := `INSERT INTO reward (project_id, title, description, amount, estimated_delivery, image_url, is_available, includes) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	query := `INSERT INTO reward (project_id, title, description, amount, estimated_delivery, image_url, is_available, includes, quantity_limit, is_addon, is_retired) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOT $7) RETURNING reward_id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -64,8 +94,20 @@ func (m RewardModel) InsertAll(reward []Reward, projectID int) error {
 		return err
 	}
 
-	for _, r := range reward {
-		_, err := tx.ExecContext(ctx, query, projectID, r.Title, r.Description, r.Amount, r.EstimatedDelivery, r.ImageURL, r.IsAvailable, r.Includes)
+	for i, r := range reward {
+		err := tx.QueryRowContext(ctx, query, projectID, r.Title, r.Description, r.Amount, r.EstimatedDelivery, r.ImageURL, r.IsAvailable, r.Includes, r.QuantityLimit, r.IsAddon).Scan(&reward[i].ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = replaceRewardShipping(ctx, tx, reward[i].ID, r.Shipping)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = saveRewardVariants(ctx, tx, reward[i].ID, reward[i].Variants)
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -76,7 +118,8 @@ func (m RewardModel) InsertAll(reward []Reward, projectID int) error {
 }
 
 func (m RewardModel) GetAll(id int) (*[]Reward, error) {
-	query := `SELECT reward_id, project_id, title, description, amount, image_url, includes, estimated_delivery, is_available FROM reward WHERE project_id = $1`
+	query := `SELECT r.reward_id, r.project_id, r.title, r.description, r.amount, r.image_url, r.includes, r.estimated_delivery, r.is_available, r.quantity_limit, r.late_amount, r.is_addon, ` + rewardRemaining + `
+	FROM reward r WHERE r.project_id = $1 ORDER BY r.amount, r.reward_id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -90,19 +133,56 @@ func (m RewardModel) GetAll(id int) (*[]Reward, error) {
 	var rewards []Reward
 	for rows.Next() {
 		var reward Reward
-		err := rows.Scan(&reward.ID, &reward.ProjectID, &reward.Title, &reward.Description, &reward.Amount, &reward.ImageURL, &reward.Includes, &reward.EstimatedDelivery, &reward.IsAvailable)
+		var quantityLimit sql.NullInt64
+		var remaining sql.NullInt64
+		err := rows.Scan(&reward.ID, &reward.ProjectID, &reward.Title, &reward.Description, &reward.Amount, &reward.ImageURL, &reward.Includes, &reward.EstimatedDelivery, &reward.IsAvailable, &quantityLimit, &reward.LateAmount, &reward.IsAddon, &remaining)
 		if err != nil {
 			return nil, err
 		}
+		reward.QuantityLimit = nullableInt(quantityLimit)
+		reward.Remaining = nullableInt(remaining)
 		rewards = append(rewards, reward)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(rewards))
+	for i, reward := range rewards {
+		ids[i] = reward.ID
+	}
+
+	shipping, err := loadRewardShipping(ctx, m.DB, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := loadRewardVariants(ctx, m.DB, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rewards {
+		rewards[i].Shipping = shipping[rewards[i].ID]
+		rewards[i].Variants = variants[rewards[i].ID]
+	}
 
 	return &rewards, nil
 }
 
+// UpdateAll keeps the rewards that are sent back with their id so their
+// pledges and reservations survive. Rewards left out are deleted, unless someone
+// already pledged for them, in which case they're retired: unavailable for good,
+// even when units are given back later. Rewards the creator switches off are
+// retired the same way until they're switched back on.
 func (m RewardModel) UpdateAll(rewards []Reward, projectID int) error {
-	reInsertQuery := `INSERT INTO reward (project_id, title, description, amount, estimated_delivery, image_url, is_available, includes) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
-	deleteQuery := `DELETE FROM reward WHERE project_id = $1`
+	insertQuery := `INSERT INTO reward (project_id, title, description, amount, estimated_delivery, image_url, is_available, includes, quantity_limit, is_addon, is_retired)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOT $7)
+	RETURNING reward_id`
+
+	updateQuery := `UPDATE reward r SET title = $1, description = $2, amount = $3, estimated_delivery = $4, image_url = $5, includes = $6, quantity_limit = $7,
+	is_available = $11 AND ($7::integer IS NULL OR (SELECT COUNT(*) FROM backing_reward br WHERE br.reward_id = r.reward_id) < $7::integer), is_retired = NOT $11, is_addon = $10, updated_at = NOW()
+	WHERE r.reward_id = $8 AND r.project_id = $9`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -111,28 +191,95 @@ func (m RewardModel) UpdateAll(rewards []Reward, projectID int) error {
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	kept := []int64{}
+
+	for i, r := range rewards {
+		if r.ID == 0 {
+			args := []interface{}{
+				projectID,
+				r.Title,
+				r.Description,
+				r.Amount,
+				r.EstimatedDelivery,
+				r.ImageURL,
+				r.IsAvailable,
+				r.Includes,
+				r.QuantityLimit,
+				r.IsAddon,
+			}
+			err = tx.QueryRowContext(ctx, insertQuery, args...).Scan(&rewards[i].ID)
+			if err != nil {
+				return err
+			}
+
+			err = replaceRewardShipping(ctx, tx, rewards[i].ID, r.Shipping)
+			if err != nil {
+				return err
+			}
+
+			err = saveRewardVariants(ctx, tx, rewards[i].ID, rewards[i].Variants)
+			if err != nil {
+				return err
+			}
+
+			kept = append(kept, int64(rewards[i].ID))
+			continue
+		}
 
-	_, err = tx.ExecContext(ctx, deleteQuery, projectID)
-	if err != nil {
-		return err
-	}
-
-	for _, r := range rewards {
 		args := []interface{}{
-			projectID,
 			r.Title,
 			r.Description,
 			r.Amount,
 			r.EstimatedDelivery,
 			r.ImageURL,
-			r.IsAvailable,
 			r.Includes,
+			r.QuantityLimit,
+			r.ID,
+			projectID,
+			r.IsAddon,
+			r.IsAvailable,
 		}
-		_, err := tx.ExecContext(ctx, reInsertQuery, args...)
+		result, err := tx.ExecContext(ctx, updateQuery, args...)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNoRecordFound
+		}
+
+		err = replaceRewardShipping(ctx, tx, r.ID, r.Shipping)
+		if err != nil {
+			return err
+		}
+
+		err = saveRewardVariants(ctx, tx, r.ID, rewards[i].Variants)
+		if err != nil {
+			return err
+		}
+
+		kept = append(kept, int64(r.ID))
+	}
+
+	query := `DELETE FROM reward r WHERE r.project_id = $1 AND NOT (r.reward_id = ANY($2))
+	AND NOT EXISTS (SELECT 1 FROM backing_reward br WHERE br.reward_id = r.reward_id)`
+
+	_, err = tx.ExecContext(ctx, query, projectID, pq.Array(kept))
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE reward SET is_available = FALSE, is_retired = TRUE, updated_at = NOW() WHERE project_id = $1 AND NOT (reward_id = ANY($2))`
+
+	_, err = tx.ExecContext(ctx, query, projectID, pq.Array(kept))
+	if err != nil {
+		return err
 	}
 
 	return tx.Commit()
@@ -143,11 +290,15 @@ func (m RewardModel) Get(id int) (*Reward, error) {
 		return nil, ErrNoRecordFound
 	}
 	var reward Reward
-	query := `SELECT reward_id, project_id, title, description, amount, image_url, includes, estimated_delivery, is_available FROM reward WHERE reward_id = $1`
+	query := `SELECT r.reward_id, r.project_id, r.title, r.description, r.amount, r.image_url, r.includes, r.estimated_delivery, r.is_available, r.quantity_limit, r.late_amount, r.is_addon, ` + rewardRemaining + `
+	FROM reward r WHERE r.reward_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	var quantityLimit sql.NullInt64
+	var remaining sql.NullInt64
+
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&reward.ID,
 		&reward.ProjectID,
@@ -158,6 +309,10 @@ func (m RewardModel) Get(id int) (*Reward, error) {
 		&reward.Includes,
 		&reward.EstimatedDelivery,
 		&reward.IsAvailable,
+		&quantityLimit,
+		&reward.LateAmount,
+		&reward.IsAddon,
+		&remaining,
 	)
 	if err != nil {
 		switch {
@@ -167,9 +322,67 @@ func (m RewardModel) Get(id int) (*Reward, error) {
 			return nil, err
 		}
 	}
+	reward.QuantityLimit = nullableInt(quantityLimit)
+	reward.Remaining = nullableInt(remaining)
+
+	shipping, err := loadRewardShipping(ctx, m.DB, []int{reward.ID})
+	if err != nil {
+		return nil, err
+	}
+	reward.Shipping = shipping[reward.ID]
+
+	variants, err := loadRewardVariants(ctx, m.DB, []int{reward.ID})
+	if err != nil {
+		return nil, err
+	}
+	reward.Variants = variants[reward.ID]
+
 	return &reward, nil
 }
 
+func (m RewardModel) GetByIDs(ids []int) ([]*Reward, error) {
+	query := `SELECT reward_id, project_id, title, amount, late_amount, is_available, is_addon FROM reward WHERE reward_id = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rewards := []*Reward{}
+	for rows.Next() {
+		var reward Reward
+		err := rows.Scan(&reward.ID, &reward.ProjectID, &reward.Title, &reward.Amount, &reward.LateAmount, &reward.IsAvailable, &reward.IsAddon)
+		if err != nil {
+			return nil, err
+		}
+		rewards = append(rewards, &reward)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	shipping, err := loadRewardShipping(ctx, m.DB, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := loadRewardVariants(ctx, m.DB, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reward := range rewards {
+		reward.Shipping = shipping[reward.ID]
+		reward.Variants = variants[reward.ID]
+	}
+
+	return rewards, nil
+}
+
 func (m RewardModel) InsertBackingReward(backingID, rewardID int) error {
 	query := `INSERT INTO backing_reward (backing_id, reward_id) VALUES ($1, $2)`
 
@@ -199,12 +412,10 @@ func (m RewardModel) CheckBackingWithRewards(backingID int) (bool, error) {
 }
 
 func (m RewardModel) DeleteBackingReward(backingID int) error {
-	query := `DELETE FROM backing_reward WHERE backing_id = $1`
-
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, backingID)
+	_, err := m.DB.ExecContext(ctx, releaseBackingRewardsQuery, backingID)
 	if err != nil {
 		return err
 	}
@@ -212,10 +423,13 @@ func (m RewardModel) DeleteBackingReward(backingID int) error {
 }
 
 func (m RewardModel) GetAllByBacking(backingID int) (*[]Reward, error) {
-	query := `SELECT r.reward_id, r.project_id, r.title, r.description, r.amount, r.image_url, r.includes, r.estimated_delivery, r.is_available 
+	query := `SELECT r.reward_id, r.project_id, r.title, r.description, r.amount, r.image_url, r.includes, r.estimated_delivery, r.is_available, r.is_addon, br.shipping_fee, br.shipping_address,
+		br.fulfillment_status, COALESCE(br.carrier, ''), COALESCE(br.tracking_number, ''), br.fulfillment_updated_at,
+		rv.variant_id, COALESCE(rv.name, ''), COALESCE(rv.options, '{}')
 	FROM reward r 
 	INNER JOIN backing_reward br 
 	ON br.reward_id = r.reward_id 
+	LEFT JOIN reward_variant rv ON rv.variant_id = br.variant_id
 	WHERE br.backing_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -230,12 +444,265 @@ func (m RewardModel) GetAllByBacking(backingID int) (*[]Reward, error) {
 	var rewards []Reward
 	for rows.Next() {
 		var reward Reward
-		err := rows.Scan(&reward.ID, &reward.ProjectID, &reward.Title, &reward.Description, &reward.Amount, &reward.ImageURL, &reward.Includes, &reward.EstimatedDelivery, &reward.IsAvailable)
+		var shippingAddress []byte
+		var fulfillment Fulfillment
+		var variantID sql.NullInt64
+		var variant RewardVariant
+		var options []byte
+		err := rows.Scan(&reward.ID, &reward.ProjectID, &reward.Title, &reward.Description, &reward.Amount, &reward.ImageURL, &reward.Includes, &reward.EstimatedDelivery, &reward.IsAvailable, &reward.IsAddon, &reward.ShippingFee, &shippingAddress,
+			&fulfillment.Status, &fulfillment.Carrier, &fulfillment.TrackingNumber, &fulfillment.UpdatedAt,
+			&variantID, &variant.Name, &options)
 		if err != nil {
 			return nil, err
 		}
+		if variantID.Valid {
+			variant.ID = int(variantID.Int64)
+			if err = json.Unmarshal(options, &variant.Options); err != nil {
+				return nil, err
+			}
+			reward.Variant = &variant
+		}
+		reward.ShippingAddress = shippingAddress
+		reward.Fulfillment = &fulfillment
 		rewards = append(rewards, reward)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	history, err := loadFulfillmentHistory(ctx, m.DB, backingID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rewards {
+		rewards[i].Fulfillment.History = history[rewards[i].ID]
+		if rewards[i].Fulfillment.History == nil {
+			rewards[i].Fulfillment.History = []*FulfillmentEvent{}
+		}
+	}
 
 	return &rewards, nil
 }
+
+// Reserve holds one of each reward for a payment that is still being made, so
+// other backers can't take the last units in the meantime.
+func (m RewardModel) Reserve(projectID, backerID int, transactionID string, rewardIDs []int, variants map[int]int, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, rewardID := range sortedIDs(rewardIDs) {
+		remaining, err := lockReward(ctx, tx, projectID, rewardID)
+		if err != nil {
+			return err
+		}
+
+		if remaining != nil && *remaining <= 0 {
+			return fmt.Errorf("reward %d: %w", rewardID, ErrRewardSoldOut)
+		}
+
+		variantID := variantOf(variants, rewardID)
+		if variantID != nil {
+			remaining, err = variantUnitsLeft(ctx, tx, rewardID, *variantID)
+			if err != nil {
+				return err
+			}
+
+			if remaining != nil && *remaining <= 0 {
+				return fmt.Errorf("variant %d of reward %d: %w", *variantID, rewardID, ErrRewardSoldOut)
+			}
+		}
+
+		query := `INSERT INTO reward_reservation (reward_id, backer_id, transaction_id, expires_at, variant_id) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (transaction_id, reward_id) DO UPDATE SET expires_at = EXCLUDED.expires_at, variant_id = EXCLUDED.variant_id`
+
+		_, err = tx.ExecContext(ctx, query, rewardID, backerID, transactionID, expiresAt, variantID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// claimRewards attaches the rewards to a recorded backing, using up the
+// reservations made for the payment. Rewards whose reservation expired are only
+// attached if there are still units left, otherwise ErrRewardSoldOut is
+// returned and the transaction should be rolled back. The address and shipping
+// fees of the shipment are copied onto each pledged reward, along with the
+// variant picked for it.
+func claimRewards(ctx context.Context, tx *sql.Tx, projectID, backingID int, transactionID string, rewardIDs []int, variants map[int]int, shipment *Shipment) error {
+	address, addressID, err := shipment.snapshot()
+	if err != nil {
+		return err
+	}
+
+	for _, rewardID := range sortedIDs(rewardIDs) {
+		remaining, err := lockReward(ctx, tx, projectID, rewardID)
+		if err != nil {
+			return err
+		}
+
+		var reserved bool
+		query := `DELETE FROM reward_reservation WHERE reward_id = $1 AND transaction_id = $2 RETURNING expires_at > NOW()`
+
+		err = tx.QueryRowContext(ctx, query, rewardID, transactionID).Scan(&reserved)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if !reserved && remaining != nil && *remaining <= 0 {
+			return fmt.Errorf("reward %d: %w", rewardID, ErrRewardSoldOut)
+		}
+
+		variantID := variantOf(variants, rewardID)
+		if variantID != nil && !reserved {
+			remaining, err = variantUnitsLeft(ctx, tx, rewardID, *variantID)
+			if err != nil {
+				return err
+			}
+
+			if remaining != nil && *remaining <= 0 {
+				return fmt.Errorf("variant %d of reward %d: %w", *variantID, rewardID, ErrRewardSoldOut)
+			}
+		}
+
+		var fee *float64
+		if shipment != nil {
+			if f, ok := shipment.Fees[rewardID]; ok {
+				fee = &f
+			}
+		}
+
+		query = `INSERT INTO backing_reward (backing_id, reward_id, address_id, shipping_address, shipping_fee, variant_id) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`
+
+		_, err = tx.ExecContext(ctx, query, backingID, rewardID, addressID, address, fee, variantID)
+		if err != nil {
+			return err
+		}
+
+		if variantID != nil {
+			query = `UPDATE reward_variant SET is_available = FALSE
+			WHERE variant_id = $1 AND quantity_limit <= (SELECT COUNT(*) FROM backing_reward WHERE variant_id = $1)`
+
+			_, err = tx.ExecContext(ctx, query, *variantID)
+			if err != nil {
+				return err
+			}
+		}
+
+		query = `UPDATE reward SET is_available = FALSE, updated_at = NOW()
+		WHERE reward_id = $1 AND quantity_limit <= (SELECT COUNT(*) FROM backing_reward WHERE reward_id = $1)`
+
+		_, err = tx.ExecContext(ctx, query, rewardID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m RewardModel) ReleaseReservations(transactionID string) error {
+	query := `DELETE FROM reward_reservation WHERE transaction_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, transactionID)
+	return err
+}
+
+func (m RewardModel) DeleteExpiredReservations() (int64, error) {
+	query := `DELETE FROM reward_reservation WHERE expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// releaseUnitsQuery makes the rewards and variants of the released backing
+// rewards available again. Retired ones stay unavailable, and so do the ones
+// still sold out: the released rows are still counted in backing_reward by the
+// subqueries, since they run on the snapshot taken before the delete.
+const releaseUnitsQuery = `, released_variants AS (
+	UPDATE reward_variant rv SET is_available = TRUE
+	WHERE rv.variant_id IN (SELECT variant_id FROM released) AND NOT rv.is_retired
+	AND rv.quantity_limit > (SELECT COUNT(*) FROM backing_reward br WHERE br.variant_id = rv.variant_id)
+		- (SELECT COUNT(*) FROM released WHERE released.variant_id = rv.variant_id)
+)
+UPDATE reward r SET is_available = TRUE, updated_at = NOW()
+WHERE r.reward_id IN (SELECT reward_id FROM released) AND NOT r.is_retired
+AND r.quantity_limit > (SELECT COUNT(*) FROM backing_reward br WHERE br.reward_id = r.reward_id)
+	- (SELECT COUNT(*) FROM released WHERE released.reward_id = r.reward_id)`
+
+// releaseRewardsQuery gives the units of some of a backing's rewards back.
+const releaseRewardsQuery = `WITH released AS (
+	DELETE FROM backing_reward WHERE backing_id = $1 AND reward_id = ANY($2) RETURNING reward_id, variant_id
+)` + releaseUnitsQuery
+
+// releaseBackingRewardsQuery gives the units of a refunded backing back to
+// their rewards.
+const releaseBackingRewardsQuery = `WITH released AS (
+	DELETE FROM backing_reward WHERE backing_id = $1 RETURNING reward_id, variant_id
+)` + releaseUnitsQuery
+
+// lockReward locks the reward row for the rest of the transaction, which
+// serializes every claim on it, and returns the units left.
+func lockReward(ctx context.Context, tx *sql.Tx, projectID, rewardID int) (*int, error) {
+	query := `SELECT is_available FROM reward WHERE reward_id = $1 AND project_id = $2 FOR UPDATE`
+
+	var isAvailable bool
+
+	err := tx.QueryRowContext(ctx, query, rewardID, projectID).Scan(&isAvailable)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, fmt.Errorf("reward %d: %w", rewardID, ErrNoRecordFound)
+		default:
+			return nil, err
+		}
+	}
+
+	if !isAvailable {
+		zero := 0
+		return &zero, nil
+	}
+
+	query = `SELECT ` + rewardRemaining + ` FROM reward r WHERE r.reward_id = $1`
+
+	var remaining sql.NullInt64
+
+	err = tx.QueryRowContext(ctx, query, rewardID).Scan(&remaining)
+	if err != nil {
+		return nil, err
+	}
+
+	return nullableInt(remaining), nil
+}
+
+func sortedIDs(ids []int) []int {
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+func nullableInt(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	i := int(n.Int64)
+	return &i
+}
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"projectx/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// ShippingEverywhere is the zone used for every country that doesn't have a
+// zone of its own.
+const ShippingEverywhere = "*"
+
+type RewardShipping struct {
+	Zone string  `json:"zone"`
+	Cost float64 `json:"cost"`
+}
+
+// Shipment is what shipping the selected rewards to the backer's address
+// costs, per reward and in total. Address is nil when nothing has to be shipped.
+type Shipment struct {
+	Address *ShippingAddress
+	Fees    map[int]float64
+	Total   float64
+}
+
+func ValidateRewardShipping(v *validator.Validator, shipping []RewardShipping, index int32) {
+	zones := make([]string, 0, len(shipping))
+	for _, s := range shipping {
+		zones = append(zones, s.Zone)
+		v.Check(s.Zone == ShippingEverywhere || validator.Matches(s.Zone, CountryCodeRX), "shipping", IfThenElseMessage(index == 0, "Shipping zone must be a two letter country code or *", fmt.Sprintf("Shipping zone of reward %d must be a two letter country code or *", index)))
+		v.Check(s.Cost >= 0, "shipping", IfThenElseMessage(index == 0, "Shipping cost cannot be negative", fmt.Sprintf("Shipping cost of reward %d cannot be negative", index)))
+	}
+	v.Check(validator.Unique(zones), "shipping", IfThenElseMessage(index == 0, "Shipping zones must be unique", fmt.Sprintf("Shipping zones of reward %d must be unique", index)))
+}
+
+// ShippingCost returns what shipping the reward to country costs. Rewards
+// without shipping zones don't need to be shipped.
+func (r *Reward) ShippingCost(country string) (float64, bool) {
+	if len(r.Shipping) == 0 {
+		return 0, true
+	}
+
+	everywhere, shipsEverywhere := 0.0, false
+	for _, s := range r.Shipping {
+		switch s.Zone {
+		case country:
+			return s.Cost, true
+		case ShippingEverywhere:
+			everywhere, shipsEverywhere = s.Cost, true
+		}
+	}
+
+	return everywhere, shipsEverywhere
+}
+
+func ValidateShipment(v *validator.Validator, rewards []*Reward, address *ShippingAddress) *Shipment {
+	shipment := &Shipment{Fees: map[int]float64{}}
+
+	for _, reward := range rewards {
+		if len(reward.Shipping) == 0 {
+			continue
+		}
+
+		if address == nil {
+			v.AddError("address_id", fmt.Sprintf("A shipping address is required for reward %d", reward.ID))
+			continue
+		}
+
+		cost, ok := reward.ShippingCost(address.Country)
+		if !ok {
+			v.AddError("address_id", fmt.Sprintf("Reward %d doesn't ship to %s", reward.ID, address.Country))
+			continue
+		}
+
+		shipment.Address = address
+		shipment.Fees[reward.ID] = cost
+		shipment.Total += cost
+	}
+
+	return shipment
+}
+
+// snapshot returns the address as it is stored on the pledged rewards, so that
+// later edits to the backer's address book don't change past pledges.
+func (s *Shipment) snapshot() (*string, *int, error) {
+	if s == nil || s.Address == nil {
+		return nil, nil, nil
+	}
+
+	js, err := json.Marshal(s.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+	address := string(js)
+
+	return &address, &s.Address.ID, nil
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func loadRewardShipping(ctx context.Context, q querier, rewardIDs []int) (map[int][]RewardShipping, error) {
+	query := `SELECT reward_id, zone, cost FROM reward_shipping WHERE reward_id = ANY($1) ORDER BY reward_id, zone`
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(rewardIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shipping := map[int][]RewardShipping{}
+
+	for rows.Next() {
+		var rewardID int
+		var s RewardShipping
+
+		err := rows.Scan(&rewardID, &s.Zone, &s.Cost)
+		if err != nil {
+			return nil, err
+		}
+
+		shipping[rewardID] = append(shipping[rewardID], s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return shipping, nil
+}
+
+func replaceRewardShipping(ctx context.Context, tx *sql.Tx, rewardID int, shipping []RewardShipping) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM reward_shipping WHERE reward_id = $1`, rewardID)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range shipping {
+		_, err = tx.ExecContext(ctx, `INSERT INTO reward_shipping (reward_id, zone, cost) VALUES ($1, $2, $3)`, rewardID, s.Zone, s.Cost)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,356 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"projectx/internal/validator"
+	"slices"
+	"time"
+)
+
+const (
+	MatchEntry    = "match"
+	MatchReversal = "reversal"
+)
+
+// Sponsorship matches backer pledges 1:1 until Cap is reached. It targets a
+// single project or every project of a category. Cap and Matched are in DA.
+type Sponsorship struct {
+	ID          int        `json:"id"`
+	SponsorName string     `json:"sponsor_name"`
+	ProjectID   *int       `json:"project_id"`
+	Category    *string    `json:"category"`
+	Cap         float64    `json:"cap"`
+	Matched     float64    `json:"matched"`
+	IsActive    bool       `json:"is_active"`
+	EndsAt      *time.Time `json:"ends_at"`
+	CreatedBy   int        `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Version     int        `json:"version"`
+}
+
+// ProjectSponsor is what a sponsor matched on a single project.
+type ProjectSponsor struct {
+	SponsorName string  `json:"sponsor_name"`
+	Matched     float64 `json:"matched"`
+}
+
+func ValidateSponsorship(v *validator.Validator, sponsorship *Sponsorship) {
+	v.Check(sponsorship.SponsorName != "", "sponsor_name", "Sponsor name must be provided")
+	v.Check(validator.MaxChars(sponsorship.SponsorName, 100), "sponsor_name", "Sponsor name cannot be more than 100 characters")
+	v.Check((sponsorship.ProjectID == nil) != (sponsorship.Category == nil), "target", "Either a project or a category must be targeted")
+	if sponsorship.Category != nil {
+		v.Check(slices.Contains(SupportedCategories, *sponsorship.Category), "category", "Category is not supported")
+	}
+	v.Check(sponsorship.Cap >= minimumPledge/100, "cap", "Cap must be at least the minimum pledge")
+	v.Check(sponsorship.Cap >= sponsorship.Matched, "cap", "Cap cannot be less than what was already matched")
+}
+
+type SponsorshipModel struct {
+	DB *sql.DB
+}
+
+const sponsorshipColumns = `sponsorship_id, sponsor_name, project_id, category, cap::DECIMAL/100, matched::DECIMAL/100, is_active, ends_at,
+	COALESCE(created_by, 0), created_at, version`
+
+func scanSponsorship(row rowScanner) (*Sponsorship, error) {
+	var sponsorship Sponsorship
+
+	err := row.Scan(
+		&sponsorship.ID,
+		&sponsorship.SponsorName,
+		&sponsorship.ProjectID,
+		&sponsorship.Category,
+		&sponsorship.Cap,
+		&sponsorship.Matched,
+		&sponsorship.IsActive,
+		&sponsorship.EndsAt,
+		&sponsorship.CreatedBy,
+		&sponsorship.CreatedAt,
+		&sponsorship.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sponsorship, nil
+}
+
+func (m SponsorshipModel) Insert(sponsorship *Sponsorship) error {
+	query := `INSERT INTO sponsorship (sponsor_name, project_id, category, cap, ends_at, created_by)
+	VALUES ($1, $2, $3, ROUND($4::DECIMAL * 100), $5, $6)
+	RETURNING sponsorship_id, is_active, created_at, version`
+
+	args := []interface{}{
+		sponsorship.SponsorName,
+		sponsorship.ProjectID,
+		sponsorship.Category,
+		sponsorship.Cap,
+		sponsorship.EndsAt,
+		sponsorship.CreatedBy,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&sponsorship.ID, &sponsorship.IsActive, &sponsorship.CreatedAt, &sponsorship.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "sponsorship" violates foreign key constraint "sponsorship_project_id_fkey"`:
+			return ErrNoRecordFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m SponsorshipModel) Get(id int) (*Sponsorship, error) {
+	query := `SELECT ` + sponsorshipColumns + ` FROM sponsorship WHERE sponsorship_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	sponsorship, err := scanSponsorship(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	return sponsorship, nil
+}
+
+func (m SponsorshipModel) GetAll() ([]*Sponsorship, error) {
+	query := `SELECT ` + sponsorshipColumns + ` FROM sponsorship ORDER BY sponsorship_id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sponsorships := []*Sponsorship{}
+
+	for rows.Next() {
+		sponsorship, err := scanSponsorship(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		sponsorships = append(sponsorships, sponsorship)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sponsorships, nil
+}
+
+// Update saves the sponsor's name, cap, end date and whether it still
+// matches new pledges. What it targets can't change once created.
+func (m SponsorshipModel) Update(sponsorship *Sponsorship) error {
+	query := `UPDATE sponsorship SET sponsor_name = $1, cap = ROUND($2::DECIMAL * 100), is_active = $3, ends_at = $4, version = version + 1
+	WHERE sponsorship_id = $5 AND version = $6 AND matched <= ROUND($2::DECIMAL * 100)
+	RETURNING matched::DECIMAL/100, version`
+
+	args := []interface{}{
+		sponsorship.SponsorName,
+		sponsorship.Cap,
+		sponsorship.IsActive,
+		sponsorship.EndsAt,
+		sponsorship.ID,
+		sponsorship.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&sponsorship.Matched, &sponsorship.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetForProject returns the sponsors that matched pledges of the project.
+func (m SponsorshipModel) GetForProject(projectID int) ([]*ProjectSponsor, error) {
+	query := `SELECT s.sponsor_name, SUM(CASE sm.entry_type WHEN 'match' THEN sm.amount ELSE -sm.amount END)::DECIMAL/100
+	FROM sponsor_match sm
+	INNER JOIN sponsorship s ON s.sponsorship_id = sm.sponsorship_id
+	WHERE sm.project_id = $1
+	GROUP BY s.sponsorship_id, s.sponsor_name
+	HAVING SUM(CASE sm.entry_type WHEN 'match' THEN sm.amount ELSE -sm.amount END) > 0
+	ORDER BY 2 DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sponsors := []*ProjectSponsor{}
+
+	for rows.Next() {
+		var sponsor ProjectSponsor
+
+		if err := rows.Scan(&sponsor.SponsorName, &sponsor.Matched); err != nil {
+			return nil, err
+		}
+
+		sponsors = append(sponsors, &sponsor)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sponsors, nil
+}
+
+func insertMatchEntry(ctx context.Context, tx *sql.Tx, sponsorshipID, projectID, paymentID int, entryType string, amount int64) error {
+	query := `INSERT INTO sponsor_match (sponsorship_id, project_id, payment_id, entry_type, amount) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := tx.ExecContext(ctx, query, sponsorshipID, projectID, paymentID, entryType, amount)
+	return err
+}
+
+// postMatch matches a pledge of a live project with the sponsorships
+// targeting it, the ones for the project itself first. A pledge is matched
+// once at most, however many sponsors there are. The sponsorships are locked
+// so their caps can't be overrun by concurrent pledges.
+func postMatch(ctx context.Context, tx *sql.Tx, projectID, paymentID int, amount int64) error {
+	query := `SELECT s.sponsorship_id, s.cap - s.matched
+	FROM sponsorship s
+	INNER JOIN project p ON p.project_id = $1
+	WHERE p.status = 'Live' AND s.is_active AND (s.ends_at IS NULL OR s.ends_at > NOW()) AND s.matched < s.cap
+		AND (s.project_id = p.project_id OR s.category = ANY(p.categories))
+	ORDER BY s.project_id IS NULL, s.sponsorship_id
+	FOR UPDATE OF s`
+
+	rows, err := tx.QueryContext(ctx, query, projectID)
+	if err != nil {
+		return err
+	}
+
+	type available struct {
+		sponsorshipID int
+		left          int64
+	}
+
+	var sponsorships []available
+
+	for rows.Next() {
+		var a available
+		if err := rows.Scan(&a.sponsorshipID, &a.left); err != nil {
+			rows.Close()
+			return err
+		}
+		sponsorships = append(sponsorships, a)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, sponsorship := range sponsorships {
+		if amount <= 0 {
+			break
+		}
+
+		matched := min(amount, sponsorship.left)
+
+		_, err = tx.ExecContext(ctx, `UPDATE sponsorship SET matched = matched + $1 WHERE sponsorship_id = $2`, matched, sponsorship.sponsorshipID)
+		if err != nil {
+			return err
+		}
+
+		err = insertMatchEntry(ctx, tx, sponsorship.sponsorshipID, projectID, paymentID, MatchEntry, matched)
+		if err != nil {
+			return err
+		}
+
+		amount -= matched
+	}
+
+	return nil
+}
+
+// reverseMatches gives back to the sponsors whatever they matched of a
+// payment beyond what is still pledged, the latest sponsorships first.
+func reverseMatches(ctx context.Context, tx *sql.Tx, paymentID int, pledged int64) error {
+	query := `SELECT sponsorship_id, project_id, SUM(CASE entry_type WHEN 'match' THEN amount ELSE -amount END)
+	FROM sponsor_match
+	WHERE payment_id = $1
+	GROUP BY sponsorship_id, project_id
+	HAVING SUM(CASE entry_type WHEN 'match' THEN amount ELSE -amount END) > 0
+	ORDER BY sponsorship_id DESC`
+
+	rows, err := tx.QueryContext(ctx, query, paymentID)
+	if err != nil {
+		return err
+	}
+
+	type match struct {
+		sponsorshipID int
+		projectID     int
+		amount        int64
+	}
+
+	var matches []match
+	var total int64
+
+	for rows.Next() {
+		var m match
+		if err := rows.Scan(&m.sponsorshipID, &m.projectID, &m.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		matches = append(matches, m)
+		total += m.amount
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	excess := total - max(pledged, 0)
+
+	for _, m := range matches {
+		if excess <= 0 {
+			break
+		}
+
+		reversed := min(excess, m.amount)
+
+		_, err = tx.ExecContext(ctx, `UPDATE sponsorship SET matched = matched - $1 WHERE sponsorship_id = $2`, reversed, m.sponsorshipID)
+		if err != nil {
+			return err
+		}
+
+		err = insertMatchEntry(ctx, tx, m.sponsorshipID, m.projectID, paymentID, MatchReversal, reversed)
+		if err != nil {
+			return err
+		}
+
+		excess -= reversed
+	}
+
+	return nil
+}
@@ -9,15 +9,21 @@ import (
 	"github.com/lib/pq"
 )
 
+// ledgerFunding adds up, in minor units, what the funding ledger entries l
+// moved into projects: pledges and captures in, refunds out.
+const ledgerFunding = `SUM(CASE WHEN l.entry_type IN ('pledge', 'capture') AND l.debit_account = 'project' THEN l.amount WHEN l.entry_type = 'refund' AND l.credit_account = 'project' THEN -l.amount ELSE 0 END)`
+
 type Stats struct {
 	TotalProjects      int     `json:"total_projects"`
 	TotalMoneyRaised   float64 `json:"total_money_raised"`
+	TotalLateRaised    float64 `json:"total_late_raised"`
 	SuccessfulProjects int     `json:"successful_projects"`
 	FailedProjects     int     `json:"failed_projects"`
 	TotalBackers       int     `json:"total_backers"`
 	TotalCreators      int     `json:"total_creators"`
 	TotalBackings      int     `json:"total_backings"`
 	TotalRefunds       int     `json:"total_refunds"`
+	TotalMatched       float64 `json:"total_matched"`
 }
 
 type Overview struct {
@@ -85,8 +91,11 @@ type ReviewerStats struct {
 type UserStats struct {
 	TotalProjects  int     `json:"total_projects"`
 	TotalRaised    float64 `json:"total_raised"`
+	LateRaised     float64 `json:"late_raised"`
 	ProjectsBacked int     `json:"projects_backed"`
 	TotalBacked    float64 `json:"total_backed"`
+	// MembershipRevenue is kept apart from campaign funding
+	MembershipRevenue float64 `json:"membership_revenue"`
 }
 
 type ProjectsStatistics struct {
@@ -124,13 +133,19 @@ func (m StatsModel) GetTotalProjectsCount(stats *Stats) error {
 }
 
 func (m StatsModel) GetTotalMoneyRaised(stats *Stats) error {
-	query := `SELECT SUM(amount)/100 FROM payment WHERE status = 'succeeded'`
+	// late pledges are counted apart from what campaigns raised
+	query := `SELECT
+		` + ledgerFunding + ` FILTER (WHERE NOT COALESCE(b.is_late, FALSE))::DECIMAL/100,
+		` + ledgerFunding + ` FILTER (WHERE b.is_late)::DECIMAL/100
+	FROM funding_ledger l
+	LEFT JOIN payment pa ON pa.payment_id = l.payment_id
+	LEFT JOIN backing b ON b.backing_id = pa.backing_id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var count sql.NullFloat64
-	err := m.DB.QueryRowContext(ctx, query).Scan(&count)
+	var count, late sql.NullFloat64
+	err := m.DB.QueryRowContext(ctx, query).Scan(&count, &late)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -140,12 +155,13 @@ func (m StatsModel) GetTotalMoneyRaised(stats *Stats) error {
 	}
 
 	stats.TotalMoneyRaised = count.Float64
+	stats.TotalLateRaised = late.Float64
 
 	return nil
 }
 
 func (m StatsModel) GetTotalSuccessfulProjectsCount(stats *Stats) error {
-	query := `SELECT COUNT(*) OVER() FROM project WHERE status='Completed' AND current_funding >= funding_goal`
+	query := `SELECT COUNT(*) OVER() FROM project WHERE status='Completed' AND project_funding(project_id) >= funding_goal`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -165,7 +181,7 @@ func (m StatsModel) GetTotalSuccessfulProjectsCount(stats *Stats) error {
 }
 
 func (m StatsModel) GetTotalFailedProjectsCount(stats *Stats) error {
-	query := `SELECT COUNT(*) OVER() FROM project WHERE status='Completed' AND current_funding < funding_goal`
+	query := `SELECT COUNT(*) OVER() FROM project WHERE status='Completed' AND project_funding(project_id) < funding_goal`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -206,11 +222,11 @@ func (m StatsModel) GetTotalCreators(stats *Stats) error {
 	return nil
 }
 func (m StatsModel) GetTotalBackers(stats *Stats) error {
-	query := `SELECT DISTINCT COUNT(*) OVER()
+	query := `SELECT COUNT(DISTINCT b.backing_id)
 	FROM backing b
 	INNER JOIN payment pa
 	ON b.backing_id = pa.backing_id
-	WHERE pa.status = 'succeeded'`
+	WHERE pa.status IN ('succeeded', 'partially_refunded')`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -270,14 +286,35 @@ func (m StatsModel) GetTotalRefunds(stats *Stats) error {
 	return nil
 }
 
+func (m StatsModel) GetTotalMatched(stats *Stats) error {
+	query := `SELECT SUM(CASE entry_type WHEN 'match' THEN amount ELSE -amount END)::DECIMAL/100 FROM sponsor_match`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var total sql.NullFloat64
+	err := m.DB.QueryRowContext(ctx, query).Scan(&total)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil
+		}
+		return err
+	}
+
+	stats.TotalMatched = total.Float64
+
+	return nil
+}
+
 func (m StatsModel) GetProjectsOverview() ([]*Overview, error) {
 	query := `
 	WITH project_months AS (
 		SELECT
 			to_char(deadline, 'Month') AS project_month,
 			CASE
-				WHEN current_funding >= funding_goal THEN 'Successful'
-				WHEN current_funding < funding_goal THEN 'Failed'
+				WHEN project_funding(project_id) >= funding_goal THEN 'Successful'
+				WHEN project_funding(project_id) < funding_goal THEN 'Failed'
 				ELSE 'Other'
 			END AS project_status
 		FROM project
@@ -335,16 +372,13 @@ func (m StatsModel) GetProjectsOverview() ([]*Overview, error) {
 
 func (m StatsModel) GetTopFiveProjects() ([]*TopProject, error) {
 	query := `
-	SELECT pr.title, u.username AS creator, sum(pa.amount)/100 AS total_raised
-	FROM backing b
-	INNER JOIN payment pa
-	ON pa.backing_id = b.backing_id
+	SELECT pr.title, u.username AS creator, ` + ledgerFunding + `::DECIMAL/100 AS total_raised
+	FROM funding_ledger l
 	INNER JOIN project pr
-	ON b.project_id = pr.project_id
+	ON l.project_id = pr.project_id
 	INNER JOIN user_t u
 	ON pr.creator_id = u.user_id
-	WHERE pa.status = 'succeeded'
-	GROUP BY creator, pr.title
+	GROUP BY creator, pr.project_id, pr.title
 	ORDER BY total_raised DESC
 	LIMIT 5;
 	`
@@ -378,12 +412,10 @@ func (m StatsModel) GetTopFiveProjects() ([]*TopProject, error) {
 
 func (m StatsModel) GetTopFiveCreators() ([]*TopUser, error) {
 	query := `
-	SELECT u.username, u.image_url, COUNT(pr.project_id) AS project_count, COALESCE(SUM(pa.amount)/100, 0) AS total_raised
+	SELECT u.username, u.image_url, COUNT(DISTINCT pr.project_id) AS project_count, COALESCE(` + ledgerFunding + `::DECIMAL/100, 0) AS total_raised
 	FROM project pr
-	LEFT JOIN backing b ON b.project_id = pr.project_id
-	LEFT JOIN payment pa ON b.backing_id = pa.backing_id
+	LEFT JOIN funding_ledger l ON l.project_id = pr.project_id
 	INNER JOIN user_t u ON pr.creator_id = u.user_id
-	WHERE pa.status = 'succeeded'
 	GROUP BY u.username, u.image_url
 	ORDER BY project_count DESC, total_raised DESC
 	LIMIT 5;
@@ -419,12 +451,11 @@ func (m StatsModel) GetTopFiveCreators() ([]*TopUser, error) {
 
 func (m StatsModel) GetTopFiveBackers() ([]*TopUser, error) {
 	query := `
-	SELECT u.username, u.image_url, COUNT(pr.project_id) AS project_count, SUM(pa.amount)/100 AS total_raised
-	FROM backing b
-	INNER JOIN payment pa ON b.backing_id = pa.backing_id
-	INNER JOIN project pr ON b.project_id = pr.project_id
+	SELECT u.username, u.image_url, COUNT(DISTINCT b.project_id) AS project_count, ` + ledgerFunding + `::DECIMAL/100 AS total_raised
+	FROM funding_ledger l
+	INNER JOIN payment pa ON pa.payment_id = l.payment_id
+	INNER JOIN backing b ON b.backing_id = pa.backing_id
 	INNER JOIN user_t u ON b.backer_id = u.user_id
-	WHERE pa.status = 'succeeded'
 	GROUP BY u.username, u.image_url
 	ORDER BY project_count, total_raised DESC
 	LIMIT 5;
@@ -632,11 +663,11 @@ func (m StatsModel) BackingsVSRefunds() ([]*BackingVSRefund, error) {
 }
 
 func (m StatsModel) CreatedBackedProjectsCount(userId int) (*ProfileStats, error) {
-	backedQuery := `SELECT COUNT(b.project_id) AS backed_projects
+	backedQuery := `SELECT COUNT(DISTINCT b.project_id) AS backed_projects
 	FROM project pr 
 	INNER JOIN backing b ON pr.project_id = b.project_id 
 	INNER JOIN payment pa ON pa.backing_id = b.backing_id 
-	WHERE pa.status = 'succeeded' AND b.backer_id = $1`
+	WHERE pa.status IN ('succeeded', 'partially_refunded') AND b.backer_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -856,13 +887,13 @@ func (m StatsModel) GetTotalCreatedProjectsCount(stats *UserStats, creatorID int
 }
 
 func (m StatsModel) GetTotalRaised(stats *UserStats, creatorID int) error {
-	query := `SELECT SUM(current_funding) FROM project WHERE creator_id = $1`
+	query := `SELECT SUM(project_funding(project_id)), SUM(project_late_funding(project_id)) FROM project WHERE creator_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var count sql.NullFloat64
-	err := m.DB.QueryRowContext(ctx, query, creatorID).Scan(&count)
+	var count, late sql.NullFloat64
+	err := m.DB.QueryRowContext(ctx, query, creatorID).Scan(&count, &late)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -872,12 +903,13 @@ func (m StatsModel) GetTotalRaised(stats *UserStats, creatorID int) error {
 	}
 
 	stats.TotalRaised = count.Float64
+	stats.LateRaised = late.Float64
 
 	return nil
 }
 
 func (m StatsModel) GetProjectsBacked(stats *UserStats, backerID int) error {
-	query := `SELECT COUNT(*) FROM backing b INNER JOIN payment pa ON b.backing_id = pa.backing_id WHERE pa.status = 'succeeded' AND b.backer_id = $1`
+	query := `SELECT COUNT(DISTINCT b.backing_id) FROM backing b INNER JOIN payment pa ON b.backing_id = pa.backing_id WHERE pa.status IN ('succeeded', 'partially_refunded') AND b.backer_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -898,7 +930,7 @@ func (m StatsModel) GetProjectsBacked(stats *UserStats, backerID int) error {
 }
 
 func (m StatsModel) GetTotalBacks(stats *UserStats, backerID int) error {
-	query := `SELECT SUM(pa.amount)/100 FROM backing b INNER JOIN payment pa ON b.backing_id = pa.backing_id WHERE pa.status = 'succeeded' AND b.backer_id = $1`
+	query := `SELECT ` + ledgerFunding + `::DECIMAL/100 FROM funding_ledger l INNER JOIN payment pa ON pa.payment_id = l.payment_id INNER JOIN backing b ON b.backing_id = pa.backing_id WHERE b.backer_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -918,14 +950,35 @@ func (m StatsModel) GetTotalBacks(stats *UserStats, backerID int) error {
 	return nil
 }
 
+func (m StatsModel) GetMembershipRevenue(stats *UserStats, creatorID int) error {
+	query := `SELECT SUM(mp.amount)::DECIMAL/100 FROM membership_payment mp INNER JOIN membership m ON m.membership_id = mp.membership_id INNER JOIN membership_tier t ON t.tier_id = m.tier_id WHERE t.creator_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var revenue sql.NullFloat64
+	err := m.DB.QueryRowContext(ctx, query, creatorID).Scan(&revenue)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil
+		}
+		return err
+	}
+
+	stats.MembershipRevenue = revenue.Float64
+
+	return nil
+}
+
 func (m StatsModel) GetFundingProgress(creatorID int) ([]*Overview, error) {
 	query := `
 	WITH project_months AS (
 		SELECT
 			to_char(deadline, 'Month') AS project_month,
 			CASE
-				WHEN current_funding >= funding_goal THEN 'Successful'
-				WHEN current_funding < funding_goal THEN 'Failed'
+				WHEN project_funding(project_id) >= funding_goal THEN 'Successful'
+				WHEN project_funding(project_id) < funding_goal THEN 'Failed'
 				ELSE 'Other'
 			END AS project_status
 		FROM project
@@ -984,7 +1037,7 @@ func (m StatsModel) GetFundingProgress(creatorID int) ([]*Overview, error) {
 
 func (m TablesModel) GetLiveProjectsStatistics(creatorID int) ([]*ProjectsStatistics, error) {
 	query := `
-	SELECT pr.title, pr.categories, pr.funding_goal, pr.current_funding, pr.deadline, count(DISTINCT b.backer_id) as backers
+	SELECT pr.title, pr.categories, pr.funding_goal, project_funding(pr.project_id) AS current_funding, pr.deadline, count(DISTINCT b.backer_id) as backers
 	FROM project pr 
 	LEFT JOIN backing b on pr.project_id = b.project_id
 	WHERE pr.status = 'Live' AND pr.creator_id = $1
@@ -1028,7 +1081,7 @@ func (m TablesModel) GetLiveProjectsStatistics(creatorID int) ([]*ProjectsStatis
 
 func (m TablesModel) GetBackedProjectsStatistics(backerID int) ([]*ProjectsStatistics, error) {
 	query := `
-	SELECT pr.title, pr.categories, pr.funding_goal, pr.current_funding, pr.deadline, pr.status, count(DISTINCT b.backer_id) as backers
+	SELECT pr.title, pr.categories, pr.funding_goal, project_funding(pr.project_id) AS current_funding, pr.deadline, pr.status, count(DISTINCT b.backer_id) as backers
 	FROM project pr 
 	LEFT JOIN backing b on pr.project_id = b.project_id
 	WHERE b.backer_id = $1
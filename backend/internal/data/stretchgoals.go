@@ -0,0 +1,182 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"projectx/internal/validator"
+	"time"
+)
+
+// StretchGoal is an extra funding threshold a creator promises something
+// for. It is unlocked once the campaign funding reaches Amount.
+type StretchGoal struct {
+	ID          int        `json:"id"`
+	ProjectID   int        `json:"project_id"`
+	Amount      float64    `json:"amount"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	UnlockedAt  *time.Time `json:"unlocked_at"`
+	CreatedAt   time.Time  `json:"-"`
+	Version     int32      `json:"version"`
+}
+
+func ValidateStretchGoals(v *validator.Validator, project *Project, goals []*StretchGoal, unlocked []*StretchGoal) {
+	amounts := make([]float64, 0, len(goals)+len(unlocked))
+	for _, goal := range unlocked {
+		amounts = append(amounts, goal.Amount)
+	}
+
+	for i, goal := range goals {
+		v.Check(goal.Title != "", "stretch_goals", fmt.Sprintf("Title of stretch goal %d must be provided", i+1))
+		v.Check(validator.MaxChars(goal.Title, 70), "stretch_goals", fmt.Sprintf("Title of stretch goal %d must not be more than 70 characters long", i+1))
+		v.Check(validator.MaxChars(goal.Description, 1000), "stretch_goals", fmt.Sprintf("Description of stretch goal %d must not be more than 1000 characters long", i+1))
+		v.Check(goal.Amount > project.FundingGoal, "stretch_goals", fmt.Sprintf("Amount of stretch goal %d must be more than the funding goal", i+1))
+		amounts = append(amounts, goal.Amount)
+	}
+
+	v.Check(validator.Unique(amounts), "stretch_goals", "Two stretch goals cannot have the same amount")
+}
+
+type StretchGoalModel struct {
+	DB *sql.DB
+}
+
+const stretchGoalColumns = `stretch_goal_id, project_id, amount, title, description, unlocked_at, created_at, version`
+
+func scanStretchGoal(row rowScanner) (*StretchGoal, error) {
+	var goal StretchGoal
+
+	err := row.Scan(&goal.ID, &goal.ProjectID, &goal.Amount, &goal.Title, &goal.Description, &goal.UnlockedAt, &goal.CreatedAt, &goal.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &goal, nil
+}
+
+func scanStretchGoals(rows *sql.Rows) ([]*StretchGoal, error) {
+	defer rows.Close()
+
+	goals := []*StretchGoal{}
+
+	for rows.Next() {
+		goal, err := scanStretchGoal(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		goals = append(goals, goal)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return goals, nil
+}
+
+func (m StretchGoalModel) GetAll(projectID int) ([]*StretchGoal, error) {
+	query := `SELECT ` + stretchGoalColumns + ` FROM stretch_goal WHERE project_id = $1 ORDER BY amount`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanStretchGoals(rows)
+}
+
+// ReplaceLocked swaps the goals of the project that aren't unlocked yet for
+// goals. Unlocked goals stay as they are.
+func (m StretchGoalModel) ReplaceLocked(projectID int, goals []*StretchGoal) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM stretch_goal WHERE project_id = $1 AND unlocked_at IS NULL`, projectID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO stretch_goal (project_id, amount, title, description)
+	VALUES ($1, $2, $3, $4)
+	RETURNING stretch_goal_id, created_at, version`
+
+	for _, goal := range goals {
+		goal.ProjectID = projectID
+
+		err = tx.QueryRowContext(ctx, query, projectID, goal.Amount, goal.Title, goal.Description).Scan(&goal.ID, &goal.CreatedAt, &goal.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "stretch_goal_project_id_amount_key"`:
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Unlock marks the goals the project's funding has reached as unlocked and
+// returns them. A goal is only ever returned once.
+func (m StretchGoalModel) Unlock(projectID int) ([]*StretchGoal, error) {
+	query := `UPDATE stretch_goal SET unlocked_at = NOW(), version = version + 1
+	WHERE project_id = $1 AND unlocked_at IS NULL AND amount <= project_funding($1)
+	RETURNING ` + stretchGoalColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanStretchGoals(rows)
+}
+
+// GetBackerEmails returns the email of everyone whose pledge to the project
+// still holds money.
+func (m BackingModel) GetBackerEmails(projectID int) ([]string, error) {
+	query := `SELECT DISTINCT u.email
+	FROM backing b
+	INNER JOIN user_t u ON u.user_id = b.backer_id
+	INNER JOIN payment pa ON pa.backing_id = b.backing_id
+	WHERE b.project_id = $1 AND pa.status IN ('succeeded', 'partially_refunded', 'requires_capture')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []string{}
+
+	for rows.Next() {
+		var email string
+
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
@@ -0,0 +1,586 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"projectx/internal/validator"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	SurveyQuestionText    = "text"
+	SurveyQuestionChoice  = "choice"
+	SurveyQuestionAddress = "address"
+)
+
+var ErrSurveySent = errors.New("the survey has already been sent to backers")
+
+// Survey collects what creators need from their backers to fulfill rewards.
+// A question with a RewardID is only asked to the backers of that reward.
+type Survey struct {
+	ID          int               `json:"id"`
+	ProjectID   int               `json:"project_id"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	SentAt      *time.Time        `json:"sent_at"`
+	Questions   []*SurveyQuestion `json:"questions"`
+	Recipients  int               `json:"recipients"`
+	Completed   int               `json:"completed"`
+	Version     int32             `json:"version"`
+}
+
+type SurveyQuestion struct {
+	ID       int      `json:"id"`
+	RewardID *int     `json:"reward_id"`
+	Type     string   `json:"type"`
+	Prompt   string   `json:"prompt"`
+	Choices  []string `json:"choices"`
+	Required bool     `json:"required"`
+}
+
+// SurveyAnswer answers one question. Address questions are answered with one
+// of the backer's addresses, which is copied into Address.
+type SurveyAnswer struct {
+	QuestionID int             `json:"question_id"`
+	Answer     string          `json:"answer"`
+	AddressID  int             `json:"address_id,omitempty"`
+	Address    json.RawMessage `json:"address,omitempty"`
+}
+
+type SurveyResponse struct {
+	BackingID   int             `json:"backing_id"`
+	BackerEmail string          `json:"backer_email"`
+	SentAt      time.Time       `json:"sent_at"`
+	CompletedAt *time.Time      `json:"completed_at"`
+	Answers     []*SurveyAnswer `json:"answers"`
+}
+
+type SurveyRecipient struct {
+	SurveyID      int
+	BackingID     int
+	ProjectTitle  string
+	SurveyTitle   string
+	BackerEmail   string
+	ReminderCount int
+}
+
+func ValidateSurvey(v *validator.Validator, survey *Survey, rewardIDs []int) {
+	v.Check(survey.Title != "", "title", "Title must be provided")
+	v.Check(validator.MaxChars(survey.Title, 100), "title", "Title cannot be more than 100 characters")
+	v.Check(validator.MaxChars(survey.Description, 1000), "description", "Description cannot be more than 1000 characters")
+	v.Check(len(survey.Questions) > 0, "questions", "At least one question must be provided")
+	v.Check(len(survey.Questions) <= 50, "questions", "A survey cannot have more than 50 questions")
+
+	for i, question := range survey.Questions {
+		v.Check(validator.In(question.Type, SurveyQuestionText, SurveyQuestionChoice, SurveyQuestionAddress), "questions", fmt.Sprintf("Type of question %d must be text, choice or address", i+1))
+		v.Check(question.Prompt != "", "questions", fmt.Sprintf("Prompt of question %d must be provided", i+1))
+		v.Check(validator.MaxChars(question.Prompt, 300), "questions", fmt.Sprintf("Prompt of question %d cannot be more than 300 characters", i+1))
+
+		if question.Type == SurveyQuestionChoice {
+			v.Check(len(question.Choices) >= 2, "questions", fmt.Sprintf("Question %d must have at least two choices", i+1))
+			v.Check(validator.Unique(question.Choices), "questions", fmt.Sprintf("Choices of question %d must be unique", i+1))
+		} else {
+			v.Check(len(question.Choices) == 0, "questions", fmt.Sprintf("Only choice questions can have choices, not question %d", i+1))
+		}
+
+		if question.RewardID != nil {
+			v.Check(slices.Contains(rewardIDs, *question.RewardID), "questions", fmt.Sprintf("Reward of question %d doesn't belong to this project", i+1))
+		}
+	}
+}
+
+// ValidateSurveyAnswers checks every required question is answered and that
+// the answers fit their question.
+func ValidateSurveyAnswers(v *validator.Validator, questions []*SurveyQuestion, answers []*SurveyAnswer) {
+	byQuestion := make(map[int]*SurveyAnswer, len(answers))
+	for _, answer := range answers {
+		if _, ok := byQuestion[answer.QuestionID]; ok {
+			v.AddError("answers", fmt.Sprintf("Question %d is answered more than once", answer.QuestionID))
+		}
+		byQuestion[answer.QuestionID] = answer
+	}
+
+	asked := make(map[int]bool, len(questions))
+	for _, question := range questions {
+		asked[question.ID] = true
+
+		answer, ok := byQuestion[question.ID]
+		answered := ok && (answer.Answer != "" || answer.AddressID != 0)
+		if !answered {
+			v.Check(!question.Required, "answers", fmt.Sprintf("Question %d must be answered", question.ID))
+			continue
+		}
+
+		switch question.Type {
+		case SurveyQuestionText:
+			v.Check(validator.MaxChars(answer.Answer, 1000), "answers", fmt.Sprintf("Answer to question %d cannot be more than 1000 characters", question.ID))
+		case SurveyQuestionChoice:
+			v.Check(validator.In(answer.Answer, question.Choices...), "answers", fmt.Sprintf("Answer to question %d must be one of its choices", question.ID))
+		case SurveyQuestionAddress:
+			v.Check(answer.AddressID != 0, "answers", fmt.Sprintf("Question %d must be answered with one of your addresses", question.ID))
+		}
+	}
+
+	for questionID := range byQuestion {
+		v.Check(asked[questionID], "answers", fmt.Sprintf("Question %d is not part of your survey", questionID))
+	}
+}
+
+type SurveyModel struct {
+	DB *sql.DB
+}
+
+// Upsert creates or replaces the survey of a project with its questions. A
+// survey can't be changed anymore once it was sent.
+func (m SurveyModel) Upsert(survey *Survey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO survey (project_id, title, description)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (project_id) DO UPDATE
+	SET title = EXCLUDED.title, description = EXCLUDED.description, version = survey.version + 1
+	WHERE survey.sent_at IS NULL
+	RETURNING survey_id, version`
+
+	err = tx.QueryRowContext(ctx, query, survey.ProjectID, survey.Title, survey.Description).Scan(&survey.ID, &survey.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrSurveySent
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM survey_question WHERE survey_id = $1`, survey.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO survey_question (survey_id, reward_id, type, prompt, choices, required, position)
+	VALUES ($1, $2, $3, $4, COALESCE($5::text[], '{}'), $6, $7)
+	RETURNING question_id`
+
+	for i, question := range survey.Questions {
+		args := []interface{}{
+			survey.ID,
+			question.RewardID,
+			question.Type,
+			question.Prompt,
+			pq.Array(question.Choices),
+			question.Required,
+			i,
+		}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&question.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m SurveyModel) GetByProject(projectID int) (*Survey, error) {
+	query := `SELECT s.survey_id, s.project_id, s.title, s.description, s.sent_at, s.version,
+		(SELECT COUNT(*) FROM survey_recipient sr WHERE sr.survey_id = s.survey_id),
+		(SELECT COUNT(*) FROM survey_recipient sr WHERE sr.survey_id = s.survey_id AND sr.completed_at IS NOT NULL)
+	FROM survey s
+	WHERE s.project_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var survey Survey
+
+	err := m.DB.QueryRowContext(ctx, query, projectID).Scan(
+		&survey.ID,
+		&survey.ProjectID,
+		&survey.Title,
+		&survey.Description,
+		&survey.SentAt,
+		&survey.Version,
+		&survey.Recipients,
+		&survey.Completed,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	survey.Questions, err = m.getQuestions(ctx, survey.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &survey, nil
+}
+
+// getQuestions returns the questions of the survey, only the ones asked to
+// the backing when backingID isn't 0.
+func (m SurveyModel) getQuestions(ctx context.Context, surveyID, backingID int) ([]*SurveyQuestion, error) {
+	query := `SELECT q.question_id, q.reward_id, q.type, q.prompt, q.choices, q.required
+	FROM survey_question q
+	WHERE q.survey_id = $1
+	AND ($2 = 0 OR q.reward_id IS NULL OR EXISTS (SELECT 1 FROM backing_reward br WHERE br.backing_id = $2 AND br.reward_id = q.reward_id))
+	ORDER BY q.position`
+
+	rows, err := m.DB.QueryContext(ctx, query, surveyID, backingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := []*SurveyQuestion{}
+
+	for rows.Next() {
+		var question SurveyQuestion
+		var choices pq.StringArray
+
+		err := rows.Scan(&question.ID, &question.RewardID, &question.Type, &question.Prompt, &choices, &question.Required)
+		if err != nil {
+			return nil, err
+		}
+		question.Choices = choices
+
+		questions = append(questions, &question)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return questions, nil
+}
+
+// Send adds the backers the survey asks something to as recipients and
+// returns the ones that weren't already. It can be called again to reach
+// backers who pledged after the first send.
+func (m SurveyModel) Send(surveyID int) ([]*SurveyRecipient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var projectID int
+	var projectTitle, surveyTitle string
+
+	query := `UPDATE survey s SET sent_at = COALESCE(s.sent_at, NOW())
+	FROM project p
+	WHERE s.survey_id = $1 AND p.project_id = s.project_id
+	RETURNING p.project_id, p.title, s.title`
+
+	err = tx.QueryRowContext(ctx, query, surveyID).Scan(&projectID, &projectTitle, &surveyTitle)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `WITH added AS (
+		INSERT INTO survey_recipient (survey_id, backing_id)
+		SELECT $1, b.backing_id
+		FROM backing b
+		WHERE b.project_id = $2
+		AND EXISTS (SELECT 1 FROM payment pa WHERE pa.backing_id = b.backing_id AND pa.status IN ('succeeded', 'partially_refunded'))
+		AND EXISTS (SELECT 1 FROM survey_question q WHERE q.survey_id = $1
+			AND (q.reward_id IS NULL OR EXISTS (SELECT 1 FROM backing_reward br WHERE br.backing_id = b.backing_id AND br.reward_id = q.reward_id)))
+		ON CONFLICT DO NOTHING
+		RETURNING backing_id
+	)
+	SELECT a.backing_id, u.email
+	FROM added a
+	INNER JOIN backing b ON b.backing_id = a.backing_id
+	INNER JOIN user_t u ON u.user_id = b.backer_id`
+
+	rows, err := tx.QueryContext(ctx, query, surveyID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := []*SurveyRecipient{}
+
+	for rows.Next() {
+		recipient := SurveyRecipient{SurveyID: surveyID, ProjectTitle: projectTitle, SurveyTitle: surveyTitle}
+
+		if err := rows.Scan(&recipient.BackingID, &recipient.BackerEmail); err != nil {
+			return nil, err
+		}
+
+		recipients = append(recipients, &recipient)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return recipients, nil
+}
+
+// GetForBacking returns the survey as the backer sees it: only their questions
+// and the answers they already gave. It returns ErrNoRecordFound when the
+// backing didn't get the survey.
+func (m SurveyModel) GetForBacking(projectID, backingID int) (*Survey, *SurveyResponse, error) {
+	query := `SELECT s.survey_id, s.project_id, s.title, s.description, s.sent_at, s.version, sr.sent_at, sr.completed_at
+	FROM survey s
+	INNER JOIN survey_recipient sr ON sr.survey_id = s.survey_id
+	WHERE s.project_id = $1 AND sr.backing_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var survey Survey
+	response := SurveyResponse{BackingID: backingID}
+
+	err := m.DB.QueryRowContext(ctx, query, projectID, backingID).Scan(
+		&survey.ID,
+		&survey.ProjectID,
+		&survey.Title,
+		&survey.Description,
+		&survey.SentAt,
+		&survey.Version,
+		&response.SentAt,
+		&response.CompletedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrNoRecordFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	survey.Questions, err = m.getQuestions(ctx, survey.ID, backingID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	answers, err := m.getAnswers(ctx, survey.ID, backingID)
+	if err != nil {
+		return nil, nil, err
+	}
+	response.Answers = answers[backingID]
+	if response.Answers == nil {
+		response.Answers = []*SurveyAnswer{}
+	}
+
+	return &survey, &response, nil
+}
+
+// getAnswers returns the answers to the survey per backing, of one backing
+// when backingID isn't 0.
+func (m SurveyModel) getAnswers(ctx context.Context, surveyID, backingID int) (map[int][]*SurveyAnswer, error) {
+	query := `SELECT a.backing_id, a.question_id, a.answer, a.address
+	FROM survey_answer a
+	INNER JOIN survey_question q ON q.question_id = a.question_id
+	WHERE a.survey_id = $1 AND ($2 = 0 OR a.backing_id = $2)
+	ORDER BY a.backing_id, q.position`
+
+	rows, err := m.DB.QueryContext(ctx, query, surveyID, backingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	answers := map[int][]*SurveyAnswer{}
+
+	for rows.Next() {
+		var id int
+		var answer SurveyAnswer
+		var address []byte
+
+		err := rows.Scan(&id, &answer.QuestionID, &answer.Answer, &address)
+		if err != nil {
+			return nil, err
+		}
+		answer.Address = address
+
+		answers[id] = append(answers[id], &answer)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return answers, nil
+}
+
+// SaveAnswers records the backer's answers and marks their survey completed.
+// Answering again replaces the previous answers.
+func (m SurveyModel) SaveAnswers(surveyID, backingID int, answers []*SurveyAnswer, addresses map[int]*ShippingAddress) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM survey_answer WHERE survey_id = $1 AND backing_id = $2`, surveyID, backingID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO survey_answer (survey_id, backing_id, question_id, answer, address) VALUES ($1, $2, $3, $4, $5)`
+
+	for _, answer := range answers {
+		var address *string
+		if a, ok := addresses[answer.AddressID]; ok && answer.AddressID != 0 {
+			js, err := json.Marshal(a)
+			if err != nil {
+				return err
+			}
+			snapshot := string(js)
+			address = &snapshot
+			answer.Address = js
+		}
+
+		_, err = tx.ExecContext(ctx, query, surveyID, backingID, answer.QuestionID, answer.Answer, address)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE survey_recipient SET completed_at = NOW() WHERE survey_id = $1 AND backing_id = $2`, surveyID, backingID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecordFound
+	}
+
+	return tx.Commit()
+}
+
+func (m SurveyModel) GetResponses(surveyID int) ([]*SurveyResponse, error) {
+	query := `SELECT sr.backing_id, u.email, sr.sent_at, sr.completed_at
+	FROM survey_recipient sr
+	INNER JOIN backing b ON b.backing_id = sr.backing_id
+	INNER JOIN user_t u ON u.user_id = b.backer_id
+	WHERE sr.survey_id = $1
+	ORDER BY sr.completed_at NULLS LAST, sr.backing_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, surveyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	responses := []*SurveyResponse{}
+
+	for rows.Next() {
+		var response SurveyResponse
+
+		err := rows.Scan(&response.BackingID, &response.BackerEmail, &response.SentAt, &response.CompletedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		responses = append(responses, &response)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	answers, err := m.getAnswers(ctx, surveyID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, response := range responses {
+		response.Answers = answers[response.BackingID]
+		if response.Answers == nil {
+			response.Answers = []*SurveyAnswer{}
+		}
+	}
+
+	return responses, nil
+}
+
+// GetDueReminders returns the recipients who haven't answered since before
+// olderThan and weren't reminded maxReminders times yet.
+func (m SurveyModel) GetDueReminders(olderThan time.Time, maxReminders int) ([]*SurveyRecipient, error) {
+	query := `SELECT sr.survey_id, sr.backing_id, p.title, s.title, u.email, sr.reminder_count
+	FROM survey_recipient sr
+	INNER JOIN survey s ON s.survey_id = sr.survey_id
+	INNER JOIN project p ON p.project_id = s.project_id
+	INNER JOIN backing b ON b.backing_id = sr.backing_id
+	INNER JOIN user_t u ON u.user_id = b.backer_id
+	WHERE sr.completed_at IS NULL AND sr.reminder_count < $2 AND COALESCE(sr.reminded_at, sr.sent_at) < $1
+	ORDER BY sr.sent_at
+	LIMIT 100`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, olderThan, maxReminders)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipients := []*SurveyRecipient{}
+
+	for rows.Next() {
+		var recipient SurveyRecipient
+
+		err := rows.Scan(&recipient.SurveyID, &recipient.BackingID, &recipient.ProjectTitle, &recipient.SurveyTitle, &recipient.BackerEmail, &recipient.ReminderCount)
+		if err != nil {
+			return nil, err
+		}
+
+		recipients = append(recipients, &recipient)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return recipients, nil
+}
+
+func (m SurveyModel) MarkReminded(surveyID, backingID int) error {
+	query := `UPDATE survey_recipient SET reminded_at = NOW(), reminder_count = reminder_count + 1
+	WHERE survey_id = $1 AND backing_id = $2 AND completed_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, surveyID, backingID)
+	return err
+}
@@ -57,6 +57,38 @@ type BackingsTable struct {
 	Status        string    `json:"status"`
 	PaymentMethod string    `json:"payment_method"`
 	TransactionID string    `json:"transaction_id"`
+	FraudScore    *int      `json:"fraud_score,omitempty"`
+	FraudSignals  []string  `json:"fraud_signals,omitempty"`
+	Flagged       *bool     `json:"flagged_for_review,omitempty"`
+}
+
+// StatementEntry is a ledger entry of a creator's project. Account is the
+// ledger account debited, which tells platform fees from processing fees.
+type StatementEntry struct {
+	ProjectID     int       `json:"project_id"`
+	Project       string    `json:"project"`
+	Type          string    `json:"type"`
+	Account       string    `json:"account"`
+	Amount        int64     `json:"amount"`
+	TransactionID string    `json:"transaction_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// PromoRedemptionsTable sums up the redemptions of a promo code. Pledged is
+// what the backers paid with the code, in the same units as Discount.
+type PromoRedemptionsTable struct {
+	PromoCodeID    int        `json:"promo_code_id"`
+	ProjectID      int        `json:"project_id"`
+	Project        string     `json:"project"`
+	Code           string     `json:"code"`
+	DiscountType   string     `json:"discount_type"`
+	DiscountValue  float64    `json:"discount_value"`
+	MaxRedemptions *int       `json:"max_redemptions"`
+	Redemptions    int        `json:"redemptions"`
+	TotalDiscount  float64    `json:"total_discount"`
+	Pledged        float64    `json:"pledged"`
+	IsActive       bool       `json:"is_active"`
+	EndsAt         *time.Time `json:"ends_at"`
 }
 
 type DisputesTable struct {
@@ -83,7 +115,7 @@ func (m TablesModel) GetProjects(page, pageSize int) ([]*ProjectsTable, MetaData
 	offset := (page - 1) * pageSize
 
 	query := `
-	SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, pr.current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, u.username as creator, u.image_url, count(DISTINCT b.backer_id) as backers, pr.is_suspicious, pr.experts_decision
+	SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, project_funding(pr.project_id) AS current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, u.username as creator, u.image_url, count(DISTINCT b.backer_id) as backers, pr.is_suspicious, pr.experts_decision
 	FROM project pr 
 	INNER JOIN user_t u ON pr.creator_id = u.user_id 
 	LEFT JOIN backing b on pr.project_id = b.project_id
@@ -152,7 +184,7 @@ func (m TablesModel) GetCreatedProjects(page, pageSize, creatorID int) ([]*Proje
 	offset := (page - 1) * pageSize
 
 	query := `
-	SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, pr.current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, count(DISTINCT b.backer_id) as backers, pr.is_suspicious, pr.experts_decision
+	SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, project_funding(pr.project_id) AS current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, count(DISTINCT b.backer_id) as backers, pr.is_suspicious, pr.experts_decision
 	FROM project pr 
 	LEFT JOIN backing b on pr.project_id = b.project_id
 	WHERE pr.creator_id = $1
@@ -215,6 +247,120 @@ func (m TablesModel) GetCreatedProjects(page, pageSize, creatorID int) ([]*Proje
 	return table, metaData, nil
 }
 
+// GetPromoRedemptions reports the redemptions of the promo codes of the
+// creator's projects.
+func (m TablesModel) GetPromoRedemptions(page, pageSize, creatorID int) ([]*PromoRedemptionsTable, MetaData, error) {
+	offset := (page - 1) * pageSize
+
+	query := `
+	SELECT COUNT(*) OVER(), pc.promo_code_id, pr.project_id, pr.title, pc.code, pc.discount_type, pc.discount_value, pc.max_redemptions,
+		COUNT(r.redemption_id), COALESCE(SUM(r.discount), 0),
+		COALESCE((SELECT SUM(pa.amount) FROM payment pa INNER JOIN promo_redemption used ON used.backing_id = pa.backing_id
+			WHERE used.promo_code_id = pc.promo_code_id AND used.redeemed_at IS NOT NULL AND pa.status NOT IN ('failed', 'canceled', 'refunded')), 0),
+		pc.is_active, pc.ends_at
+	FROM promo_code pc
+	INNER JOIN project pr ON pr.project_id = pc.project_id
+	LEFT JOIN promo_redemption r ON r.promo_code_id = pc.promo_code_id AND r.redeemed_at IS NOT NULL
+	WHERE pr.creator_id = $1
+	GROUP BY pc.promo_code_id, pr.project_id
+	ORDER BY pc.created_at DESC
+	LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, creatorID, pageSize, offset)
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+	defer rows.Close()
+
+	table := []*PromoRedemptionsTable{}
+	totalRecords := 0
+
+	for rows.Next() {
+		row := &PromoRedemptionsTable{}
+
+		err := rows.Scan(
+			&totalRecords,
+			&row.PromoCodeID,
+			&row.ProjectID,
+			&row.Project,
+			&row.Code,
+			&row.DiscountType,
+			&row.DiscountValue,
+			&row.MaxRedemptions,
+			&row.Redemptions,
+			&row.TotalDiscount,
+			&row.Pledged,
+			&row.IsActive,
+			&row.EndsAt,
+		)
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+
+		table = append(table, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metaData := calculateMetadata(totalRecords, page, pageSize)
+
+	return table, metaData, nil
+}
+
+// GetCreatorStatement returns the ledger entries of the creator's projects
+// between from and to, the projects being the ones GetCreatedProjects lists.
+// Amounts are in minor units.
+func (m TablesModel) GetCreatorStatement(creatorID int, from, to time.Time) ([]*StatementEntry, error) {
+	query := `
+	SELECT pr.project_id, pr.title, l.entry_type, l.debit_account, l.amount, COALESCE(pa.transaction_id, ''), l.created_at
+	FROM project pr
+	INNER JOIN funding_ledger l ON l.project_id = pr.project_id
+	LEFT JOIN payment pa ON pa.payment_id = l.payment_id
+	WHERE pr.creator_id = $1 AND l.created_at >= $2 AND l.created_at < $3 AND l.entry_type <> 'capture'
+	ORDER BY pr.project_id, l.created_at, l.entry_id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, creatorID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*StatementEntry{}
+
+	for rows.Next() {
+		var entry StatementEntry
+
+		err := rows.Scan(
+			&entry.ProjectID,
+			&entry.Project,
+			&entry.Type,
+			&entry.Account,
+			&entry.Amount,
+			&entry.TransactionID,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
 func (m TablesModel) GetUsers(page, pageSize int) ([]*UsersTable, MetaData, error) {
 	offset := (page - 1) * pageSize
 
@@ -227,8 +373,8 @@ func (m TablesModel) GetUsers(page, pageSize int) ([]*UsersTable, MetaData, erro
 		u.activated,
 		r.rolename,
 		COUNT(pr.creator_id) as projects_created,
-		COUNT(DISTINCT CASE WHEN pa.status = 'succeeded' THEN b.backing_id END) as projects_backed,
-		SUM(CASE WHEN pa.status = 'succeeded' THEN pa.amount ELSE 0 END) as total_contributed,
+		COUNT(DISTINCT CASE WHEN pa.status IN ('succeeded', 'partially_refunded') THEN b.backing_id END) as projects_backed,
+		SUM(CASE WHEN pa.status IN ('succeeded', 'partially_refunded') THEN pa.amount - pa.refunded_amount ELSE 0 END) as total_contributed,
 		u.created_at,
 		u.updated_at
 	FROM user_t u
@@ -290,16 +436,20 @@ func (m TablesModel) GetUsers(page, pageSize int) ([]*UsersTable, MetaData, erro
 	return table, metaData, nil
 }
 
-func (m TablesModel) GetBackings(page, pageSize int) ([]*BackingsTable, MetaData, error) {
+// GetBackings lists backings for admins, along with their fraud score.
+// flaggedOnly keeps the backings flagged for review.
+func (m TablesModel) GetBackings(page, pageSize int, flaggedOnly bool) ([]*BackingsTable, MetaData, error) {
 	offset := (page - 1) * pageSize
 
 	query := `
-	SELECT COUNT(*) OVER(), b.backing_id, pa.payment_id, u.username, pr.project_id, pr.title, pa.amount, pa.status, pa.created_at, pa.updated_at, pa.payment_method, pa.transaction_id
+	SELECT COUNT(*) OVER(), b.backing_id, pa.payment_id, u.username, pr.project_id, pr.title, pa.amount, pa.status, pa.created_at, pa.updated_at, pa.payment_method, pa.transaction_id, b.fraud_score, b.fraud_signals, b.flagged_for_review
 	FROM backing b
 	INNER JOIN user_t u ON b.backer_id = u.user_id 
 	INNER JOIN project pr ON pr.project_id = b.project_id 
 	INNER JOIN payment pa ON pa.backing_id = b.backing_id 
+	WHERE ($3 = FALSE OR b.flagged_for_review)
 	GROUP BY b.backing_id, pa.payment_id, u.username, pr.project_id, pr.title, pa.amount, pa.status, pa.payment_method, pa.created_at, pa.updated_at, pa.transaction_id
+	ORDER BY b.flagged_for_review DESC, b.fraud_score DESC, b.backing_id DESC
 	LIMIT $1 OFFSET $2
 	`
 
@@ -308,7 +458,7 @@ func (m TablesModel) GetBackings(page, pageSize int) ([]*BackingsTable, MetaData
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{pageSize, offset}
+	args := []interface{}{pageSize, offset, flaggedOnly}
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -319,6 +469,9 @@ func (m TablesModel) GetBackings(page, pageSize int) ([]*BackingsTable, MetaData
 	for rows.Next() {
 		row := &BackingsTable{}
 		var amount sql.NullFloat64
+		var fraudScore int
+		var fraudSignals pq.StringArray
+		var flagged bool
 
 		err := rows.Scan(
 			&totalRecords,
@@ -333,12 +486,18 @@ func (m TablesModel) GetBackings(page, pageSize int) ([]*BackingsTable, MetaData
 			&row.UpdatedAt,
 			&row.PaymentMethod,
 			&row.TransactionID,
+			&fraudScore,
+			&fraudSignals,
+			&flagged,
 		)
 		if err != nil {
 			return nil, MetaData{}, err
 		}
 
 		row.Amount = amount.Float64
+		row.FraudScore = &fraudScore
+		row.FraudSignals = fraudSignals
+		row.Flagged = &flagged
 
 		table = append(table, row)
 	}
@@ -352,6 +511,24 @@ func (m TablesModel) GetBackings(page, pageSize int) ([]*BackingsTable, MetaData
 }
 
 func (m TablesModel) GetUserBackings(page, pageSize, backerID int) ([]*BackingsTable, MetaData, error) {
+	return m.getUserBackings(page, pageSize, backerID, 0)
+}
+
+// GetUserBacking returns the payments of one of the backer's backings, the
+// way GetUserBackings lists them.
+func (m TablesModel) GetUserBacking(backingID, backerID int) ([]*BackingsTable, error) {
+	table, _, err := m.getUserBackings(1, 100, backerID, backingID)
+	if err != nil {
+		return nil, err
+	}
+	if len(table) == 0 {
+		return nil, ErrNoRecordFound
+	}
+
+	return table, nil
+}
+
+func (m TablesModel) getUserBackings(page, pageSize, backerID, backingID int) ([]*BackingsTable, MetaData, error) {
 	offset := (page - 1) * pageSize
 
 	query := `
@@ -359,7 +536,7 @@ func (m TablesModel) GetUserBackings(page, pageSize, backerID int) ([]*BackingsT
 	FROM backing b
 	INNER JOIN project pr ON pr.project_id = b.project_id 
 	INNER JOIN payment pa ON pa.backing_id = b.backing_id
-	WHERE b.backer_id = $1
+	WHERE b.backer_id = $1 AND ($4 = 0 OR b.backing_id = $4)
 	GROUP BY b.backing_id, pa.payment_id, pr.project_id, pr.title, pa.amount, pa.status, pa.payment_method, pa.created_at, pa.updated_at, pa.transaction_id
 	LIMIT $2 OFFSET $3
 	`
@@ -369,7 +546,7 @@ func (m TablesModel) GetUserBackings(page, pageSize, backerID int) ([]*BackingsT
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{backerID, pageSize, offset}
+	args := []interface{}{backerID, pageSize, offset, backingID}
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -506,7 +683,7 @@ func (m TablesModel) GetPendingProjects(page, pageSize int) ([]*ProjectsTable, M
 	offset := (page - 1) * pageSize
 
 	query := `
-	SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, pr.current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, u.username as creator, u.image_url, count(DISTINCT b.backer_id) as backers, pr.is_suspicious, pr.experts_decision
+	SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, project_funding(pr.project_id) AS current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, u.username as creator, u.image_url, count(DISTINCT b.backer_id) as backers, pr.is_suspicious, pr.experts_decision
 	FROM project pr 
 	INNER JOIN user_t u ON pr.creator_id = u.user_id 
 	LEFT JOIN backing b on pr.project_id = b.project_id
@@ -576,7 +753,7 @@ func (m TablesModel) GetPendingAssessementProjects(categories []string, expertID
 	offset := (page - 1) * pageSize
 
 	query := `
-	SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, pr.current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, u.username as creator, u.image_url, count(DISTINCT b.backer_id) as backers, pr.experts_decision
+	SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, project_funding(pr.project_id) AS current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, u.username as creator, u.image_url, count(DISTINCT b.backer_id) as backers, pr.experts_decision
 	FROM project pr 
 	INNER JOIN user_t u ON pr.creator_id = u.user_id 
 	LEFT JOIN backing b on pr.project_id = b.project_id
@@ -647,7 +824,7 @@ func (m TablesModel) GetAssessedProjects(categories []string, expertID, page, pa
 	offset := (page - 1) * pageSize
 
 	query := `
-	SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, pr.current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, u.username as creator, u.image_url, count(DISTINCT b.backer_id) as backers, pr.experts_decision
+	SELECT COUNT(pr.project_id) OVER(), pr.project_id, pr.title, pr.description, pr.categories, pr.funding_goal, project_funding(pr.project_id) AS current_funding, pr.deadline, pr.status, pr.project_img, pr.campaign, pr.created_at, pr.updated_at, pr.launched_at, u.username as creator, u.image_url, count(DISTINCT b.backer_id) as backers, pr.experts_decision
 	FROM project pr 
 	INNER JOIN user_t u ON pr.creator_id = u.user_id 
 	LEFT JOIN backing b on pr.project_id = b.project_id
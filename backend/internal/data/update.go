@@ -9,11 +9,12 @@ import (
 )
 
 type Update struct {
-	ID        int    `json:"id"`
-	Title     string `json:"title"`
-	Content   string `json:"content"`
-	ProjectID int    `json:"project_id"`
-	CreatedAt string `json:"created_at"`
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Content     string `json:"content"`
+	ProjectID   int    `json:"project_id"`
+	MembersOnly bool   `json:"members_only"`
+	CreatedAt   string `json:"created_at"`
 }
 
 func ValidateUpdate(v *validator.Validator, update *Update) {
@@ -33,14 +34,15 @@ type UpdateModel struct {
 func (m UpdateModel) Insert(update *Update) error {
 	query := `
 	INSERT INTO project_update 
-	(title, content, project_id)
-	VALUES ($1, $2, $3)
+	(title, content, project_id, members_only)
+	VALUES ($1, $2, $3, $4)
 	RETURNING update_id, created_at
 	`
 	args := []interface{}{
 		update.Title,
 		update.Content,
 		update.ProjectID,
+		update.MembersOnly,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -77,7 +79,7 @@ func (m UpdateModel) GetAllByProjectID(projectID int, filters Filter) ([]*Update
 	query := `
 	SELECT COUNT(*) OVER(), update_id, title, content, created_at
 	FROM project_update
-	WHERE project_id = $1
+	WHERE project_id = $1 AND NOT members_only
 	ORDER BY created_at DESC
 	LIMIT $2 OFFSET $3
 	`
@@ -120,7 +122,7 @@ func (m UpdateModel) Get(id int) (*Update, error) {
 		return nil, ErrNoRecordFound
 	}
 
-	query := `SELECT update_id, title, content, created_at, project_id
+	query := `SELECT update_id, title, content, created_at, project_id, members_only
 	FROM project_update
 	WHERE update_id = $1`
 
@@ -135,6 +137,7 @@ func (m UpdateModel) Get(id int) (*Update, error) {
 		&update.Content,
 		&update.CreatedAt,
 		&update.ProjectID,
+		&update.MembersOnly,
 	)
 
 	if err != nil {
@@ -148,3 +151,52 @@ func (m UpdateModel) Get(id int) (*Update, error) {
 
 	return &update, nil
 }
+
+// GetMembersOnlyUpdates returns the members-only updates posted on the
+// creator's projects.
+func (m UpdateModel) GetMembersOnlyUpdates(creatorID int, filters Filter) ([]*Update, MetaData, error) {
+	offset := (filters.Page - 1) * filters.PageSize
+
+	query := `
+	SELECT COUNT(*) OVER(), pu.update_id, pu.title, pu.content, pu.created_at, pu.project_id
+	FROM project_update pu
+	INNER JOIN project p ON p.project_id = pu.project_id
+	WHERE p.creator_id = $1 AND pu.members_only
+	ORDER BY pu.created_at DESC
+	LIMIT $2 OFFSET $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, creatorID, filters.PageSize, offset)
+	if err != nil {
+		return nil, MetaData{}, err
+	}
+	defer rows.Close()
+
+	updates := []*Update{}
+	totalRecords := 0
+	for rows.Next() {
+		update := &Update{MembersOnly: true}
+		err := rows.Scan(
+			&totalRecords,
+			&update.ID,
+			&update.Title,
+			&update.Content,
+			&update.CreatedAt,
+			&update.ProjectID,
+		)
+		if err != nil {
+			return nil, MetaData{}, err
+		}
+		updates = append(updates, update)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, MetaData{}, err
+	}
+
+	metaData := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return updates, metaData, nil
+}
@@ -0,0 +1,315 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"projectx/internal/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RewardVariant is one version of a reward, like a size or a color of a
+// t-shirt. A variant with a QuantityLimit has its own stock on top of the
+// reward's.
+type RewardVariant struct {
+	ID            int               `json:"id"`
+	Name          string            `json:"name"`
+	Options       map[string]string `json:"options"`
+	QuantityLimit *int              `json:"quantity_limit"`
+	Remaining     *int              `json:"remaining"`
+	IsAvailable   bool              `json:"is_available"`
+}
+
+// VariantTotal is how many units of a reward variant were pledged, for
+// manufacturing. VariantID is nil for the units of rewards without variants.
+type VariantTotal struct {
+	RewardID    int               `json:"reward_id"`
+	RewardTitle string            `json:"reward_title"`
+	IsAddon     bool              `json:"is_addon"`
+	VariantID   *int              `json:"variant_id"`
+	VariantName string            `json:"variant_name"`
+	Options     map[string]string `json:"options"`
+	Quantity    int               `json:"quantity"`
+}
+
+// variantRemaining works like rewardRemaining for the units of a variant.
+const variantRemaining = `GREATEST(rv.quantity_limit
+	- (SELECT COUNT(*) FROM backing_reward br WHERE br.variant_id = rv.variant_id)
+	- (SELECT COUNT(*) FROM reward_reservation rr WHERE rr.variant_id = rv.variant_id AND rr.expires_at > NOW()), 0)`
+
+func ValidateRewardVariants(v *validator.Validator, variants []RewardVariant, index int32) {
+	names := make([]string, 0, len(variants))
+	for _, variant := range variants {
+		names = append(names, variant.Name)
+		v.Check(variant.Name != "", "variants", IfThenElseMessage(index == 0, "Variant name must be provided", fmt.Sprintf("Variant name of reward %d must be provided", index)))
+		v.Check(validator.MaxChars(variant.Name, 50), "variants", IfThenElseMessage(index == 0, "Variant name cannot be more than 50 characters", fmt.Sprintf("Variant name of reward %d cannot be more than 50 characters", index)))
+		v.Check(variant.QuantityLimit == nil || *variant.QuantityLimit > 0, "variants", IfThenElseMessage(index == 0, "Variant quantity limit must be greater than zero", fmt.Sprintf("Variant quantity limit of reward %d must be greater than zero", index)))
+	}
+	v.Check(validator.Unique(names), "variants", IfThenElseMessage(index == 0, "Variant names must be unique", fmt.Sprintf("Variant names of reward %d must be unique", index)))
+}
+
+// validateSelection checks the variants picked for the rewards of a pledge
+// and that add-ons come with a base reward.
+func validateSelection(v *validator.Validator, rewardIDs []int, found map[int]*Reward, variants map[int]int) {
+	hasBase, hasAddon := false, false
+
+	for _, id := range rewardIDs {
+		reward, ok := found[id]
+		if !ok {
+			continue
+		}
+
+		if reward.IsAddon {
+			hasAddon = true
+		} else {
+			hasBase = true
+		}
+
+		variantID, picked := variants[id]
+		if len(reward.Variants) == 0 {
+			v.Check(!picked, "variants", fmt.Sprintf("Reward %d doesn't have variants", id))
+			continue
+		}
+		if !picked {
+			v.AddError("variants", fmt.Sprintf("A variant must be picked for reward %d", id))
+			continue
+		}
+
+		var variant *RewardVariant
+		for i := range reward.Variants {
+			if reward.Variants[i].ID == variantID {
+				variant = &reward.Variants[i]
+			}
+		}
+		if variant == nil {
+			v.AddError("variants", fmt.Sprintf("Variant %d doesn't belong to reward %d", variantID, id))
+			continue
+		}
+		v.Check(variant.IsAvailable, "variants", fmt.Sprintf("Variant %s of reward %d is not available anymore", variant.Name, id))
+	}
+
+	for rewardID := range variants {
+		if _, ok := found[rewardID]; !ok || !validator.In(rewardID, rewardIDs...) {
+			v.AddError("variants", fmt.Sprintf("A variant was picked for reward %d which isn't selected", rewardID))
+		}
+	}
+
+	v.Check(!hasAddon || hasBase, "rewards", "Add-ons can only be pledged for along with a base reward")
+}
+
+func loadRewardVariants(ctx context.Context, q querier, rewardIDs []int) (map[int][]RewardVariant, error) {
+	query := `SELECT rv.reward_id, rv.variant_id, rv.name, rv.options, rv.quantity_limit, ` + variantRemaining + `, rv.is_available
+	FROM reward_variant rv
+	WHERE rv.reward_id = ANY($1)
+	ORDER BY rv.reward_id, rv.variant_id`
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(rewardIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := map[int][]RewardVariant{}
+
+	for rows.Next() {
+		var rewardID int
+		var variant RewardVariant
+		var options []byte
+		var quantityLimit, remaining sql.NullInt64
+
+		err := rows.Scan(&rewardID, &variant.ID, &variant.Name, &options, &quantityLimit, &remaining, &variant.IsAvailable)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal(options, &variant.Options); err != nil {
+			return nil, err
+		}
+		variant.QuantityLimit = nullableInt(quantityLimit)
+		variant.Remaining = nullableInt(remaining)
+
+		variants[rewardID] = append(variants[rewardID], variant)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// saveRewardVariants works like UpdateAll for the variants of one reward:
+// variants sent back with their id are kept, the others are deleted unless
+// someone already pledged for them.
+func saveRewardVariants(ctx context.Context, tx *sql.Tx, rewardID int, variants []RewardVariant) error {
+	insertQuery := `INSERT INTO reward_variant (reward_id, name, options, quantity_limit) VALUES ($1, $2, $3, $4) RETURNING variant_id`
+
+	updateQuery := `UPDATE reward_variant rv SET name = $1, options = $2, quantity_limit = $3,
+	is_available = ($3::integer IS NULL OR (SELECT COUNT(*) FROM backing_reward br WHERE br.variant_id = rv.variant_id) < $3::integer), is_retired = FALSE, version = version + 1
+	WHERE rv.variant_id = $4 AND rv.reward_id = $5`
+
+	kept := []int64{}
+
+	for i, variant := range variants {
+		if variant.Options == nil {
+			variant.Options = map[string]string{}
+		}
+
+		options, err := json.Marshal(variant.Options)
+		if err != nil {
+			return err
+		}
+
+		if variant.ID == 0 {
+			err = tx.QueryRowContext(ctx, insertQuery, rewardID, variant.Name, string(options), variant.QuantityLimit).Scan(&variants[i].ID)
+			if err != nil {
+				return err
+			}
+
+			kept = append(kept, int64(variants[i].ID))
+			continue
+		}
+
+		result, err := tx.ExecContext(ctx, updateQuery, variant.Name, string(options), variant.QuantityLimit, variant.ID, rewardID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNoRecordFound
+		}
+
+		kept = append(kept, int64(variant.ID))
+	}
+
+	query := `DELETE FROM reward_variant rv WHERE rv.reward_id = $1 AND NOT (rv.variant_id = ANY($2))
+	AND NOT EXISTS (SELECT 1 FROM backing_reward br WHERE br.variant_id = rv.variant_id)`
+
+	_, err := tx.ExecContext(ctx, query, rewardID, pq.Array(kept))
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE reward_variant SET is_available = FALSE, is_retired = TRUE, version = version + 1 WHERE reward_id = $1 AND NOT (variant_id = ANY($2))`
+
+	_, err = tx.ExecContext(ctx, query, rewardID, pq.Array(kept))
+	return err
+}
+
+// variantUnitsLeft returns the units left of a variant of a reward locked by
+// lockReward.
+func variantUnitsLeft(ctx context.Context, tx *sql.Tx, rewardID, variantID int) (*int, error) {
+	query := `SELECT rv.is_available, ` + variantRemaining + ` FROM reward_variant rv WHERE rv.variant_id = $1 AND rv.reward_id = $2`
+
+	var isAvailable bool
+	var remaining sql.NullInt64
+
+	err := tx.QueryRowContext(ctx, query, variantID, rewardID).Scan(&isAvailable, &remaining)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, fmt.Errorf("variant %d of reward %d: %w", variantID, rewardID, ErrNoRecordFound)
+		default:
+			return nil, err
+		}
+	}
+
+	if !isAvailable {
+		zero := 0
+		return &zero, nil
+	}
+
+	return nullableInt(remaining), nil
+}
+
+// variantOf returns the variant picked for the reward, or nil.
+func variantOf(variants map[int]int, rewardID int) *int {
+	variantID, ok := variants[rewardID]
+	if !ok {
+		return nil
+	}
+	return &variantID
+}
+
+// GetRewardVariants returns the variant pledged for each reward of the
+// backing that has one.
+func (m BackingModel) GetRewardVariants(backingID int) (map[int]int, error) {
+	query := `SELECT reward_id, variant_id FROM backing_reward WHERE backing_id = $1 AND variant_id IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, backingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := map[int]int{}
+
+	for rows.Next() {
+		var rewardID, variantID int
+
+		if err := rows.Scan(&rewardID, &variantID); err != nil {
+			return nil, err
+		}
+
+		variants[rewardID] = variantID
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// GetVariantTotals counts the pledged units of every reward and variant of the
+// project.
+func (m RewardModel) GetVariantTotals(projectID int) ([]*VariantTotal, error) {
+	query := `SELECT r.reward_id, r.title, r.is_addon, rv.variant_id, COALESCE(rv.name, ''), COALESCE(rv.options, '{}'), COUNT(*)
+	FROM backing_reward br
+	INNER JOIN reward r ON r.reward_id = br.reward_id
+	LEFT JOIN reward_variant rv ON rv.variant_id = br.variant_id
+	WHERE r.project_id = $1
+	GROUP BY r.reward_id, r.title, r.is_addon, rv.variant_id, rv.name, rv.options
+	ORDER BY r.reward_id, rv.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []*VariantTotal{}
+
+	for rows.Next() {
+		var total VariantTotal
+		var options []byte
+
+		err := rows.Scan(&total.RewardID, &total.RewardTitle, &total.IsAddon, &total.VariantID, &total.VariantName, &options, &total.Quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal(options, &total.Options); err != nil {
+			return nil, err
+		}
+
+		totals = append(totals, &total)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
@@ -0,0 +1,45 @@
+package payments
+
+import "errors"
+
+const (
+	AccountPending  = "pending"
+	AccountVerified = "verified"
+	AccountRejected = "rejected"
+)
+
+var ErrAccountNotFound = errors.New("payout account not found")
+
+type BankAccount struct {
+	HolderName    string
+	AccountNumber string
+	RoutingNumber string
+	Country       string
+	Currency      string
+}
+
+// AccountParams describe the creator being onboarded. TOSAcceptedIP is where
+// they accepted the provider's terms from.
+type AccountParams struct {
+	Email         string
+	LegalName     string
+	Country       string
+	Bank          BankAccount
+	TOSAcceptedIP string
+}
+
+// Account is a connected account payouts are sent to. StatusReason tells what
+// is holding verification back, when the provider says.
+type Account struct {
+	ID           string
+	Status       string
+	StatusReason string
+}
+
+// PayoutAccountProvider onboards creators as connected accounts. Verification
+// may take a while, GetAccount returns where it's at.
+type PayoutAccountProvider interface {
+	CreateAccount(params AccountParams) (*Account, error)
+	UpdateAccount(id string, params AccountParams) (*Account, error)
+	GetAccount(id string) (*Account, error)
+}
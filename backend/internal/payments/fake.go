@@ -0,0 +1,152 @@
+package payments
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FakeGateway keeps intents in memory and settles them immediately, so the
+// whole backing flow can run without reaching Stripe. IDs are sequential, which
+// keeps runs reproducible.
+type FakeGateway struct {
+	mu            sync.Mutex
+	seq           int
+	intents       map[string]*Intent
+	refunded      map[string]int64
+	refunds       map[string]*Refund
+	plans         map[string]int64
+	subscriptions map[string]*Subscription
+}
+
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		intents:       make(map[string]*Intent),
+		refunded:      make(map[string]int64),
+		refunds:       make(map[string]*Refund),
+		plans:         make(map[string]int64),
+		subscriptions: make(map[string]*Subscription),
+	}
+}
+
+func (g *FakeGateway) CreateIntent(params IntentParams) (*Intent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.seq++
+	id := fmt.Sprintf("pi_fake_%06d", g.seq)
+
+	status := StatusSucceeded
+	if params.ManualCapture {
+		status = StatusRequiresCapture
+	}
+
+	metadata := make(map[string]string, len(params.Metadata))
+	for key, value := range params.Metadata {
+		metadata[key] = value
+	}
+
+	intent := &Intent{
+		ID:           id,
+		ClientSecret: id + "_secret_fake",
+		Amount:       params.Amount,
+		Status:       status,
+		Metadata:     metadata,
+	}
+	g.intents[id] = intent
+
+	result := *intent
+	return &result, nil
+}
+
+func (g *FakeGateway) GetIntent(id string) (*Intent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	intent, ok := g.intents[id]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+
+	result := *intent
+	return &result, nil
+}
+
+func (g *FakeGateway) CaptureIntent(id string, amount int64) (*Intent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	intent, ok := g.intents[id]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+
+	if intent.Status != StatusRequiresCapture || amount > intent.Amount {
+		return nil, ErrInvalidState
+	}
+
+	if amount > 0 {
+		intent.Amount = amount
+	}
+	intent.Status = StatusSucceeded
+
+	result := *intent
+	return &result, nil
+}
+
+func (g *FakeGateway) CancelIntent(id string) (*Intent, error) {
+	return g.transition(id, StatusRequiresCapture, StatusCanceled)
+}
+
+func (g *FakeGateway) Refund(params RefundParams) (*Refund, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if refund, ok := g.refunds[params.IdempotencyKey]; ok {
+		result := *refund
+		return &result, nil
+	}
+
+	intent, ok := g.intents[params.IntentID]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+
+	amount := params.Amount
+	remaining := intent.Amount - g.refunded[params.IntentID]
+	if intent.Status != StatusSucceeded || remaining <= 0 || amount > remaining {
+		return nil, ErrInvalidState
+	}
+
+	if amount == 0 {
+		amount = remaining
+	}
+
+	g.refunded[params.IntentID] += amount
+	g.seq++
+
+	refund := &Refund{ID: fmt.Sprintf("re_fake_%06d", g.seq), Amount: amount}
+	if params.IdempotencyKey != "" {
+		g.refunds[params.IdempotencyKey] = refund
+	}
+
+	result := *refund
+	return &result, nil
+}
+
+func (g *FakeGateway) transition(id, from, to string) (*Intent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	intent, ok := g.intents[id]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+
+	if intent.Status != from {
+		return nil, ErrInvalidState
+	}
+	intent.Status = to
+
+	result := *intent
+	return &result, nil
+}
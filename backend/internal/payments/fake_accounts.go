@@ -0,0 +1,73 @@
+package payments
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FakeAccountProvider keeps accounts in memory and verifies them right away.
+// An account number made of zeros only gets the account rejected, to try that
+// path without reaching Stripe.
+type FakeAccountProvider struct {
+	mu       sync.Mutex
+	seq      int
+	accounts map[string]*Account
+}
+
+func NewFakeAccountProvider() *FakeAccountProvider {
+	return &FakeAccountProvider{
+		accounts: make(map[string]*Account),
+	}
+}
+
+func (p *FakeAccountProvider) CreateAccount(params AccountParams) (*Account, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.seq++
+	account := &Account{ID: fmt.Sprintf("acct_fake_%06d", p.seq)}
+	verifyFake(account, params)
+	p.accounts[account.ID] = account
+
+	result := *account
+	return &result, nil
+}
+
+func (p *FakeAccountProvider) UpdateAccount(id string, params AccountParams) (*Account, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	account, ok := p.accounts[id]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	verifyFake(account, params)
+
+	result := *account
+	return &result, nil
+}
+
+func (p *FakeAccountProvider) GetAccount(id string) (*Account, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	account, ok := p.accounts[id]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+
+	result := *account
+	return &result, nil
+}
+
+func verifyFake(account *Account, params AccountParams) {
+	if strings.Trim(params.Bank.AccountNumber, "0") == "" {
+		account.Status = AccountRejected
+		account.StatusReason = "rejected.other"
+		return
+	}
+
+	account.Status = AccountVerified
+	account.StatusReason = ""
+}
@@ -0,0 +1,96 @@
+package payments
+
+import (
+	"fmt"
+	"time"
+)
+
+func (g *FakeGateway) CreatePlan(params PlanParams) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.seq++
+	id := fmt.Sprintf("price_fake_%06d", g.seq)
+	g.plans[id] = params.Amount
+
+	return id, nil
+}
+
+// CreateSubscription starts the subscription paid for its first month.
+// Every month that goes by is paid for as well, when the subscription is
+// looked at again.
+func (g *FakeGateway) CreateSubscription(params SubscriptionParams) (*Subscription, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	amount, ok := g.plans[params.PlanID]
+	if !ok {
+		return nil, fmt.Errorf("plan %s not found", params.PlanID)
+	}
+
+	g.seq++
+	id := fmt.Sprintf("sub_fake_%06d", g.seq)
+
+	subscription := &Subscription{
+		ID:               id,
+		Status:           SubscriptionActive,
+		ClientSecret:     id + "_secret_fake",
+		CurrentPeriodEnd: time.Now().AddDate(0, 1, 0),
+		LatestInvoice:    g.fakeInvoice(amount),
+	}
+	g.subscriptions[id] = subscription
+
+	result := *subscription
+	return &result, nil
+}
+
+func (g *FakeGateway) GetSubscription(id string) (*Subscription, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	subscription, ok := g.subscriptions[id]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	for subscription.Status == SubscriptionActive && time.Now().After(subscription.CurrentPeriodEnd) {
+		if subscription.CancelAtPeriodEnd {
+			subscription.Status = SubscriptionCanceled
+			break
+		}
+
+		subscription.CurrentPeriodEnd = subscription.CurrentPeriodEnd.AddDate(0, 1, 0)
+		subscription.LatestInvoice = g.fakeInvoice(subscription.LatestInvoice.Amount)
+	}
+
+	result := *subscription
+	return &result, nil
+}
+
+func (g *FakeGateway) CancelSubscription(id string, atPeriodEnd bool) (*Subscription, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	subscription, ok := g.subscriptions[id]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	if subscription.Status == SubscriptionCanceled {
+		return nil, ErrInvalidState
+	}
+
+	if atPeriodEnd {
+		subscription.CancelAtPeriodEnd = true
+	} else {
+		subscription.Status = SubscriptionCanceled
+	}
+
+	result := *subscription
+	return &result, nil
+}
+
+func (g *FakeGateway) fakeInvoice(amount int64) *Invoice {
+	g.seq++
+	return &Invoice{ID: fmt.Sprintf("in_fake_%06d", g.seq), Amount: amount, Paid: true}
+}
@@ -0,0 +1,224 @@
+package payments
+
+import (
+	"errors"
+	"testing"
+)
+
+func newIntent(t *testing.T, g *FakeGateway, amount int64, manualCapture bool) *Intent {
+	t.Helper()
+
+	intent, err := g.CreateIntent(IntentParams{Amount: amount, Currency: "dzd", ManualCapture: manualCapture})
+	if err != nil {
+		t.Fatalf("creating intent: %v", err)
+	}
+
+	return intent
+}
+
+func TestFakeGatewayCreateIntent(t *testing.T) {
+	tests := []struct {
+		name          string
+		manualCapture bool
+		wantStatus    string
+	}{
+		{name: "automatic capture", manualCapture: false, wantStatus: StatusSucceeded},
+		{name: "manual capture", manualCapture: true, wantStatus: StatusRequiresCapture},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewFakeGateway()
+
+			intent := newIntent(t, g, 5000, tt.manualCapture)
+			if intent.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", intent.Status, tt.wantStatus)
+			}
+
+			got, err := g.GetIntent(intent.ID)
+			if err != nil {
+				t.Fatalf("getting intent: %v", err)
+			}
+			if got.Status != tt.wantStatus || got.Amount != 5000 {
+				t.Errorf("stored intent = %+v, want status %q and amount 5000", got, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestFakeGatewayCaptureIntent(t *testing.T) {
+	tests := []struct {
+		name          string
+		manualCapture bool
+		amount        int64
+		wantErr       error
+		wantAmount    int64
+	}{
+		{name: "whole hold", manualCapture: true, amount: 0, wantAmount: 5000},
+		{name: "part of the hold", manualCapture: true, amount: 3000, wantAmount: 3000},
+		{name: "more than the hold", manualCapture: true, amount: 6000, wantErr: ErrInvalidState},
+		{name: "already captured", manualCapture: false, amount: 0, wantErr: ErrInvalidState},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewFakeGateway()
+			intent := newIntent(t, g, 5000, tt.manualCapture)
+
+			captured, err := g.CaptureIntent(intent.ID, tt.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if captured.Status != StatusSucceeded || captured.Amount != tt.wantAmount {
+				t.Errorf("captured intent = %+v, want status %q and amount %d", captured, StatusSucceeded, tt.wantAmount)
+			}
+		})
+	}
+
+	t.Run("unknown intent", func(t *testing.T) {
+		_, err := NewFakeGateway().CaptureIntent("pi_unknown", 0)
+		if !errors.Is(err, ErrIntentNotFound) {
+			t.Errorf("err = %v, want %v", err, ErrIntentNotFound)
+		}
+	})
+}
+
+func TestFakeGatewayCancelIntent(t *testing.T) {
+	tests := []struct {
+		name          string
+		manualCapture bool
+		cancelTwice   bool
+		wantErr       error
+	}{
+		{name: "hold", manualCapture: true},
+		{name: "captured payment", manualCapture: false, wantErr: ErrInvalidState},
+		{name: "hold already released", manualCapture: true, cancelTwice: true, wantErr: ErrInvalidState},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewFakeGateway()
+			intent := newIntent(t, g, 5000, tt.manualCapture)
+
+			if tt.cancelTwice {
+				if _, err := g.CancelIntent(intent.ID); err != nil {
+					t.Fatalf("first cancel: %v", err)
+				}
+			}
+
+			canceled, err := g.CancelIntent(intent.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && canceled.Status != StatusCanceled {
+				t.Errorf("status = %q, want %q", canceled.Status, StatusCanceled)
+			}
+		})
+	}
+}
+
+func TestFakeGatewayRefund(t *testing.T) {
+	tests := []struct {
+		name          string
+		manualCapture bool
+		refunds       []RefundParams
+		wantErr       error
+		wantAmounts   []int64
+	}{
+		{
+			name:        "whole payment",
+			refunds:     []RefundParams{{Amount: 0}},
+			wantAmounts: []int64{5000},
+		},
+		{
+			name:        "part then the rest",
+			refunds:     []RefundParams{{Amount: 2000}, {Amount: 0}},
+			wantAmounts: []int64{2000, 3000},
+		},
+		{
+			name:    "more than what is left",
+			refunds: []RefundParams{{Amount: 4000}, {Amount: 2000}},
+			wantErr: ErrInvalidState,
+		},
+		{
+			name:    "nothing left",
+			refunds: []RefundParams{{Amount: 0}, {Amount: 0}},
+			wantErr: ErrInvalidState,
+		},
+		{
+			name:          "hold",
+			manualCapture: true,
+			refunds:       []RefundParams{{Amount: 0}},
+			wantErr:       ErrInvalidState,
+		},
+		{
+			name:        "same idempotency key",
+			refunds:     []RefundParams{{Amount: 4000, IdempotencyKey: "key"}, {Amount: 4000, IdempotencyKey: "key"}, {Amount: 1000}},
+			wantAmounts: []int64{4000, 4000, 1000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewFakeGateway()
+			intent := newIntent(t, g, 5000, tt.manualCapture)
+
+			var err error
+			var amounts []int64
+			for _, params := range tt.refunds {
+				params.IntentID = intent.ID
+
+				var refund *Refund
+				refund, err = g.Refund(params)
+				if err != nil {
+					break
+				}
+				amounts = append(amounts, refund.Amount)
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if len(amounts) != len(tt.wantAmounts) {
+				t.Fatalf("refunded %v, want %v", amounts, tt.wantAmounts)
+			}
+			for i := range amounts {
+				if amounts[i] != tt.wantAmounts[i] {
+					t.Errorf("refunded %v, want %v", amounts, tt.wantAmounts)
+					break
+				}
+			}
+		})
+	}
+
+	t.Run("same idempotency key returns the same refund", func(t *testing.T) {
+		g := NewFakeGateway()
+		intent := newIntent(t, g, 5000, false)
+
+		params := RefundParams{IntentID: intent.ID, Amount: 1000, IdempotencyKey: "cancellation_1"}
+
+		first, err := g.Refund(params)
+		if err != nil {
+			t.Fatalf("first refund: %v", err)
+		}
+		second, err := g.Refund(params)
+		if err != nil {
+			t.Fatalf("second refund: %v", err)
+		}
+
+		if first.ID != second.ID {
+			t.Errorf("refund ids = %q and %q, want the same", first.ID, second.ID)
+		}
+		if g.refunded[intent.ID] != 1000 {
+			t.Errorf("refunded %d, want 1000", g.refunded[intent.ID])
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package payments
+
+import "errors"
+
+const (
+	StatusSucceeded       = "succeeded"
+	StatusRequiresCapture = "requires_capture"
+	StatusCanceled        = "canceled"
+)
+
+var (
+	ErrIntentNotFound = errors.New("payment intent not found")
+	ErrInvalidState   = errors.New("payment intent can't be changed in its current state")
+)
+
+type Intent struct {
+	ID           string
+	ClientSecret string
+	Amount       int64
+	Status       string
+	Metadata     map[string]string
+}
+
+type IntentParams struct {
+	Amount        int64
+	Currency      string
+	ManualCapture bool
+	Metadata      map[string]string
+}
+
+// RefundParams refund Amount of an intent, or whatever is left on it when
+// Amount is 0. A refund sent again with the same IdempotencyKey is only made
+// once, the first one is returned instead.
+type RefundParams struct {
+	IntentID       string
+	Amount         int64
+	IdempotencyKey string
+	Metadata       map[string]string
+}
+
+type Refund struct {
+	ID     string
+	Amount int64
+}
+
+// PaymentGateway amounts are in minor units. CaptureIntent captures the whole
+// authorized amount when amount is 0.
+type PaymentGateway interface {
+	CreateIntent(params IntentParams) (*Intent, error)
+	GetIntent(id string) (*Intent, error)
+	CaptureIntent(id string, amount int64) (*Intent, error)
+	CancelIntent(id string) (*Intent, error)
+	Refund(params RefundParams) (*Refund, error)
+	SubscriptionGateway
+}
@@ -0,0 +1,121 @@
+package payments
+
+import (
+	"errors"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/client"
+)
+
+type StripeGateway struct {
+	client *client.API
+}
+
+func NewStripeGateway(secretKey string) *StripeGateway {
+	return &StripeGateway{
+		client: client.New(secretKey, nil),
+	}
+}
+
+func (g *StripeGateway) CreateIntent(params IntentParams) (*Intent, error) {
+	p := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(params.Amount),
+		Currency: stripe.String(params.Currency),
+	}
+
+	if params.ManualCapture {
+		p.CaptureMethod = stripe.String(string(stripe.PaymentIntentCaptureMethodManual))
+	}
+
+	for key, value := range params.Metadata {
+		p.AddMetadata(key, value)
+	}
+
+	pi, err := g.client.PaymentIntents.New(p)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+
+	return toIntent(pi), nil
+}
+
+func (g *StripeGateway) GetIntent(id string) (*Intent, error) {
+	pi, err := g.client.PaymentIntents.Get(id, nil)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+
+	return toIntent(pi), nil
+}
+
+func (g *StripeGateway) CaptureIntent(id string, amount int64) (*Intent, error) {
+	p := &stripe.PaymentIntentCaptureParams{}
+
+	if amount > 0 {
+		p.AmountToCapture = stripe.Int64(amount)
+	}
+
+	pi, err := g.client.PaymentIntents.Capture(id, p)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+
+	return toIntent(pi), nil
+}
+
+func (g *StripeGateway) CancelIntent(id string) (*Intent, error) {
+	pi, err := g.client.PaymentIntents.Cancel(id, nil)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+
+	return toIntent(pi), nil
+}
+
+func (g *StripeGateway) Refund(params RefundParams) (*Refund, error) {
+	p := &stripe.RefundParams{
+		PaymentIntent: stripe.String(params.IntentID),
+	}
+
+	if params.Amount > 0 {
+		p.Amount = stripe.Int64(params.Amount)
+	}
+
+	if params.IdempotencyKey != "" {
+		p.SetIdempotencyKey(params.IdempotencyKey)
+	}
+
+	for key, value := range params.Metadata {
+		p.AddMetadata(key, value)
+	}
+
+	r, err := g.client.Refunds.New(p)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+
+	return &Refund{ID: r.ID, Amount: r.Amount}, nil
+}
+
+func toIntent(pi *stripe.PaymentIntent) *Intent {
+	return &Intent{
+		ID:           pi.ID,
+		ClientSecret: pi.ClientSecret,
+		Amount:       pi.Amount,
+		Status:       string(pi.Status),
+		Metadata:     pi.Metadata,
+	}
+}
+
+func stripeError(err error) error {
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) {
+		switch stripeErr.Code {
+		case stripe.ErrorCodeResourceMissing:
+			return ErrIntentNotFound
+		case stripe.ErrorCodePaymentIntentUnexpectedState:
+			return ErrInvalidState
+		}
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package payments
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/client"
+)
+
+// StripeAccountProvider onboards creators as Stripe Connect custom accounts.
+type StripeAccountProvider struct {
+	client *client.API
+}
+
+func NewStripeAccountProvider(secretKey string) *StripeAccountProvider {
+	return &StripeAccountProvider{
+		client: client.New(secretKey, nil),
+	}
+}
+
+func (p *StripeAccountProvider) CreateAccount(params AccountParams) (*Account, error) {
+	ap := accountParams(params)
+	ap.Type = stripe.String(string(stripe.AccountTypeCustom))
+	ap.Country = stripe.String(params.Country)
+	ap.BusinessType = stripe.String(string(stripe.AccountBusinessTypeIndividual))
+	ap.Capabilities = &stripe.AccountCapabilitiesParams{
+		Transfers: &stripe.AccountCapabilitiesTransfersParams{Requested: stripe.Bool(true)},
+	}
+	ap.TOSAcceptance = &stripe.AccountTOSAcceptanceParams{
+		Date: stripe.Int64(time.Now().Unix()),
+		IP:   stripe.String(params.TOSAcceptedIP),
+	}
+
+	a, err := p.client.Account.New(ap)
+	if err != nil {
+		return nil, accountError(err)
+	}
+
+	return toAccount(a), nil
+}
+
+func (p *StripeAccountProvider) UpdateAccount(id string, params AccountParams) (*Account, error) {
+	a, err := p.client.Account.Update(id, accountParams(params))
+	if err != nil {
+		return nil, accountError(err)
+	}
+
+	return toAccount(a), nil
+}
+
+func (p *StripeAccountProvider) GetAccount(id string) (*Account, error) {
+	a, err := p.client.Account.GetByID(id, nil)
+	if err != nil {
+		return nil, accountError(err)
+	}
+
+	return toAccount(a), nil
+}
+
+func accountParams(params AccountParams) *stripe.AccountParams {
+	firstName, lastName, _ := strings.Cut(params.LegalName, " ")
+
+	return &stripe.AccountParams{
+		Email: stripe.String(params.Email),
+		Individual: &stripe.PersonParams{
+			FirstName: stripe.String(firstName),
+			LastName:  stripe.String(lastName),
+			Email:     stripe.String(params.Email),
+		},
+		ExternalAccount: &stripe.AccountExternalAccountParams{
+			AccountHolderName: stripe.String(params.Bank.HolderName),
+			AccountHolderType: stripe.String(string(stripe.BankAccountAccountHolderTypeIndividual)),
+			AccountNumber:     stripe.String(params.Bank.AccountNumber),
+			RoutingNumber:     stripe.String(params.Bank.RoutingNumber),
+			Country:           stripe.String(params.Bank.Country),
+			Currency:          stripe.String(params.Bank.Currency),
+		},
+	}
+}
+
+func toAccount(a *stripe.Account) *Account {
+	account := &Account{ID: a.ID, Status: AccountPending}
+
+	if a.PayoutsEnabled {
+		account.Status = AccountVerified
+		return account
+	}
+
+	if a.Requirements != nil {
+		account.StatusReason = string(a.Requirements.DisabledReason)
+		if strings.HasPrefix(account.StatusReason, "rejected") {
+			account.Status = AccountRejected
+		}
+	}
+
+	return account
+}
+
+func accountError(err error) error {
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+		return ErrAccountNotFound
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package payments
+
+import (
+	"errors"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func (g *StripeGateway) CreatePlan(params PlanParams) (string, error) {
+	product, err := g.client.Products.New(&stripe.ProductParams{
+		Name: stripe.String(params.Name),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	price, err := g.client.Prices.New(&stripe.PriceParams{
+		Product:    stripe.String(product.ID),
+		UnitAmount: stripe.Int64(params.Amount),
+		Currency:   stripe.String(params.Currency),
+		Recurring: &stripe.PriceRecurringParams{
+			Interval: stripe.String(string(stripe.PriceRecurringIntervalMonth)),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return price.ID, nil
+}
+
+func (g *StripeGateway) CreateSubscription(params SubscriptionParams) (*Subscription, error) {
+	customer, err := g.client.Customers.New(&stripe.CustomerParams{
+		Email: stripe.String(params.Email),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &stripe.SubscriptionParams{
+		Customer: stripe.String(customer.ID),
+		Items: []*stripe.SubscriptionItemsParams{
+			{Price: stripe.String(params.PlanID)},
+		},
+		PaymentBehavior: stripe.String("default_incomplete"),
+	}
+	p.AddExpand("latest_invoice.payment_intent")
+
+	for key, value := range params.Metadata {
+		p.AddMetadata(key, value)
+	}
+
+	s, err := g.client.Subscriptions.New(p)
+	if err != nil {
+		return nil, subscriptionError(err)
+	}
+
+	return toSubscription(s), nil
+}
+
+func (g *StripeGateway) GetSubscription(id string) (*Subscription, error) {
+	p := &stripe.SubscriptionParams{}
+	p.AddExpand("latest_invoice.payment_intent")
+
+	s, err := g.client.Subscriptions.Get(id, p)
+	if err != nil {
+		return nil, subscriptionError(err)
+	}
+
+	return toSubscription(s), nil
+}
+
+func (g *StripeGateway) CancelSubscription(id string, atPeriodEnd bool) (*Subscription, error) {
+	var s *stripe.Subscription
+	var err error
+
+	if atPeriodEnd {
+		s, err = g.client.Subscriptions.Update(id, &stripe.SubscriptionParams{CancelAtPeriodEnd: stripe.Bool(true)})
+	} else {
+		s, err = g.client.Subscriptions.Cancel(id, nil)
+	}
+	if err != nil {
+		return nil, subscriptionError(err)
+	}
+
+	return toSubscription(s), nil
+}
+
+// toSubscription folds Stripe's statuses into ours: an unpaid subscription
+// is past due and an expired incomplete one is canceled.
+func toSubscription(s *stripe.Subscription) *Subscription {
+	subscription := &Subscription{
+		ID:                s.ID,
+		Status:            string(s.Status),
+		CurrentPeriodEnd:  time.Unix(s.CurrentPeriodEnd, 0),
+		CancelAtPeriodEnd: s.CancelAtPeriodEnd,
+	}
+
+	switch s.Status {
+	case stripe.SubscriptionStatusUnpaid:
+		subscription.Status = SubscriptionPastDue
+	case stripe.SubscriptionStatusIncompleteExpired:
+		subscription.Status = SubscriptionCanceled
+	case stripe.SubscriptionStatusTrialing:
+		subscription.Status = SubscriptionActive
+	}
+
+	if s.LatestInvoice != nil {
+		subscription.LatestInvoice = &Invoice{
+			ID:     s.LatestInvoice.ID,
+			Amount: s.LatestInvoice.AmountPaid,
+			Paid:   s.LatestInvoice.Paid,
+		}
+		if s.LatestInvoice.PaymentIntent != nil {
+			subscription.ClientSecret = s.LatestInvoice.PaymentIntent.ClientSecret
+		}
+	}
+
+	return subscription
+}
+
+func subscriptionError(err error) error {
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+		return ErrSubscriptionNotFound
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package payments
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	SubscriptionIncomplete = "incomplete"
+	SubscriptionActive     = "active"
+	SubscriptionPastDue    = "past_due"
+	SubscriptionCanceled   = "canceled"
+)
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// PlanParams describe a monthly plan. Amount is in minor units.
+type PlanParams struct {
+	Name     string
+	Amount   int64
+	Currency string
+}
+
+type SubscriptionParams struct {
+	PlanID   string
+	Email    string
+	Metadata map[string]string
+}
+
+// Invoice is one month of a subscription. Amount is what was paid, in minor
+// units.
+type Invoice struct {
+	ID     string
+	Amount int64
+	Paid   bool
+}
+
+// Subscription bills a member every month until it's canceled. ClientSecret
+// confirms the payment of the first invoice when it's still due.
+type Subscription struct {
+	ID                string
+	Status            string
+	ClientSecret      string
+	CurrentPeriodEnd  time.Time
+	CancelAtPeriodEnd bool
+	LatestInvoice     *Invoice
+}
+
+// SubscriptionGateway bills memberships. CancelSubscription stops the billing
+// at the end of the paid period, or right away when atPeriodEnd is false.
+type SubscriptionGateway interface {
+	CreatePlan(params PlanParams) (string, error)
+	CreateSubscription(params SubscriptionParams) (*Subscription, error)
+	GetSubscription(id string) (*Subscription, error)
+	CancelSubscription(id string, atPeriodEnd bool) (*Subscription, error)
+}
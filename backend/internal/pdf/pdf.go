@@ -0,0 +1,105 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// document is an A4 page with the CertiFund header. Text goes through tr,
+// the core fonts only know cp1252.
+type document struct {
+	*gofpdf.Fpdf
+	tr func(string) string
+}
+
+func newDocument(title, subtitle string) *document {
+	f := gofpdf.New("P", "mm", "A4", "")
+	f.SetMargins(15, 15, 15)
+	f.SetAutoPageBreak(true, 15)
+	f.SetTitle(title, true)
+	f.SetCreator("CertiFund", true)
+	f.AddPage()
+
+	d := &document{Fpdf: f, tr: f.UnicodeTranslatorFromDescriptor("")}
+
+	d.SetFont("Helvetica", "B", 20)
+	d.SetTextColor(30, 64, 175)
+	d.CellFormat(0, 10, "CertiFund", "", 1, "L", false, 0, "")
+	d.SetFont("Helvetica", "B", 14)
+	d.SetTextColor(55, 65, 81)
+	d.CellFormat(0, 8, d.tr(title), "", 1, "L", false, 0, "")
+	d.SetFont("Helvetica", "", 10)
+	d.SetTextColor(107, 114, 128)
+	d.CellFormat(0, 6, d.tr(subtitle), "", 1, "L", false, 0, "")
+	d.Ln(4)
+	d.SetTextColor(55, 65, 81)
+
+	return d
+}
+
+// field writes a label and its value on one line.
+func (d *document) field(label, value string) {
+	d.SetFont("Helvetica", "B", 10)
+	d.CellFormat(45, 6, d.tr(label), "", 0, "L", false, 0, "")
+	d.SetFont("Helvetica", "", 10)
+	d.CellFormat(0, 6, d.tr(value), "", 1, "L", false, 0, "")
+}
+
+func (d *document) section(title string) {
+	d.Ln(4)
+	d.SetFont("Helvetica", "B", 12)
+	d.CellFormat(0, 8, d.tr(title), "B", 1, "L", false, 0, "")
+	d.Ln(2)
+}
+
+// table writes a header row and rows. The last column is right aligned, it
+// holds the amounts.
+func (d *document) table(widths []float64, header []string, rows [][]string) {
+	d.SetFont("Helvetica", "B", 9)
+	d.SetFillColor(243, 244, 246)
+	for i, title := range header {
+		d.CellFormat(widths[i], 7, d.tr(title), "1", 0, align(i, len(header)), true, 0, "")
+	}
+	d.Ln(-1)
+
+	d.SetFont("Helvetica", "", 9)
+	for _, row := range rows {
+		for i, value := range row {
+			d.CellFormat(widths[i], 7, d.tr(value), "1", 0, align(i, len(row)), false, 0, "")
+		}
+		d.Ln(-1)
+	}
+}
+
+func (d *document) bytes() ([]byte, error) {
+	d.Ln(8)
+	d.SetFont("Helvetica", "I", 8)
+	d.SetTextColor(107, 114, 128)
+	d.CellFormat(0, 5, fmt.Sprintf("Generated on %s", time.Now().Format("2006-01-02 15:04 MST")), "", 1, "L", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := d.Output(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func align(column, columns int) string {
+	if column == columns-1 {
+		return "R"
+	}
+	return "L"
+}
+
+// minorDA formats an amount in minor units.
+func minorDA(amount int64) string {
+	return fmt.Sprintf("%.2f DA", float64(amount)/100)
+}
+
+func da(amount float64) string {
+	return fmt.Sprintf("%.2f DA", amount)
+}
@@ -0,0 +1,65 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+	"projectx/internal/data"
+)
+
+// Receipt renders the receipt of a backing from its payments, as listed by
+// TablesModel.GetUserBacking, and the rewards pledged for.
+func Receipt(backer *data.User, payments []*data.BackingsTable, rewards []data.Reward) ([]byte, error) {
+	first := payments[0]
+
+	d := newDocument("Backing receipt", fmt.Sprintf("Backing #%d", first.ID))
+
+	d.field("Project:", first.Project)
+	d.field("Backer:", fmt.Sprintf("%s (%s)", backer.Username, backer.Email))
+	d.field("Backed on:", first.CreatedAt.Format("2006-01-02 15:04"))
+
+	d.section("Payments")
+
+	rows := make([][]string, 0, len(payments))
+	var total int64
+	for _, payment := range payments {
+		amount := int64(math.Round(payment.Amount))
+		rows = append(rows, []string{
+			payment.CreatedAt.Format("2006-01-02"),
+			payment.TransactionID,
+			payment.PaymentMethod,
+			payment.Status,
+			minorDA(amount),
+		})
+		total += amount
+	}
+	d.table([]float64{25, 70, 25, 30, 30}, []string{"Date", "Transaction ID", "Method", "Status", "Amount"}, rows)
+
+	d.SetFont("Helvetica", "B", 10)
+	d.CellFormat(150, 8, "Total paid", "", 0, "R", false, 0, "")
+	d.CellFormat(30, 8, minorDA(total), "", 1, "R", false, 0, "")
+
+	d.section("Rewards")
+
+	if len(rewards) == 0 {
+		d.SetFont("Helvetica", "", 10)
+		d.CellFormat(0, 6, "No reward, just support.", "", 1, "L", false, 0, "")
+	} else {
+		rows = make([][]string, 0, len(rewards))
+		for _, reward := range rewards {
+			title := reward.Title
+			if reward.Variant != nil {
+				title = fmt.Sprintf("%s (%s)", title, reward.Variant.Name)
+			}
+
+			kind := "Reward"
+			if reward.IsAddon {
+				kind = "Add-on"
+			}
+
+			rows = append(rows, []string{title, kind, reward.EstimatedDelivery.Format("2006-01"), da(reward.Amount)})
+		}
+		d.table([]float64{95, 25, 30, 30}, []string{"Reward", "Type", "Delivery", "Price"}, rows)
+	}
+
+	return d.bytes()
+}
@@ -0,0 +1,122 @@
+package pdf
+
+import (
+	"fmt"
+	"projectx/internal/data"
+	"time"
+)
+
+type projectTotals struct {
+	pledges        int
+	pledged        int64
+	refunded       int64
+	platformFees   int64
+	processingFees int64
+	paidOut        int64
+}
+
+// Statement renders a creator's statement for the month starting at month,
+// from the ledger entries returned by TablesModel.GetCreatorStatement.
+func Statement(creator *data.User, month time.Time, entries []*data.StatementEntry) ([]byte, error) {
+	d := newDocument("Creator statement", month.Format("January 2006"))
+
+	d.field("Creator:", fmt.Sprintf("%s (%s)", creator.Username, creator.Email))
+	d.field("Period:", fmt.Sprintf("%s to %s", month.Format("2006-01-02"), month.AddDate(0, 1, -1).Format("2006-01-02")))
+
+	if len(entries) == 0 {
+		d.section("Summary")
+		d.SetFont("Helvetica", "", 10)
+		d.CellFormat(0, 6, "Nothing happened on your projects this month.", "", 1, "L", false, 0, "")
+		return d.bytes()
+	}
+
+	ids := []int{}
+	titles := map[int]string{}
+	byProject := map[int][]*data.StatementEntry{}
+	totals := map[int]*projectTotals{}
+
+	for _, entry := range entries {
+		if _, ok := totals[entry.ProjectID]; !ok {
+			ids = append(ids, entry.ProjectID)
+			titles[entry.ProjectID] = entry.Project
+			totals[entry.ProjectID] = &projectTotals{}
+		}
+		byProject[entry.ProjectID] = append(byProject[entry.ProjectID], entry)
+
+		t := totals[entry.ProjectID]
+		switch entry.Type {
+		case "pledge":
+			t.pledges++
+			t.pledged += entry.Amount
+		case "refund":
+			t.refunded += entry.Amount
+		case "fee":
+			if entry.Account == "platform" {
+				t.platformFees += entry.Amount
+			} else {
+				t.processingFees += entry.Amount
+			}
+		case "payout":
+			t.paidOut += entry.Amount
+		}
+	}
+
+	d.section("Summary")
+
+	rows := make([][]string, 0, len(ids))
+	var sum projectTotals
+	for _, id := range ids {
+		t := totals[id]
+		rows = append(rows, []string{
+			titles[id],
+			fmt.Sprint(t.pledges),
+			minorDA(t.pledged),
+			minorDA(t.refunded),
+			minorDA(t.platformFees + t.processingFees),
+			minorDA(t.paidOut),
+		})
+
+		sum.pledges += t.pledges
+		sum.pledged += t.pledged
+		sum.refunded += t.refunded
+		sum.platformFees += t.platformFees
+		sum.processingFees += t.processingFees
+		sum.paidOut += t.paidOut
+	}
+	rows = append(rows, []string{"Total", fmt.Sprint(sum.pledges), minorDA(sum.pledged), minorDA(sum.refunded), minorDA(sum.platformFees + sum.processingFees), minorDA(sum.paidOut)})
+	d.table([]float64{60, 16, 28, 26, 25, 25}, []string{"Project", "Pledges", "Pledged", "Refunded", "Fees", "Paid out"}, rows)
+
+	d.Ln(2)
+	d.field("Platform fees:", minorDA(sum.platformFees))
+	d.field("Processing fees:", minorDA(sum.processingFees))
+	d.field("Net of refunds and fees:", minorDA(sum.pledged-sum.refunded-sum.platformFees-sum.processingFees))
+
+	for _, id := range ids {
+		d.section(titles[id])
+
+		rows = make([][]string, 0, len(byProject[id]))
+		for _, entry := range byProject[id] {
+			rows = append(rows, []string{entry.CreatedAt.Format("2006-01-02"), entryLabel(entry), entry.TransactionID, minorDA(entry.Amount)})
+		}
+		d.table([]float64{25, 35, 90, 30}, []string{"Date", "Type", "Transaction ID", "Amount"}, rows)
+	}
+
+	return d.bytes()
+}
+
+func entryLabel(entry *data.StatementEntry) string {
+	switch entry.Type {
+	case "pledge":
+		return "Pledge"
+	case "refund":
+		return "Refund"
+	case "fee":
+		if entry.Account == "platform" {
+			return "Platform fee"
+		}
+		return "Processing fee"
+	case "payout":
+		return "Payout"
+	}
+	return entry.Type
+}
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_payment_status;
+DROP INDEX IF EXISTS idx_project_settlement;
+ALTER TABLE project DROP COLUMN IF EXISTS settled_at;
+ALTER TABLE project DROP COLUMN IF EXISTS funding_model;
+DROP TYPE IF EXISTS funding_model;
\ No newline at end of file
@@ -0,0 +1,13 @@
+DO $$ BEGIN
+    CREATE TYPE funding_model AS ENUM ('all_or_nothing', 'flexible');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+ALTER TYPE project_status ADD VALUE IF NOT EXISTS 'Failed';
+
+ALTER TABLE project ADD COLUMN IF NOT EXISTS funding_model funding_model NOT NULL DEFAULT 'flexible';
+ALTER TABLE project ADD COLUMN IF NOT EXISTS settled_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS idx_project_settlement ON project (deadline) WHERE settled_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_payment_status ON payment (status);
\ No newline at end of file
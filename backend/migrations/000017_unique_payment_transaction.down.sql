@@ -0,0 +1 @@
+ALTER TABLE payment DROP CONSTRAINT IF EXISTS payment_transaction_id_key;
\ No newline at end of file
@@ -0,0 +1,19 @@
+CREATE TEMP TABLE duplicate_payment AS
+SELECT p.backing_id, p.amount, p.status, b.project_id
+FROM payment p
+INNER JOIN backing b ON b.backing_id = p.backing_id
+WHERE p.payment_id NOT IN (SELECT MIN(payment_id) FROM payment GROUP BY transaction_id);
+
+UPDATE project pr SET current_funding = pr.current_funding - d.total, version = pr.version + 1
+FROM (
+    SELECT project_id, SUM(amount)/100 AS total FROM duplicate_payment
+    WHERE status NOT IN ('failed', 'canceled', 'refunded')
+    GROUP BY project_id
+) d
+WHERE pr.project_id = d.project_id;
+
+DELETE FROM backing WHERE backing_id IN (SELECT backing_id FROM duplicate_payment);
+
+DROP TABLE duplicate_payment;
+
+ALTER TABLE payment ADD CONSTRAINT payment_transaction_id_key UNIQUE (transaction_id);
\ No newline at end of file
@@ -0,0 +1,8 @@
+ALTER TABLE project ADD COLUMN IF NOT EXISTS current_funding DECIMAL NOT NULL DEFAULT 0;
+
+UPDATE project SET current_funding = project_funding(project_id);
+
+DROP FUNCTION IF EXISTS project_funding(bigint);
+DROP TABLE IF EXISTS funding_ledger;
+DROP FUNCTION IF EXISTS funding_ledger_append_only();
+DROP TYPE IF EXISTS ledger_entry_type;
\ No newline at end of file
@@ -0,0 +1,58 @@
+DO $$ BEGIN
+    CREATE TYPE ledger_entry_type AS ENUM ('pledge', 'refund', 'capture', 'fee', 'payout');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+CREATE TABLE IF NOT EXISTS funding_ledger (
+    entry_id bigserial PRIMARY KEY,
+    project_id bigint NOT NULL REFERENCES project ON DELETE RESTRICT,
+    payment_id bigint REFERENCES payment ON DELETE SET NULL,
+    entry_type ledger_entry_type NOT NULL,
+    debit_account text NOT NULL,
+    credit_account text NOT NULL,
+    amount bigint NOT NULL CHECK (amount > 0),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CHECK (debit_account <> credit_account)
+);
+
+CREATE INDEX IF NOT EXISTS idx_funding_ledger_project ON funding_ledger (project_id);
+CREATE INDEX IF NOT EXISTS idx_funding_ledger_payment ON funding_ledger (payment_id);
+
+CREATE OR REPLACE FUNCTION funding_ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'funding_ledger entries cannot be modified or deleted';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS funding_ledger_append_only ON funding_ledger;
+CREATE TRIGGER funding_ledger_append_only
+BEFORE UPDATE OF project_id, entry_type, debit_account, credit_account, amount ON funding_ledger
+FOR EACH ROW EXECUTE FUNCTION funding_ledger_append_only();
+
+DROP TRIGGER IF EXISTS funding_ledger_no_delete ON funding_ledger;
+CREATE TRIGGER funding_ledger_no_delete
+BEFORE DELETE ON funding_ledger
+FOR EACH ROW EXECUTE FUNCTION funding_ledger_append_only();
+
+CREATE OR REPLACE FUNCTION project_funding(id bigint) RETURNS DECIMAL AS $$
+    SELECT COALESCE(SUM(CASE entry_type WHEN 'pledge' THEN amount WHEN 'refund' THEN -amount ELSE 0 END), 0)::DECIMAL / 100
+    FROM funding_ledger
+    WHERE project_id = id
+$$ LANGUAGE sql STABLE;
+
+INSERT INTO funding_ledger (project_id, payment_id, entry_type, debit_account, credit_account, amount, created_at)
+SELECT b.project_id, pa.payment_id, 'pledge',
+    CASE WHEN pa.status = 'requires_capture' THEN 'project_held' ELSE 'project' END,
+    'backer', ROUND(pa.amount), pa.created_at
+FROM payment pa
+INNER JOIN backing b ON b.backing_id = pa.backing_id
+WHERE pa.status NOT IN ('failed', 'canceled') AND pa.amount > 0;
+
+INSERT INTO funding_ledger (project_id, payment_id, entry_type, debit_account, credit_account, amount, created_at)
+SELECT b.project_id, pa.payment_id, 'refund', 'backer', 'project', ROUND(pa.amount), pa.updated_at
+FROM payment pa
+INNER JOIN backing b ON b.backing_id = pa.backing_id
+WHERE pa.status = 'refunded' AND pa.amount > 0;
+
+ALTER TABLE project DROP COLUMN IF EXISTS current_funding;
\ No newline at end of file
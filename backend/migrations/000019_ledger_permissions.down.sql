@@ -0,0 +1,2 @@
+DELETE FROM role_permission WHERE permission_id IN (43, 44);
+DELETE FROM permission WHERE permission_id IN (43, 44);
\ No newline at end of file
@@ -0,0 +1,7 @@
+INSERT INTO permission (permission_id, permission_name) VALUES
+(43, 'ledger:read'),
+(44, 'ledger:repair')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permission (role_id, permission_id) VALUES (1, 43), (1, 44)
+ON CONFLICT DO NOTHING;
\ No newline at end of file
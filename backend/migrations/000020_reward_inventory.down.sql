@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS reward_reservation;
+DROP INDEX IF EXISTS idx_backing_reward_reward;
+ALTER TABLE reward DROP COLUMN IF EXISTS quantity_limit;
\ No newline at end of file
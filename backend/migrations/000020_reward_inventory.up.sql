@@ -0,0 +1,18 @@
+ALTER TABLE reward ADD COLUMN IF NOT EXISTS quantity_limit integer CHECK (quantity_limit > 0);
+
+UPDATE reward r SET is_available = FALSE
+WHERE r.quantity_limit IS NOT NULL
+AND r.quantity_limit <= (SELECT COUNT(*) FROM backing_reward br WHERE br.reward_id = r.reward_id);
+
+CREATE TABLE IF NOT EXISTS reward_reservation (
+    reservation_id bigserial PRIMARY KEY,
+    reward_id bigint NOT NULL REFERENCES reward ON DELETE CASCADE,
+    backer_id bigint NOT NULL REFERENCES user_t ON DELETE CASCADE,
+    transaction_id text NOT NULL,
+    expires_at timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (transaction_id, reward_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reward_reservation_reward ON reward_reservation (reward_id, expires_at);
+CREATE INDEX IF NOT EXISTS idx_backing_reward_reward ON backing_reward (reward_id);
\ No newline at end of file
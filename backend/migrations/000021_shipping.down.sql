@@ -0,0 +1,7 @@
+ALTER TABLE backing_reward
+    DROP COLUMN IF EXISTS address_id,
+    DROP COLUMN IF EXISTS shipping_address,
+    DROP COLUMN IF EXISTS shipping_fee;
+
+DROP TABLE IF EXISTS shipping_address;
+DROP TABLE IF EXISTS reward_shipping;
\ No newline at end of file
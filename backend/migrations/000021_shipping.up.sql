@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS reward_shipping (
+    reward_id bigint NOT NULL REFERENCES reward ON DELETE CASCADE,
+    zone text NOT NULL,
+    cost DECIMAL NOT NULL CHECK (cost >= 0),
+    PRIMARY KEY (reward_id, zone)
+);
+
+CREATE TABLE IF NOT EXISTS shipping_address (
+    address_id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES user_t ON DELETE CASCADE,
+    full_name text NOT NULL,
+    line1 text NOT NULL,
+    line2 text NOT NULL DEFAULT '',
+    city text NOT NULL,
+    region text NOT NULL DEFAULT '',
+    postal_code text NOT NULL DEFAULT '',
+    country char(2) NOT NULL,
+    phone text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS idx_shipping_address_user ON shipping_address (user_id);
+
+ALTER TABLE backing_reward
+    ADD COLUMN IF NOT EXISTS address_id bigint REFERENCES shipping_address ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS shipping_address jsonb,
+    ADD COLUMN IF NOT EXISTS shipping_fee DECIMAL;
\ No newline at end of file
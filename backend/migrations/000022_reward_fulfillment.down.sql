@@ -0,0 +1,12 @@
+DELETE FROM role_permission WHERE permission_id = 45;
+DELETE FROM permission WHERE permission_id = 45;
+
+DROP TABLE IF EXISTS fulfillment_event;
+
+ALTER TABLE backing_reward
+    DROP COLUMN IF EXISTS fulfillment_status,
+    DROP COLUMN IF EXISTS carrier,
+    DROP COLUMN IF EXISTS tracking_number,
+    DROP COLUMN IF EXISTS fulfillment_updated_at;
+
+DROP TYPE IF EXISTS fulfillment_status;
\ No newline at end of file
@@ -0,0 +1,32 @@
+DO $$ BEGIN
+    CREATE TYPE fulfillment_status AS ENUM ('pending', 'in_production', 'shipped', 'delivered', 'failed');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+ALTER TABLE backing_reward
+    ADD COLUMN IF NOT EXISTS fulfillment_status fulfillment_status NOT NULL DEFAULT 'pending',
+    ADD COLUMN IF NOT EXISTS carrier text,
+    ADD COLUMN IF NOT EXISTS tracking_number text,
+    ADD COLUMN IF NOT EXISTS fulfillment_updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+CREATE TABLE IF NOT EXISTS fulfillment_event (
+    event_id bigserial PRIMARY KEY,
+    backing_id bigint NOT NULL,
+    reward_id bigint NOT NULL,
+    status fulfillment_status NOT NULL,
+    carrier text,
+    tracking_number text,
+    changed_by bigint REFERENCES user_t ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (backing_id, reward_id) REFERENCES backing_reward ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_fulfillment_event_backing ON fulfillment_event (backing_id, reward_id, created_at);
+
+INSERT INTO permission (permission_id, permission_name) VALUES
+(45, 'fulfillment:update')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permission (role_id, permission_id) VALUES (1, 45), (3, 45)
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,7 @@
+DELETE FROM role_permission WHERE permission_id IN (46, 47);
+DELETE FROM permission WHERE permission_id IN (46, 47);
+
+ALTER TABLE funding_ledger DROP COLUMN IF EXISTS payout_id;
+
+DROP TABLE IF EXISTS payout;
+DROP TYPE IF EXISTS payout_status;
\ No newline at end of file
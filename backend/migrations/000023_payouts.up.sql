@@ -0,0 +1,37 @@
+DO $$ BEGIN
+    CREATE TYPE payout_status AS ENUM ('pending', 'scheduled', 'paid', 'failed');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+CREATE TABLE IF NOT EXISTS payout (
+    payout_id bigserial PRIMARY KEY,
+    project_id bigint NOT NULL REFERENCES project ON DELETE CASCADE,
+    creator_id bigint NOT NULL REFERENCES user_t ON DELETE CASCADE,
+    gross bigint NOT NULL CHECK (gross > 0),
+    platform_fee bigint NOT NULL CHECK (platform_fee >= 0),
+    processing_fee bigint NOT NULL CHECK (processing_fee >= 0),
+    net bigint NOT NULL CHECK (net > 0),
+    status payout_status NOT NULL DEFAULT 'pending',
+    approved_by bigint REFERENCES user_t ON DELETE SET NULL,
+    scheduled_at timestamp(0) with time zone,
+    paid_at timestamp(0) with time zone,
+    failure_reason text,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    CHECK (gross = platform_fee + processing_fee + net)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payout_open_project ON payout (project_id) WHERE status IN ('pending', 'scheduled');
+CREATE INDEX IF NOT EXISTS idx_payout_creator ON payout (creator_id);
+
+ALTER TABLE funding_ledger ADD COLUMN IF NOT EXISTS payout_id bigint REFERENCES payout ON DELETE SET NULL;
+
+INSERT INTO permission (permission_id, permission_name) VALUES
+(46, 'payouts:read'),
+(47, 'payouts:approve')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permission (role_id, permission_id) VALUES (1, 46), (1, 47)
+ON CONFLICT DO NOTHING;
\ No newline at end of file
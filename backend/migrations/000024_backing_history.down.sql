@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_payment_backing;
+ALTER TABLE payment ADD CONSTRAINT payment_backing_id_key UNIQUE (backing_id);
+DROP TABLE IF EXISTS backing_history;
\ No newline at end of file
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS backing_history (
+    history_id bigserial PRIMARY KEY,
+    backing_id bigint NOT NULL REFERENCES backing ON DELETE CASCADE,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'applied', 'canceled')),
+    old_amount DECIMAL NOT NULL,
+    new_amount DECIMAL NOT NULL,
+    old_rewards bigint[] NOT NULL DEFAULT '{}',
+    new_rewards bigint[] NOT NULL DEFAULT '{}',
+    address_id bigint REFERENCES shipping_address ON DELETE SET NULL,
+    transaction_id text,
+    refund_ids text[] NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    applied_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_backing_history_backing ON backing_history (backing_id, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_backing_history_transaction ON backing_history (transaction_id) WHERE transaction_id IS NOT NULL;
+
+ALTER TABLE payment DROP CONSTRAINT IF EXISTS payment_backing_id_key;
+CREATE INDEX IF NOT EXISTS idx_payment_backing ON payment (backing_id);
\ No newline at end of file
@@ -0,0 +1,18 @@
+DELETE FROM role_permission WHERE permission_id = 48;
+DELETE FROM permission WHERE permission_id = 48;
+
+DROP INDEX IF EXISTS idx_cancellation_refund;
+DROP INDEX IF EXISTS idx_cancellation_payment;
+
+ALTER TABLE cancellation
+    DROP COLUMN IF EXISTS refunded_by,
+    DROP COLUMN IF EXISTS refund_id,
+    DROP COLUMN IF EXISTS category,
+    DROP COLUMN IF EXISTS amount,
+    DROP COLUMN IF EXISTS payment_id;
+
+UPDATE payment SET status = 'refunded' WHERE status = 'partially_refunded';
+
+ALTER TABLE payment DROP COLUMN IF EXISTS refunded_amount;
+
+DROP TYPE IF EXISTS refund_category;
\ No newline at end of file
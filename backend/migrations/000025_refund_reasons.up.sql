@@ -0,0 +1,26 @@
+DO $$ BEGIN
+    CREATE TYPE refund_category AS ENUM ('fraud', 'non_delivery', 'duplicate', 'goodwill', 'backer_request', 'payment_provider');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+ALTER TABLE payment ADD COLUMN IF NOT EXISTS refunded_amount DECIMAL NOT NULL DEFAULT 0;
+
+UPDATE payment SET refunded_amount = amount WHERE status = 'refunded';
+
+ALTER TABLE cancellation
+    ADD COLUMN IF NOT EXISTS payment_id bigint REFERENCES payment ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS amount DECIMAL,
+    ADD COLUMN IF NOT EXISTS category refund_category NOT NULL DEFAULT 'backer_request',
+    ADD COLUMN IF NOT EXISTS refund_id text,
+    ADD COLUMN IF NOT EXISTS refunded_by bigint REFERENCES user_t ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_cancellation_payment ON cancellation (payment_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cancellation_refund ON cancellation (refund_id) WHERE refund_id IS NOT NULL;
+
+INSERT INTO permission (permission_id, permission_name) VALUES
+(48, 'backings:refund')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permission (role_id, permission_id) VALUES (1, 48)
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS refund_policy;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS refund_policy (
+    project_id bigint PRIMARY KEY REFERENCES project ON DELETE CASCADE,
+    dispute_only boolean NOT NULL DEFAULT FALSE,
+    final_hours_locked integer NOT NULL DEFAULT 48 CHECK (final_hours_locked BETWEEN 0 AND 168),
+    allow_after_success boolean NOT NULL DEFAULT FALSE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TRIGGER update_refund_policy_modtime
+BEFORE UPDATE ON refund_policy
+FOR EACH ROW
+EXECUTE FUNCTION update_modified_column();
\ No newline at end of file
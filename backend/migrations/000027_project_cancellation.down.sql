@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS project_cancellation;
+
+ALTER TABLE project DROP COLUMN IF EXISTS deleted_at;
+
+DROP TYPE IF EXISTS project_cancellation_type;
\ No newline at end of file
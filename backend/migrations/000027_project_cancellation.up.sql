@@ -0,0 +1,23 @@
+DO $$ BEGIN
+    CREATE TYPE project_cancellation_type AS ENUM ('creator', 'takedown', 'failed_goal');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+ALTER TYPE project_status ADD VALUE IF NOT EXISTS 'Cancelled';
+ALTER TYPE refund_category ADD VALUE IF NOT EXISTS 'project_cancelled';
+
+ALTER TABLE project ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE TABLE IF NOT EXISTS project_cancellation (
+    project_id bigint PRIMARY KEY REFERENCES project ON DELETE CASCADE,
+    type project_cancellation_type NOT NULL,
+    reason text,
+    requested_by bigint REFERENCES user_t ON DELETE SET NULL,
+    refunded_count integer NOT NULL DEFAULT 0,
+    failed_count integer NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    finished_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_cancellation_unfinished ON project_cancellation (created_at) WHERE finished_at IS NULL;
\ No newline at end of file
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_backing_flagged;
+DROP INDEX IF EXISTS idx_backing_backer;
+DROP INDEX IF EXISTS idx_backing_ip;
+
+ALTER TABLE backing
+    DROP COLUMN IF EXISTS flagged_for_review,
+    DROP COLUMN IF EXISTS fraud_signals,
+    DROP COLUMN IF EXISTS fraud_score,
+    DROP COLUMN IF EXISTS ip_address;
\ No newline at end of file
@@ -0,0 +1,9 @@
+ALTER TABLE backing
+    ADD COLUMN IF NOT EXISTS ip_address text,
+    ADD COLUMN IF NOT EXISTS fraud_score integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS fraud_signals text[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS flagged_for_review boolean NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_backing_ip ON backing (ip_address, created_at) WHERE ip_address IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_backing_backer ON backing (backer_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_backing_flagged ON backing (created_at) WHERE flagged_for_review;
\ No newline at end of file
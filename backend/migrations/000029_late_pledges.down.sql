@@ -0,0 +1,15 @@
+DROP FUNCTION IF EXISTS project_late_funding(bigint);
+
+CREATE OR REPLACE FUNCTION project_funding(id bigint) RETURNS DECIMAL AS $$
+    SELECT COALESCE(SUM(CASE entry_type WHEN 'pledge' THEN amount WHEN 'refund' THEN -amount ELSE 0 END), 0)::DECIMAL / 100
+    FROM funding_ledger
+    WHERE project_id = id
+$$ LANGUAGE sql STABLE;
+
+DROP INDEX IF EXISTS idx_project_late_pledges;
+
+ALTER TABLE backing DROP COLUMN IF EXISTS is_late;
+ALTER TABLE reward DROP COLUMN IF EXISTS late_amount;
+ALTER TABLE project
+    DROP COLUMN IF EXISTS late_pledges_until,
+    DROP COLUMN IF EXISTS late_pledges;
\ No newline at end of file
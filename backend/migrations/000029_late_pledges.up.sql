@@ -0,0 +1,25 @@
+ALTER TABLE project
+    ADD COLUMN IF NOT EXISTS late_pledges boolean NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS late_pledges_until timestamp(0) with time zone;
+
+ALTER TABLE reward ADD COLUMN IF NOT EXISTS late_amount DECIMAL CHECK (late_amount > 0);
+
+ALTER TABLE backing ADD COLUMN IF NOT EXISTS is_late boolean NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_project_late_pledges ON project (project_id) WHERE late_pledges;
+
+CREATE OR REPLACE FUNCTION project_funding(id bigint) RETURNS DECIMAL AS $$
+    SELECT COALESCE(SUM(CASE l.entry_type WHEN 'pledge' THEN l.amount WHEN 'refund' THEN -l.amount ELSE 0 END), 0)::DECIMAL / 100
+    FROM funding_ledger l
+    LEFT JOIN payment pa ON pa.payment_id = l.payment_id
+    LEFT JOIN backing b ON b.backing_id = pa.backing_id
+    WHERE l.project_id = id AND NOT COALESCE(b.is_late, FALSE)
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION project_late_funding(id bigint) RETURNS DECIMAL AS $$
+    SELECT COALESCE(SUM(CASE l.entry_type WHEN 'pledge' THEN l.amount WHEN 'refund' THEN -l.amount ELSE 0 END), 0)::DECIMAL / 100
+    FROM funding_ledger l
+    INNER JOIN payment pa ON pa.payment_id = l.payment_id
+    INNER JOIN backing b ON b.backing_id = pa.backing_id
+    WHERE l.project_id = id AND b.is_late
+$$ LANGUAGE sql STABLE;
\ No newline at end of file
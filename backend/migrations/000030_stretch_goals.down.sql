@@ -0,0 +1 @@
+DROP TABLE IF EXISTS stretch_goal;
\ No newline at end of file
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS stretch_goal (
+    stretch_goal_id bigserial PRIMARY KEY,
+    project_id bigint NOT NULL REFERENCES project ON DELETE CASCADE,
+    amount DECIMAL NOT NULL CHECK (amount > 0),
+    title text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    unlocked_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (project_id, amount)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stretch_goal_locked ON stretch_goal (project_id) WHERE unlocked_at IS NULL;
+
+CREATE TRIGGER update_stretch_goal_modtime
+BEFORE UPDATE ON stretch_goal
+FOR EACH ROW
+EXECUTE FUNCTION update_modified_column();
\ No newline at end of file
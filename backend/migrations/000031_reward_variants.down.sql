@@ -0,0 +1,11 @@
+ALTER TABLE backing_history DROP COLUMN IF EXISTS new_variants;
+
+DROP INDEX IF EXISTS idx_backing_reward_variant;
+DROP INDEX IF EXISTS idx_reward_reservation_variant;
+
+ALTER TABLE backing_reward DROP COLUMN IF EXISTS variant_id;
+ALTER TABLE reward_reservation DROP COLUMN IF EXISTS variant_id;
+
+DROP TABLE IF EXISTS reward_variant;
+
+ALTER TABLE reward DROP COLUMN IF EXISTS is_addon;
\ No newline at end of file
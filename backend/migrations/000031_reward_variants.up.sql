@@ -0,0 +1,28 @@
+ALTER TABLE reward ADD COLUMN IF NOT EXISTS is_addon boolean NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS reward_variant (
+    variant_id bigserial PRIMARY KEY,
+    reward_id bigint NOT NULL REFERENCES reward ON DELETE CASCADE,
+    name text NOT NULL,
+    options jsonb NOT NULL DEFAULT '{}',
+    quantity_limit integer CHECK (quantity_limit > 0),
+    is_available boolean NOT NULL DEFAULT TRUE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (reward_id, name)
+);
+
+CREATE TRIGGER update_reward_variant_modtime
+BEFORE UPDATE ON reward_variant
+FOR EACH ROW
+EXECUTE FUNCTION update_modified_column();
+
+ALTER TABLE reward_reservation ADD COLUMN IF NOT EXISTS variant_id bigint REFERENCES reward_variant ON DELETE CASCADE;
+
+ALTER TABLE backing_reward ADD COLUMN IF NOT EXISTS variant_id bigint REFERENCES reward_variant ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_reward_reservation_variant ON reward_reservation (variant_id, expires_at) WHERE variant_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_backing_reward_variant ON backing_reward (variant_id) WHERE variant_id IS NOT NULL;
+
+ALTER TABLE backing_history ADD COLUMN IF NOT EXISTS new_variants jsonb NOT NULL DEFAULT '{}';
\ No newline at end of file
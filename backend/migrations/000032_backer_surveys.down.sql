@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS survey_answer;
+DROP TABLE IF EXISTS survey_recipient;
+DROP TABLE IF EXISTS survey_question;
+DROP TABLE IF EXISTS survey;
+DROP TYPE IF EXISTS survey_question_type;
\ No newline at end of file
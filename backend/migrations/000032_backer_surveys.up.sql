@@ -0,0 +1,63 @@
+DO $$ BEGIN
+    CREATE TYPE survey_question_type AS ENUM ('text', 'choice', 'address');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+CREATE TABLE IF NOT EXISTS survey (
+    survey_id bigserial PRIMARY KEY,
+    project_id bigint NOT NULL UNIQUE REFERENCES project ON DELETE CASCADE,
+    title text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    sent_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TRIGGER update_survey_modtime
+BEFORE UPDATE ON survey
+FOR EACH ROW
+EXECUTE FUNCTION update_modified_column();
+
+CREATE TABLE IF NOT EXISTS survey_question (
+    question_id bigserial PRIMARY KEY,
+    survey_id bigint NOT NULL REFERENCES survey ON DELETE CASCADE,
+    reward_id bigint REFERENCES reward ON DELETE CASCADE,
+    type survey_question_type NOT NULL,
+    prompt text NOT NULL,
+    choices text[] NOT NULL DEFAULT '{}',
+    required boolean NOT NULL DEFAULT TRUE,
+    position integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_survey_question_survey ON survey_question (survey_id, position);
+
+CREATE TABLE IF NOT EXISTS survey_recipient (
+    survey_id bigint NOT NULL REFERENCES survey ON DELETE CASCADE,
+    backing_id bigint NOT NULL REFERENCES backing ON DELETE CASCADE,
+    sent_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    reminded_at timestamp(0) with time zone,
+    reminder_count integer NOT NULL DEFAULT 0,
+    completed_at timestamp(0) with time zone,
+    PRIMARY KEY (survey_id, backing_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_survey_recipient_pending ON survey_recipient (sent_at) WHERE completed_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS survey_answer (
+    survey_id bigint NOT NULL,
+    backing_id bigint NOT NULL,
+    question_id bigint NOT NULL REFERENCES survey_question ON DELETE CASCADE,
+    answer text NOT NULL DEFAULT '',
+    address jsonb,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (backing_id, question_id),
+    FOREIGN KEY (survey_id, backing_id) REFERENCES survey_recipient ON DELETE CASCADE
+);
+
+CREATE TRIGGER update_survey_answer_modtime
+BEFORE UPDATE ON survey_answer
+FOR EACH ROW
+EXECUTE FUNCTION update_modified_column();
\ No newline at end of file
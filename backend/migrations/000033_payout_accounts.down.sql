@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS payout_account;
+
+DROP TYPE IF EXISTS payout_account_status;
\ No newline at end of file
@@ -0,0 +1,28 @@
+DO $$ BEGIN
+    CREATE TYPE payout_account_status AS ENUM ('pending', 'verified', 'rejected');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+CREATE TABLE IF NOT EXISTS payout_account (
+    user_id bigint PRIMARY KEY REFERENCES user_t ON DELETE CASCADE,
+    provider_account_id text NOT NULL UNIQUE,
+    legal_name text NOT NULL,
+    country char(2) NOT NULL,
+    currency char(3) NOT NULL,
+    account_holder_name text NOT NULL,
+    account_number_encrypted text NOT NULL,
+    routing_number_encrypted text NOT NULL,
+    account_last4 text NOT NULL,
+    status payout_account_status NOT NULL DEFAULT 'pending',
+    status_reason text NOT NULL DEFAULT '',
+    verified_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TRIGGER update_payout_account_modtime
+BEFORE UPDATE ON payout_account
+FOR EACH ROW
+EXECUTE FUNCTION update_modified_column();
\ No newline at end of file
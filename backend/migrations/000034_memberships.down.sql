@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS membership_payment;
+DROP TABLE IF EXISTS membership;
+DROP TABLE IF EXISTS membership_tier;
+
+DROP TYPE IF EXISTS membership_status;
+
+ALTER TABLE project_update DROP COLUMN IF EXISTS members_only;
\ No newline at end of file
@@ -0,0 +1,60 @@
+ALTER TABLE project_update ADD COLUMN IF NOT EXISTS members_only boolean NOT NULL DEFAULT FALSE;
+
+DO $$ BEGIN
+    CREATE TYPE membership_status AS ENUM ('incomplete', 'active', 'past_due', 'cancelled');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+CREATE TABLE IF NOT EXISTS membership_tier (
+    tier_id bigserial PRIMARY KEY,
+    creator_id bigint NOT NULL REFERENCES user_t ON DELETE CASCADE,
+    title text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    amount DECIMAL NOT NULL CHECK (amount > 0),
+    plan_id text NOT NULL,
+    is_active boolean NOT NULL DEFAULT TRUE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS idx_membership_tier_creator ON membership_tier (creator_id);
+
+CREATE TRIGGER update_membership_tier_modtime
+BEFORE UPDATE ON membership_tier
+FOR EACH ROW
+EXECUTE FUNCTION update_modified_column();
+
+CREATE TABLE IF NOT EXISTS membership (
+    membership_id bigserial PRIMARY KEY,
+    tier_id bigint NOT NULL REFERENCES membership_tier ON DELETE CASCADE,
+    member_id bigint NOT NULL REFERENCES user_t ON DELETE CASCADE,
+    subscription_id text NOT NULL UNIQUE,
+    status membership_status NOT NULL DEFAULT 'incomplete',
+    current_period_end timestamp(0) with time zone,
+    cancel_at_period_end boolean NOT NULL DEFAULT FALSE,
+    past_due_since timestamp(0) with time zone,
+    cancelled_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_membership_current ON membership (tier_id, member_id) WHERE status <> 'cancelled';
+CREATE INDEX IF NOT EXISTS idx_membership_member ON membership (member_id);
+
+CREATE TRIGGER update_membership_modtime
+BEFORE UPDATE ON membership
+FOR EACH ROW
+EXECUTE FUNCTION update_modified_column();
+
+CREATE TABLE IF NOT EXISTS membership_payment (
+    membership_payment_id bigserial PRIMARY KEY,
+    membership_id bigint NOT NULL REFERENCES membership ON DELETE CASCADE,
+    invoice_id text NOT NULL UNIQUE,
+    amount bigint NOT NULL CHECK (amount >= 0),
+    paid_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_membership_payment_membership ON membership_payment (membership_id);
\ No newline at end of file
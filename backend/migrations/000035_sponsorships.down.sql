@@ -0,0 +1,10 @@
+DELETE FROM role_permission WHERE permission_id = 49;
+DELETE FROM permission WHERE permission_id = 49;
+
+DROP FUNCTION IF EXISTS project_matched_funding(bigint);
+
+DROP TABLE IF EXISTS sponsor_match;
+DROP FUNCTION IF EXISTS sponsor_match_append_only();
+DROP TYPE IF EXISTS sponsor_match_type;
+
+DROP TABLE IF EXISTS sponsorship;
\ No newline at end of file
@@ -0,0 +1,67 @@
+CREATE TABLE IF NOT EXISTS sponsorship (
+    sponsorship_id bigserial PRIMARY KEY,
+    sponsor_name text NOT NULL,
+    project_id bigint REFERENCES project ON DELETE CASCADE,
+    category text,
+    cap bigint NOT NULL CHECK (cap > 0),
+    matched bigint NOT NULL DEFAULT 0,
+    is_active boolean NOT NULL DEFAULT TRUE,
+    ends_at timestamp(0) with time zone,
+    created_by bigint REFERENCES user_t ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    CHECK ((project_id IS NULL) <> (category IS NULL)),
+    CHECK (matched >= 0 AND matched <= cap)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sponsorship_project ON sponsorship (project_id) WHERE is_active;
+CREATE INDEX IF NOT EXISTS idx_sponsorship_category ON sponsorship (category) WHERE is_active;
+
+CREATE TRIGGER update_sponsorship_modtime
+BEFORE UPDATE ON sponsorship
+FOR EACH ROW
+EXECUTE FUNCTION update_modified_column();
+
+DO $$ BEGIN
+    CREATE TYPE sponsor_match_type AS ENUM ('match', 'reversal');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+CREATE TABLE IF NOT EXISTS sponsor_match (
+    match_id bigserial PRIMARY KEY,
+    sponsorship_id bigint NOT NULL REFERENCES sponsorship ON DELETE CASCADE,
+    project_id bigint NOT NULL REFERENCES project ON DELETE CASCADE,
+    payment_id bigint REFERENCES payment ON DELETE SET NULL,
+    entry_type sponsor_match_type NOT NULL,
+    amount bigint NOT NULL CHECK (amount > 0),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_sponsor_match_project ON sponsor_match (project_id);
+CREATE INDEX IF NOT EXISTS idx_sponsor_match_payment ON sponsor_match (payment_id);
+
+CREATE OR REPLACE FUNCTION sponsor_match_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'sponsor_match entries cannot be modified';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS sponsor_match_append_only ON sponsor_match;
+CREATE TRIGGER sponsor_match_append_only
+BEFORE UPDATE OF sponsorship_id, project_id, entry_type, amount ON sponsor_match
+FOR EACH ROW EXECUTE FUNCTION sponsor_match_append_only();
+
+CREATE OR REPLACE FUNCTION project_matched_funding(id bigint) RETURNS DECIMAL AS $$
+    SELECT COALESCE(SUM(CASE entry_type WHEN 'match' THEN amount ELSE -amount END), 0)::DECIMAL / 100
+    FROM sponsor_match
+    WHERE project_id = id
+$$ LANGUAGE sql STABLE;
+
+INSERT INTO permission (permission_id, permission_name) VALUES
+(49, 'sponsorships:manage')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permission (role_id, permission_id) VALUES (1, 49)
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS promo_redemption;
+DROP TABLE IF EXISTS promo_code_reward;
+DROP TABLE IF EXISTS promo_code;
+
+DROP TYPE IF EXISTS promo_discount_type;
\ No newline at end of file
@@ -0,0 +1,48 @@
+DO $$ BEGIN
+    CREATE TYPE promo_discount_type AS ENUM ('percentage', 'fixed');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+CREATE TABLE IF NOT EXISTS promo_code (
+    promo_code_id bigserial PRIMARY KEY,
+    project_id bigint NOT NULL REFERENCES project ON DELETE CASCADE,
+    code text NOT NULL,
+    discount_type promo_discount_type NOT NULL,
+    discount_value DECIMAL NOT NULL CHECK (discount_value > 0),
+    max_redemptions integer CHECK (max_redemptions > 0),
+    starts_at timestamp(0) with time zone,
+    ends_at timestamp(0) with time zone,
+    is_active boolean NOT NULL DEFAULT TRUE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (project_id, code),
+    CHECK (discount_type <> 'percentage' OR discount_value <= 100),
+    CHECK (ends_at IS NULL OR starts_at IS NULL OR ends_at > starts_at)
+);
+
+CREATE TRIGGER update_promo_code_modtime
+BEFORE UPDATE ON promo_code
+FOR EACH ROW
+EXECUTE FUNCTION update_modified_column();
+
+CREATE TABLE IF NOT EXISTS promo_code_reward (
+    promo_code_id bigint NOT NULL REFERENCES promo_code ON DELETE CASCADE,
+    reward_id bigint NOT NULL REFERENCES reward ON DELETE CASCADE,
+    PRIMARY KEY (promo_code_id, reward_id)
+);
+
+CREATE TABLE IF NOT EXISTS promo_redemption (
+    redemption_id bigserial PRIMARY KEY,
+    promo_code_id bigint NOT NULL REFERENCES promo_code ON DELETE CASCADE,
+    backer_id bigint NOT NULL REFERENCES user_t ON DELETE CASCADE,
+    transaction_id text NOT NULL UNIQUE,
+    backing_id bigint REFERENCES backing ON DELETE SET NULL,
+    discount DECIMAL NOT NULL CHECK (discount > 0),
+    expires_at timestamp(0) with time zone NOT NULL,
+    redeemed_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_promo_redemption_code ON promo_redemption (promo_code_id, expires_at);
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE reward_variant DROP COLUMN IF EXISTS is_retired;
+ALTER TABLE reward DROP COLUMN IF EXISTS is_retired;
\ No newline at end of file
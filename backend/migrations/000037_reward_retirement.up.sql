@@ -0,0 +1,10 @@
+ALTER TABLE reward ADD COLUMN IF NOT EXISTS is_retired boolean NOT NULL DEFAULT FALSE;
+ALTER TABLE reward_variant ADD COLUMN IF NOT EXISTS is_retired boolean NOT NULL DEFAULT FALSE;
+
+UPDATE reward r SET is_retired = TRUE
+WHERE NOT r.is_available
+AND (r.quantity_limit IS NULL OR r.quantity_limit > (SELECT COUNT(*) FROM backing_reward br WHERE br.reward_id = r.reward_id));
+
+UPDATE reward_variant rv SET is_retired = TRUE
+WHERE NOT rv.is_available
+AND (rv.quantity_limit IS NULL OR rv.quantity_limit > (SELECT COUNT(*) FROM backing_reward br WHERE br.variant_id = rv.variant_id));
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_backing_history_adjustments;
+DROP INDEX IF EXISTS idx_backing_history_pending;
+
+ALTER TABLE backing_history DROP COLUMN IF EXISTS adjustments;
\ No newline at end of file
@@ -0,0 +1,8 @@
+ALTER TABLE backing_history ADD COLUMN IF NOT EXISTS adjustments jsonb NOT NULL DEFAULT '[]';
+
+UPDATE backing_history h SET status = 'canceled'
+WHERE h.status = 'pending'
+AND EXISTS (SELECT 1 FROM backing_history n WHERE n.backing_id = h.backing_id AND n.status = 'pending' AND n.history_id > h.history_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_backing_history_pending ON backing_history (backing_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_backing_history_adjustments ON backing_history USING gin (adjustments) WHERE status = 'pending';
\ No newline at end of file
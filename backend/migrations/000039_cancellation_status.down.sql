@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_cancellation_pending;
+
+ALTER TABLE cancellation DROP COLUMN IF EXISTS status;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE cancellation ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'completed' CHECK (status IN ('pending', 'completed', 'failed'));
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cancellation_pending ON cancellation (payment_id) WHERE status = 'pending';
\ No newline at end of file
@@ -0,0 +1,2 @@
+DELETE FROM role_permission WHERE permission_id IN (50, 51);
+DELETE FROM permission WHERE permission_id IN (50, 51);
\ No newline at end of file
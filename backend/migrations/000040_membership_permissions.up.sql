@@ -0,0 +1,7 @@
+INSERT INTO permission (permission_id, permission_name) VALUES
+(50, 'memberships:manage'),
+(51, 'memberships:join')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permission (role_id, permission_id) VALUES (3, 50), (3, 51)
+ON CONFLICT DO NOTHING;
\ No newline at end of file